@@ -72,25 +72,56 @@ func (m *Manager) IsDuplicate(eventKey string) bool {
 		return false
 	}
 
-	// Check if entry has expired
-	if time.Now().After(entry.ExpiresAt) {
-		// Entry expired, remove it
-		delete(m.cache, eventKey)
-		return false
+	// Expired entries are removed by the cleanup routine
+	return time.Now().Before(entry.ExpiresAt)
+}
+
+// MarkMessageSeen records a message ID for the given time-to-live and reports whether it
+// had already been seen. Check and insert happen atomically so concurrent deliveries of
+// the same message cannot both pass.
+func (m *Manager) MarkMessageSeen(messageID string, ttl time.Duration) bool {
+	key := messageKey(messageID)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if entry, exists := m.cache[key]; exists && now.Before(entry.ExpiresAt) {
+		return true
+	}
+
+	m.cache[key] = &Entry{
+		Key:       key,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
 	}
+	return false
+}
 
-	return true
+// ForgetMessage removes a message ID so a redelivery of the message is processed again
+func (m *Manager) ForgetMessage(messageID string) {
+	m.RemoveEvent(messageKey(messageID))
+}
+
+// messageKey returns the cache key under which a message ID is tracked
+func messageKey(messageID string) string {
+	return "message_id:" + messageID
 }
 
 // AddEvent adds an event to the cache to prevent duplicates
 func (m *Manager) AddEvent(eventKey string, eventData []byte) {
+	m.AddEventWithTTL(eventKey, eventData, m.ttl)
+}
+
+// AddEventWithTTL adds an event to the cache with a custom time-to-live
+func (m *Manager) AddEventWithTTL(eventKey string, eventData []byte, ttl time.Duration) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	entry := &Entry{
 		Key:       eventKey,
 		Data:      eventData,
-		ExpiresAt: time.Now().Add(m.ttl),
+		ExpiresAt: time.Now().Add(ttl),
 		CreatedAt: time.Now(),
 	}
 
@@ -101,6 +132,27 @@ func (m *Manager) AddEvent(eventKey string, eventData []byte) {
 		"expires_at", entry.ExpiresAt)
 }
 
+// GetEvent returns the data stored for a key if it exists and has not expired
+func (m *Manager) GetEvent(eventKey string) ([]byte, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	entry, exists := m.cache[eventKey]
+	if !exists || time.Now().After(entry.ExpiresAt) {
+		return nil, false
+	}
+
+	return entry.Data, true
+}
+
+// RemoveEvent removes an event from the cache
+func (m *Manager) RemoveEvent(eventKey string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.cache, eventKey)
+}
+
 // GenerateEventKey generates a unique key for an event
 func (m *Manager) GenerateEventKey(streamerID, eventID string, timestamp time.Time) string {
 	// Create a unique key based on streamer ID, event ID, and timestamp
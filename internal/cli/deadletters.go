@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/rmoriz/itsjustintv/internal/deadletter"
+	"github.com/rmoriz/itsjustintv/internal/webhook"
+	"github.com/spf13/cobra"
+)
+
+var deadLettersCmd = &cobra.Command{
+	Use:     "dead-letters",
+	Aliases: []string{"dlq"},
+	Short:   "Manage webhooks that could not be delivered",
+	Long: `Commands to list, inspect, replay and purge dead letters: webhook requests that
+exhausted their retries or were rejected permanently by their target.`,
+}
+
+var listDeadLettersCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List dead letters",
+	Args:  cobra.NoArgs,
+	RunE:  runListDeadLetters,
+}
+
+var inspectDeadLetterCmd = &cobra.Command{
+	Use:   "inspect <id>",
+	Short: "Show a dead letter with its payload and attempt history",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runInspectDeadLetter,
+}
+
+var replayDeadLetterCmd = &cobra.Command{
+	Use:   "replay <id>...",
+	Short: "Deliver dead letters again",
+	Long: `Deliver dead letters again. Delivered dead letters are removed; failed
+attempts are added to their history.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runReplayDeadLetters,
+}
+
+var purgeDeadLettersCmd = &cobra.Command{
+	Use:   "purge [id]...",
+	Short: "Delete dead letters",
+	Long:  `Delete the given dead letters, or all of them with --all.`,
+	RunE:  runPurgeDeadLetters,
+}
+
+var (
+	// Flags for the dead-letters commands
+	deadLettersPurgeAll bool
+)
+
+func init() {
+	rootCmd.AddCommand(deadLettersCmd)
+	deadLettersCmd.AddCommand(listDeadLettersCmd)
+	deadLettersCmd.AddCommand(inspectDeadLetterCmd)
+	deadLettersCmd.AddCommand(replayDeadLetterCmd)
+	deadLettersCmd.AddCommand(purgeDeadLettersCmd)
+
+	purgeDeadLettersCmd.Flags().BoolVar(&deadLettersPurgeAll, "all", false, "delete all dead letters")
+}
+
+// loadDeadLetterStore loads the configuration and opens its dead letter store
+func loadDeadLetterStore() (*config.Config, *deadletter.Store, error) {
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return cfg, deadletter.NewStore(cfg.Retry.DeadLetterFile), nil
+}
+
+func runListDeadLetters(cmd *cobra.Command, args []string) error {
+	_, store, err := loadDeadLetterStore()
+	if err != nil {
+		return err
+	}
+
+	entries, err := store.List()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No dead letters found.")
+		return nil
+	}
+
+	fmt.Printf("%-18s %-20s %-20s %-15s %-18s %-8s %s\n", "ID", "Dead At", "Streamer", "Target", "Reason", "Attempts", "Last Error")
+	fmt.Println("--------------------------------------------------------------------------------------------------------------------")
+	for _, entry := range entries {
+		req := entry.Request
+		lastError := ""
+		if len(req.History) > 0 {
+			lastError = req.History[len(req.History)-1].Error
+		}
+		fmt.Printf("%-18s %-20s %-20s %-15s %-18s %-8d %s\n",
+			entry.ID,
+			entry.DeadAt.Format("2006-01-02 15:04"),
+			req.StreamerKey,
+			req.TargetName,
+			entry.Reason,
+			len(req.History),
+			lastError)
+	}
+
+	return nil
+}
+
+func runInspectDeadLetter(cmd *cobra.Command, args []string) error {
+	_, store, err := loadDeadLetterStore()
+	if err != nil {
+		return err
+	}
+
+	entry, err := store.Get(args[0])
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entry)
+}
+
+func runReplayDeadLetters(cmd *cobra.Command, args []string) error {
+	cfg, store, err := loadDeadLetterStore()
+	if err != nil {
+		return err
+	}
+
+	logger := setupLogger(verbose)
+	dispatcher := webhook.NewDispatcher(cfg, logger)
+
+	failed := 0
+	for _, id := range args {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		result, err := store.Replay(ctx, dispatcher, id)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+
+		if result.Success {
+			fmt.Printf("%s: delivered (HTTP %d)\n", id, result.StatusCode)
+		} else {
+			failed++
+			fmt.Printf("%s: failed: %s\n", id, result.Error)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dead letters could not be delivered", failed, len(args))
+	}
+	return nil
+}
+
+func runPurgeDeadLetters(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 && !deadLettersPurgeAll {
+		return fmt.Errorf("specify dead letter IDs or --all")
+	}
+	if len(args) > 0 && deadLettersPurgeAll {
+		return fmt.Errorf("--all cannot be combined with dead letter IDs")
+	}
+
+	_, store, err := loadDeadLetterStore()
+	if err != nil {
+		return err
+	}
+
+	if deadLettersPurgeAll {
+		purged, err := store.Purge()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Purged %d dead letters.\n", purged)
+		return nil
+	}
+
+	for _, id := range args {
+		if err := store.Remove(id); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		fmt.Printf("%s: deleted\n", id)
+	}
+	return nil
+}
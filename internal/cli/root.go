@@ -2,11 +2,13 @@ package cli
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 
 	"github.com/rmoriz/itsjustintv/internal/config"
 	"github.com/rmoriz/itsjustintv/internal/server"
+	"github.com/rmoriz/itsjustintv/internal/webhook"
 	"github.com/spf13/cobra"
 )
 
@@ -81,6 +83,9 @@ func runServer(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to load configuration: %w", err)
 	}
+	if err := webhook.ValidatePayloadTemplates(cfg); err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
 
 	// Always print which config file was loaded
 	fmt.Printf("Loaded configuration from: %s\n", configPath)
@@ -145,6 +150,9 @@ var configValidateCmd = &cobra.Command{
 		if err != nil {
 			return fmt.Errorf("configuration validation failed: %w", err)
 		}
+		if err := webhook.ValidatePayloadTemplates(cfg); err != nil {
+			return fmt.Errorf("configuration validation failed: %w", err)
+		}
 
 		fmt.Printf("Configuration file '%s' is valid\n", configPath)
 		fmt.Printf("Found %d configured streamers\n", len(cfg.Streamers))
@@ -241,6 +249,11 @@ additional_tags = ["vip_streamer"]
 
 // setupLogger creates a structured logger
 func setupLogger(verbose bool) *slog.Logger {
+	return newLogger(os.Stdout, verbose)
+}
+
+// newLogger creates a structured logger writing to the given writer
+func newLogger(w io.Writer, verbose bool) *slog.Logger {
 	level := slog.LevelInfo
 	if verbose {
 		level = slog.LevelDebug
@@ -250,6 +263,6 @@ func setupLogger(verbose bool) *slog.Logger {
 		Level: level,
 	}
 
-	handler := slog.NewTextHandler(os.Stdout, opts)
+	handler := slog.NewTextHandler(w, opts)
 	return slog.New(handler)
 }
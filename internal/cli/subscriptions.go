@@ -2,7 +2,9 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/rmoriz/itsjustintv/internal/config"
@@ -26,14 +28,38 @@ var listSubscriptionsCmd = &cobra.Command{
 var syncSubscriptionsCmd = &cobra.Command{
 	Use:   "sync",
 	Short: "Sync subscriptions with configuration",
-	Long:  `Create missing subscriptions based on your configuration and remove unwanted ones.`,
-	RunE:  runSyncSubscriptions,
+	Long: `Create missing subscriptions based on your configuration and remove unwanted ones.
+
+Subscriptions for streamers or events no longer in the configuration are deleted.
+Subscriptions that failed verification, exceeded notification failures or point at
+an outdated callback URL are deleted and recreated.
+
+Use --dry-run to print the plan (create / keep / delete / recreate and the
+resulting subscription cost) without calling the Twitch API to change anything.
+Use --output json to get the plan in machine-readable form.`,
+	RunE: runSyncSubscriptions,
 }
 
+var (
+	// Flags for the list command
+	listStatus string
+	listType   string
+
+	// Flags for the sync command
+	syncDryRun bool
+	syncOutput string
+)
+
 func init() {
 	rootCmd.AddCommand(subscriptionsCmd)
 	subscriptionsCmd.AddCommand(listSubscriptionsCmd)
 	subscriptionsCmd.AddCommand(syncSubscriptionsCmd)
+
+	listSubscriptionsCmd.Flags().StringVar(&listStatus, "status", "", "only list subscriptions with this status (e.g. enabled)")
+	listSubscriptionsCmd.Flags().StringVar(&listType, "type", "", "only list subscriptions of this type (e.g. stream.online)")
+
+	syncSubscriptionsCmd.Flags().BoolVar(&syncDryRun, "dry-run", false, "print the sync plan without creating or deleting subscriptions")
+	syncSubscriptionsCmd.Flags().StringVarP(&syncOutput, "output", "o", "text", "plan output format (text or json)")
 }
 
 func runListSubscriptions(cmd *cobra.Command, args []string) error {
@@ -64,7 +90,10 @@ func runListSubscriptions(cmd *cobra.Command, args []string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	subs, err := subManager.GetSubscriptions(ctx)
+	subs, err := subManager.GetSubscriptions(ctx, twitch.SubscriptionFilter{
+		Status: listStatus,
+		Type:   listType,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to get subscriptions: %w", err)
 	}
@@ -72,6 +101,9 @@ func runListSubscriptions(cmd *cobra.Command, args []string) error {
 	// Display results
 	fmt.Printf("EventSub Subscriptions Summary:\n")
 	fmt.Printf("Total subscriptions: %d\n", subs.Total)
+	if listStatus != "" || listType != "" {
+		fmt.Printf("Matching subscriptions: %d\n", len(subs.Data))
+	}
 	fmt.Printf("Total cost: %d\n", subs.TotalCost)
 	fmt.Printf("Max total cost: %d\n\n", subs.MaxTotalCost)
 
@@ -101,6 +133,10 @@ func runListSubscriptions(cmd *cobra.Command, args []string) error {
 }
 
 func runSyncSubscriptions(cmd *cobra.Command, args []string) error {
+	if syncOutput != "text" && syncOutput != "json" {
+		return fmt.Errorf("invalid output format %q: must be text or json", syncOutput)
+	}
+
 	// Load configuration
 	cfg, err := config.LoadConfig(configFile)
 	if err != nil {
@@ -112,8 +148,11 @@ func runSyncSubscriptions(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	// Setup logger
+	// Setup logger, keeping stdout clean for JSON output
 	logger := setupLogger(verbose)
+	if syncOutput == "json" {
+		logger = newLogger(os.Stderr, verbose)
+	}
 
 	// Create Twitch client
 	client := twitch.NewClient(cfg, logger)
@@ -134,15 +173,96 @@ func runSyncSubscriptions(cmd *cobra.Command, args []string) error {
 
 	// Create subscription manager
 	subManager := twitch.NewSubscriptionManager(cfg, logger, client)
+	if err := subManager.LoadWebhookSecretFingerprint(twitch.WebhookSecretStateFile(cfg)); err != nil {
+		logger.Warn("Failed to load webhook secret state, subscriptions are not recreated after a secret rotation", "error", err)
+	}
 
-	// Sync subscriptions
+	// Plan and sync subscriptions
 	syncCtx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
-	if err := subManager.SyncSubscriptions(syncCtx); err != nil {
+	plan, err := subManager.PlanSync(syncCtx)
+	if err != nil {
+		return fmt.Errorf("failed to plan subscription sync: %w", err)
+	}
+
+	if syncOutput == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(plan); err != nil {
+			return fmt.Errorf("failed to encode sync plan: %w", err)
+		}
+	} else {
+		printSyncPlan(plan)
+	}
+
+	if syncDryRun {
+		if syncOutput == "text" {
+			fmt.Println("\nDry run: no subscriptions were changed.")
+		}
+		return nil
+	}
+
+	if err := subManager.ApplySyncPlan(syncCtx, plan); err != nil {
 		return fmt.Errorf("failed to sync subscriptions: %w", err)
 	}
 
-	fmt.Println("Subscription sync completed successfully!")
+	if syncOutput == "text" {
+		fmt.Println("Subscription sync completed successfully!")
+	}
 	return nil
 }
+
+// printSyncPlan prints a human-readable subscription sync plan
+func printSyncPlan(plan *twitch.SyncPlan) {
+	fmt.Printf("EventSub Subscription Sync Plan:\n\n")
+
+	if len(plan.Actions) == 0 {
+		fmt.Println("No subscriptions configured or present.")
+	} else {
+		fmt.Printf("%-9s %-15s %-4s %-15s %-20s %-12s %5s  %s\n", "Action", "Type", "Ver", "Broadcaster ID", "Streamer", "Subscription", "Cost", "Reason")
+		fmt.Println("------------------------------------------------------------------------------------------------------------")
+
+		for _, action := range plan.Actions {
+			broadcasterID := "N/A"
+			if bid, ok := action.Condition["broadcaster_user_id"].(string); ok {
+				broadcasterID = bid
+			}
+
+			streamerKey := action.StreamerKey
+			if streamerKey == "" {
+				streamerKey = "-"
+			}
+
+			subscriptionID := "-"
+			if action.SubscriptionID != "" {
+				subscriptionID = action.SubscriptionID
+				if len(subscriptionID) > 8 {
+					subscriptionID = subscriptionID[:8] + "..."
+				}
+			}
+
+			fmt.Printf("%-9s %-15s %-4s %-15s %-20s %-12s %+5d  %s\n",
+				action.Action,
+				action.Type,
+				action.Version,
+				broadcasterID,
+				streamerKey,
+				subscriptionID,
+				action.CostDelta,
+				action.Reason)
+		}
+	}
+
+	fmt.Printf("\nSummary: %d to create, %d to keep, %d to delete, %d to recreate\n",
+		plan.Summary[twitch.SyncActionCreate],
+		plan.Summary[twitch.SyncActionKeep],
+		plan.Summary[twitch.SyncActionDelete],
+		plan.Summary[twitch.SyncActionRecreate])
+	fmt.Printf("Total cost: %d -> %d (%+d), max total cost: %d\n",
+		plan.TotalCost, plan.ProjectedTotalCost, plan.CostDelta, plan.MaxTotalCost)
+
+	if plan.ExceedsMaxCost {
+		fmt.Println("WARNING: the projected total cost exceeds the maximum total cost; some subscriptions will fail to be created.")
+	}
+}
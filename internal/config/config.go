@@ -5,20 +5,25 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
+
+	"github.com/rmoriz/itsjustintv/pkg/standardwebhooks"
 )
 
 // Config represents the main configuration structure
 type Config struct {
-	Server        ServerConfig              `toml:"server"`
-	Twitch        TwitchConfig              `toml:"twitch"`
-	Streamers     map[string]StreamerConfig `toml:"streamers"`
-	Retry         RetryConfig               `toml:"retry"`
-	Output        OutputConfig              `toml:"output"`
-	Telemetry     TelemetryConfig           `toml:"telemetry"`
-	GlobalWebhook GlobalWebhookConfig       `toml:"global_webhook"`
+	Server         ServerConfig              `toml:"server"`
+	Twitch         TwitchConfig              `toml:"twitch"`
+	Streamers      map[string]StreamerConfig `toml:"streamers"`
+	Retry          RetryConfig               `toml:"retry"`
+	CircuitBreaker CircuitBreakerConfig      `toml:"circuit_breaker"`
+	Output         OutputConfig              `toml:"output"`
+	Telemetry      TelemetryConfig           `toml:"telemetry"`
+	GlobalWebhook  GlobalWebhookConfig       `toml:"global_webhook"`
+	AlertWebhook   AlertWebhookConfig        `toml:"alert_webhook"`
 
 	// Internal fields (not loaded from TOML)
 	configPath string
@@ -29,6 +34,7 @@ type ServerConfig struct {
 	ListenAddr     string `toml:"listen_addr"`
 	Port           int    `toml:"port"`
 	ExternalDomain string `toml:"external_domain"`
+	AdminToken     string `toml:"admin_token"` // enables the /admin endpoints; accepts "env:NAME" and "file:PATH"
 	TLS            struct {
 		Enabled bool     `toml:"enabled"`
 		Domains []string `toml:"domains"`
@@ -38,13 +44,26 @@ type ServerConfig struct {
 
 // TwitchConfig holds Twitch API configuration
 type TwitchConfig struct {
-	ClientID           string `toml:"client_id"`
-	ClientSecret       string `toml:"client_secret"`
-	WebhookSecret      string `toml:"webhook_secret"`
-	TokenFile          string `toml:"token_file"`
-	IncomingWebhookURL string `toml:"incoming_webhook_url"`
+	ClientID                    string    `toml:"client_id"`
+	ClientSecret                string    `toml:"client_secret"`
+	WebhookSecret               string    `toml:"webhook_secret"`
+	PreviousWebhookSecrets      []string  `toml:"previous_webhook_secrets"`       // still accepted during secret rotation...
+	PreviousWebhookSecretsUntil time.Time `toml:"previous_webhook_secrets_until"` // ...until then
+	TokenFile                   string    `toml:"token_file"`
+	IncomingWebhookURL          string    `toml:"incoming_webhook_url"`
+	PreviousCallbackURLs        []string  `toml:"previous_callback_urls"` // subscriptions pointing here are ours to delete
+	Transport                   string    `toml:"transport"`              // "webhook" or "websocket"
+	WebSocketURL                string    `toml:"websocket_url"`          // EventSub WebSocket endpoint
+	UserAccessToken             string    `toml:"user_access_token"`      // required by the websocket transport...
+	UserRefreshToken            string    `toml:"user_refresh_token"`     // ...unless it can be obtained with a refresh token
 }
 
+// EventSub transports
+const (
+	TransportWebhook   = "webhook"
+	TransportWebSocket = "websocket"
+)
+
 // StreamerConfig holds individual streamer configuration
 type StreamerConfig struct {
 	UserID               string   `toml:"user_id"`
@@ -55,15 +74,155 @@ type StreamerConfig struct {
 	TargetWebhookSecret  string   `toml:"target_webhook_secret"`
 	TargetWebhookHeader  string   `toml:"target_webhook_header"`
 	TargetWebhookHashing string   `toml:"target_webhook_hashing"`
+	Events               []string `toml:"events"`
+	Disabled             bool     `toml:"disabled"`
+	SkipGlobalWebhook    bool     `toml:"skip_global_webhook"` // never deliver to the global webhook
+
+	Targets []WebhookTargetConfig `toml:"targets"`
+}
+
+// WebhookTargetConfig holds a named outgoing webhook target
+type WebhookTargetConfig struct {
+	Name    string `toml:"name"`
+	URL     string `toml:"url"`
+	Enabled *bool  `toml:"enabled"` // defaults to true
+	Secret  string `toml:"secret"`
+	Header  string `toml:"header"`
+	Hashing string `toml:"hashing"`
+
+	SigningScheme   string   `toml:"signing_scheme"`   // "hmac" (default) or "standard-webhooks"
+	PreviousSecrets []string `toml:"previous_secrets"` // standard-webhooks: also signed with while receivers rotate secrets
+
+	// HTTP authentication and static headers; secret settings accept "env:NAME" and "file:PATH"
+	BearerToken       string            `toml:"bearer_token"`
+	BasicAuthUsername string            `toml:"basic_auth_username"`
+	BasicAuthPassword string            `toml:"basic_auth_password"`
+	Headers           map[string]string `toml:"headers"`
+
+	Template    string `toml:"template"`     // Go text/template for the request body; default is the JSON payload
+	ContentType string `toml:"content_type"` // defaults to application/json
+
+	Format        string `toml:"format"`          // "json" (default), "discord", "slack", "teams", "matrix", "ntfy", "telegram", "mastodon", "bluesky" or "cloudevents"
+	EditOnOffline bool   `toml:"edit_on_offline"` // discord: edit the go-live message on stream.offline
+	RoomID        string `toml:"room_id"`         // matrix: room to post to; url is the homeserver
+	AccessToken   string `toml:"access_token"`    // matrix, ntfy: access token of the posting user
+	Topic         string `toml:"topic"`           // ntfy: topic to publish to; url is the ntfy server
+	ChatID        string `toml:"chat_id"`         // telegram: chat to send to; url is the Bot API server
+	BotToken      string `toml:"bot_token"`       // telegram: token of the sending bot
+
+	// Social posts, rendered from the template when one is set; url is the Mastodon instance or Bluesky PDS
+	DeleteOnOffline bool   `toml:"delete_on_offline"` // mastodon, bluesky: delete the go-live post on stream.offline
+	Visibility      string `toml:"visibility"`        // mastodon: status visibility, defaults to public
+	Handle          string `toml:"handle"`            // bluesky: account handle or DID
+	AppPassword     string `toml:"app_password"`      // bluesky: app password of the account
+
+	CloudEventsMode string `toml:"cloudevents_mode"` // cloudevents: "structured" (default) or "binary"
+}
+
+// Target formats
+const (
+	TargetFormatJSON     = "json"
+	TargetFormatDiscord  = "discord"
+	TargetFormatSlack    = "slack"
+	TargetFormatTeams    = "teams"
+	TargetFormatMatrix   = "matrix"
+	TargetFormatNtfy     = "ntfy"
+	TargetFormatTelegram = "telegram"
+	TargetFormatMastodon = "mastodon"
+	TargetFormatBluesky  = "bluesky"
+
+	TargetFormatCloudEvents = "cloudevents"
+)
+
+// Signing schemes of outgoing webhooks
+const (
+	SigningSchemeHMAC             = "hmac"              // signature of the body in a single header
+	SigningSchemeStandardWebhooks = "standard-webhooks" // webhook-id, webhook-timestamp and webhook-signature headers
+)
+
+// CloudEvents HTTP content modes
+const (
+	CloudEventsModeStructured = "structured"
+	CloudEventsModeBinary     = "binary"
+)
+
+// SupportedTargetFormats lists the body formats a webhook target can use
+var SupportedTargetFormats = []string{TargetFormatJSON, TargetFormatDiscord, TargetFormatSlack, TargetFormatTeams, TargetFormatMatrix, TargetFormatNtfy, TargetFormatTelegram, TargetFormatMastodon, TargetFormatBluesky, TargetFormatCloudEvents}
+
+// IsEnabled reports whether the target should receive deliveries
+func (t WebhookTargetConfig) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// DefaultTargetName is the name of the target built from target_webhook_url
+const DefaultTargetName = "default"
+
+// GetTargets returns the enabled webhook targets of the streamer. A target_webhook_url is
+// delivered to as the target named "default", ahead of the configured targets.
+func (s StreamerConfig) GetTargets() []WebhookTargetConfig {
+	targets := make([]WebhookTargetConfig, 0, len(s.Targets)+1)
+	if s.TargetWebhookURL != "" {
+		targets = append(targets, WebhookTargetConfig{
+			Name:    DefaultTargetName,
+			URL:     s.TargetWebhookURL,
+			Secret:  s.TargetWebhookSecret,
+			Header:  s.TargetWebhookHeader,
+			Hashing: s.TargetWebhookHashing,
+		})
+	}
+
+	for _, target := range s.Targets {
+		if target.IsEnabled() {
+			targets = append(targets, target)
+		}
+	}
+
+	return targets
+}
+
+// SupportedEventTypes lists the EventSub subscription types a streamer can opt into
+var SupportedEventTypes = []string{"stream.online", "stream.offline", "channel.update"}
+
+// DefaultStreamerEvents are subscribed for streamers that do not configure events
+var DefaultStreamerEvents = []string{"stream.online", "stream.offline"}
+
+// GetEvents returns the EventSub subscription types configured for the streamer
+func (s StreamerConfig) GetEvents() []string {
+	if len(s.Events) == 0 {
+		return DefaultStreamerEvents
+	}
+	return s.Events
 }
 
 // RetryConfig holds retry mechanism configuration
 type RetryConfig struct {
-	MaxAttempts   int           `toml:"max_attempts"`
-	InitialDelay  time.Duration `toml:"initial_delay"`
-	MaxDelay      time.Duration `toml:"max_delay"`
-	BackoffFactor float64       `toml:"backoff_factor"`
-	StateFile     string        `toml:"state_file"`
+	MaxAttempts    int           `toml:"max_attempts"`
+	InitialDelay   time.Duration `toml:"initial_delay"`
+	MaxDelay       time.Duration `toml:"max_delay"`
+	BackoffFactor  float64       `toml:"backoff_factor"`
+	StateFile      string        `toml:"state_file"`
+	DeadLetterFile string        `toml:"dead_letter_file"` // requests that exhausted their retries or failed permanently
+	Concurrency    int           `toml:"concurrency"`      // maximum number of retries in flight
+	Jitter         float64       `toml:"jitter"`           // randomizes each backoff delay by up to this fraction
+}
+
+// DefaultRetryConcurrency is the number of retries in flight when retry.concurrency is not set
+const DefaultRetryConcurrency = 4
+
+// GetConcurrency returns the maximum number of retries in flight
+func (r RetryConfig) GetConcurrency() int {
+	if r.Concurrency <= 0 {
+		return DefaultRetryConcurrency
+	}
+	return r.Concurrency
+}
+
+// CircuitBreakerConfig holds the settings of the per-host circuit breaker of webhook targets
+type CircuitBreakerConfig struct {
+	Enabled          bool          `toml:"enabled"`
+	FailureThreshold int           `toml:"failure_threshold"` // consecutive failures that open the circuit
+	OpenTimeout      time.Duration `toml:"open_timeout"`      // how long requests are deferred before a probe
+	SuccessThreshold int           `toml:"success_threshold"` // successful probes that close the circuit again
 }
 
 // OutputConfig holds file output configuration
@@ -82,8 +241,78 @@ type TelemetryConfig struct {
 }
 
 // GlobalWebhookConfig holds global webhook configuration
-// This provides a fallback webhook URL when streamer-specific URLs are not provided
+// In fallback mode it is used when a streamer has no targets; in always mode it receives every event
 type GlobalWebhookConfig struct {
+	Enabled              bool   `toml:"enabled"`
+	URL                  string `toml:"url"`
+	Mode                 string `toml:"mode"` // "fallback" or "always"
+	TargetWebhookSecret  string `toml:"target_webhook_secret"`
+	TargetWebhookHeader  string `toml:"target_webhook_header"`
+	TargetWebhookHashing string `toml:"target_webhook_hashing"`
+	Template             string `toml:"template"`
+	ContentType          string `toml:"content_type"`
+
+	SigningScheme   string   `toml:"signing_scheme"`
+	PreviousSecrets []string `toml:"previous_secrets"`
+
+	BearerToken       string            `toml:"bearer_token"`
+	BasicAuthUsername string            `toml:"basic_auth_username"`
+	BasicAuthPassword string            `toml:"basic_auth_password"`
+	Headers           map[string]string `toml:"headers"`
+}
+
+// Global webhook modes
+const (
+	GlobalWebhookModeFallback = "fallback"
+	GlobalWebhookModeAlways   = "always"
+)
+
+// GlobalTargetName is the target name deliveries to the global webhook are tracked under
+const GlobalTargetName = "global"
+
+// Target returns the global webhook as a webhook target
+func (g GlobalWebhookConfig) Target() WebhookTargetConfig {
+	return WebhookTargetConfig{
+		Name:        GlobalTargetName,
+		URL:         g.URL,
+		Secret:      g.TargetWebhookSecret,
+		Header:      g.TargetWebhookHeader,
+		Hashing:     g.TargetWebhookHashing,
+		Template:    g.Template,
+		ContentType: g.ContentType,
+
+		SigningScheme:   g.SigningScheme,
+		PreviousSecrets: g.PreviousSecrets,
+
+		BearerToken:       g.BearerToken,
+		BasicAuthUsername: g.BasicAuthUsername,
+		BasicAuthPassword: g.BasicAuthPassword,
+		Headers:           g.Headers,
+	}
+}
+
+// FindTarget returns the enabled target of a streamer with the given name, or the global webhook
+// for GlobalTargetName. It reports false when the target is no longer configured.
+func (c *Config) FindTarget(streamerKey, targetName string) (WebhookTargetConfig, bool) {
+	if targetName == GlobalTargetName {
+		return c.GlobalWebhook.Target(), c.GlobalWebhook.Enabled && c.GlobalWebhook.URL != ""
+	}
+
+	streamer, ok := c.Streamers[streamerKey]
+	if !ok {
+		return WebhookTargetConfig{}, false
+	}
+	for _, target := range streamer.GetTargets() {
+		if target.Name == targetName {
+			return target, true
+		}
+	}
+	return WebhookTargetConfig{}, false
+}
+
+// AlertWebhookConfig holds the operator alert webhook configuration
+// Alerts report operational problems such as revoked EventSub subscriptions
+type AlertWebhookConfig struct {
 	Enabled              bool   `toml:"enabled"`
 	URL                  string `toml:"url"`
 	TargetWebhookSecret  string `toml:"target_webhook_secret"`
@@ -111,13 +340,24 @@ func DefaultConfig() *Config {
 		Twitch: TwitchConfig{
 			TokenFile:          "data/tokens.json",
 			IncomingWebhookURL: "",
+			Transport:          TransportWebhook,
+			WebSocketURL:       "wss://eventsub.wss.twitch.tv/ws",
 		},
 		Retry: RetryConfig{
-			MaxAttempts:   3,
-			InitialDelay:  time.Second,
-			MaxDelay:      time.Minute * 5,
-			BackoffFactor: 2.0,
-			StateFile:     "data/retry_state.json",
+			MaxAttempts:    3,
+			InitialDelay:   time.Second,
+			MaxDelay:       time.Minute * 5,
+			BackoffFactor:  2.0,
+			StateFile:      "data/retry_state.json",
+			DeadLetterFile: "data/dead_letters.json",
+			Concurrency:    DefaultRetryConcurrency,
+			Jitter:         0.2,
+		},
+		CircuitBreaker: CircuitBreakerConfig{
+			Enabled:          true,
+			FailureThreshold: 5,
+			OpenTimeout:      time.Minute,
+			SuccessThreshold: 1,
 		},
 		Output: OutputConfig{
 			Enabled:  true,
@@ -130,6 +370,14 @@ func DefaultConfig() *Config {
 			ServiceVersion: "0.3.0",
 		},
 		GlobalWebhook: GlobalWebhookConfig{
+			Enabled:              false,
+			URL:                  "",
+			Mode:                 GlobalWebhookModeFallback,
+			TargetWebhookSecret:  "",
+			TargetWebhookHeader:  "X-Hub-Signature-256",
+			TargetWebhookHashing: "SHA-256",
+		},
+		AlertWebhook: AlertWebhookConfig{
 			Enabled:              false,
 			URL:                  "",
 			TargetWebhookSecret:  "",
@@ -160,6 +408,11 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to apply environment overrides: %w", err)
 	}
 
+	// Resolve secrets referenced from the environment or files
+	if err := resolveSecrets(config); err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+
 	// Validate configuration
 	if err := validateConfig(config); err != nil {
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
@@ -199,7 +452,7 @@ func ResolveStreamerUserIDs(ctx context.Context, config *Config, twitchClient Tw
 		streamer.UserID = userInfo.GetID()
 		config.Streamers[key] = streamer
 
-		fmt.Printf("Resolved user ID for streamer '%s': login='%s' -> user_id='%s'\n", key, streamer.Login, userInfo.GetID())
+		fmt.Fprintf(os.Stderr, "Resolved user ID for streamer '%s': login='%s' -> user_id='%s'\n", key, streamer.Login, userInfo.GetID())
 	}
 
 	return nil
@@ -222,6 +475,9 @@ func applyEnvOverrides(config *Config) error {
 	if val := os.Getenv("ITSJUSTINTV_SERVER_LISTEN_ADDR"); val != "" {
 		config.Server.ListenAddr = val
 	}
+	if val := os.Getenv("ITSJUSTINTV_SERVER_ADMIN_TOKEN"); val != "" {
+		config.Server.AdminToken = val
+	}
 	if val := os.Getenv("ITSJUSTINTV_SERVER_PORT"); val != "" {
 		var port int
 		if _, err := fmt.Sscanf(val, "%d", &port); err == nil {
@@ -239,6 +495,33 @@ func applyEnvOverrides(config *Config) error {
 	if val := os.Getenv("ITSJUSTINTV_TWITCH_WEBHOOK_SECRET"); val != "" {
 		config.Twitch.WebhookSecret = val
 	}
+	if val := os.Getenv("ITSJUSTINTV_TWITCH_PREVIOUS_WEBHOOK_SECRETS"); val != "" {
+		config.Twitch.PreviousWebhookSecrets = nil
+		for _, secret := range strings.Split(val, ",") {
+			config.Twitch.PreviousWebhookSecrets = append(config.Twitch.PreviousWebhookSecrets, strings.TrimSpace(secret))
+		}
+	}
+	if val := os.Getenv("ITSJUSTINTV_TWITCH_PREVIOUS_WEBHOOK_SECRETS_UNTIL"); val != "" {
+		until, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return fmt.Errorf("invalid ITSJUSTINTV_TWITCH_PREVIOUS_WEBHOOK_SECRETS_UNTIL: %w", err)
+		}
+		config.Twitch.PreviousWebhookSecretsUntil = until
+	}
+	if val := os.Getenv("ITSJUSTINTV_TWITCH_TRANSPORT"); val != "" {
+		config.Twitch.Transport = val
+	}
+	if val := os.Getenv("ITSJUSTINTV_TWITCH_USER_ACCESS_TOKEN"); val != "" {
+		config.Twitch.UserAccessToken = val
+	}
+	if val := os.Getenv("ITSJUSTINTV_TWITCH_USER_REFRESH_TOKEN"); val != "" {
+		config.Twitch.UserRefreshToken = val
+	}
+
+	// Global webhook configuration
+	if val := os.Getenv("ITSJUSTINTV_GLOBAL_WEBHOOK_MODE"); val != "" {
+		config.GlobalWebhook.Mode = val
+	}
 
 	// TLS configuration
 	if val := os.Getenv("ITSJUSTINTV_TLS_ENABLED"); val == "true" {
@@ -257,8 +540,23 @@ func validateConfig(config *Config) error {
 	if config.Twitch.ClientSecret == "" {
 		return fmt.Errorf("twitch.client_secret is required")
 	}
-	if config.Twitch.WebhookSecret == "" {
-		return fmt.Errorf("twitch.webhook_secret is required")
+	switch config.Twitch.Transport {
+	case TransportWebhook:
+		if config.Twitch.WebhookSecret == "" {
+			return fmt.Errorf("twitch.webhook_secret is required")
+		}
+	case TransportWebSocket:
+		if config.Twitch.UserAccessToken == "" && config.Twitch.UserRefreshToken == "" {
+			return fmt.Errorf("twitch.user_access_token is required when twitch.transport is websocket, unless twitch.user_refresh_token is set")
+		}
+		if config.Twitch.WebSocketURL == "" {
+			return fmt.Errorf("twitch.websocket_url is required when twitch.transport is websocket")
+		}
+	default:
+		return fmt.Errorf("twitch.transport must be %q or %q", TransportWebhook, TransportWebSocket)
+	}
+	if len(config.Twitch.PreviousWebhookSecrets) > 0 && config.Twitch.PreviousWebhookSecretsUntil.IsZero() {
+		return fmt.Errorf("twitch.previous_webhook_secrets_until is required with twitch.previous_webhook_secrets, so old secrets are not accepted forever")
 	}
 
 	// Validate server configuration
@@ -278,6 +576,25 @@ func validateConfig(config *Config) error {
 	if config.Retry.BackoffFactor <= 1.0 {
 		return fmt.Errorf("retry.backoff_factor must be greater than 1.0")
 	}
+	if config.Retry.Concurrency < 0 {
+		return fmt.Errorf("retry.concurrency must not be negative")
+	}
+	if config.Retry.Jitter < 0 || config.Retry.Jitter >= 1 {
+		return fmt.Errorf("retry.jitter must be at least 0 and less than 1")
+	}
+
+	// Validate circuit breaker configuration
+	if config.CircuitBreaker.Enabled {
+		if config.CircuitBreaker.FailureThreshold <= 0 {
+			return fmt.Errorf("circuit_breaker.failure_threshold must be greater than 0")
+		}
+		if config.CircuitBreaker.OpenTimeout <= 0 {
+			return fmt.Errorf("circuit_breaker.open_timeout must be greater than 0")
+		}
+		if config.CircuitBreaker.SuccessThreshold <= 0 {
+			return fmt.Errorf("circuit_breaker.success_threshold must be greater than 0")
+		}
+	}
 
 	// Validate global webhook configuration
 	if config.GlobalWebhook.Enabled {
@@ -289,11 +606,56 @@ func validateConfig(config *Config) error {
 			return fmt.Errorf("global_webhook.url must be a valid URL")
 		}
 	}
+	if config.GlobalWebhook.Template != "" {
+		if _, err := ParsePayloadTemplate(GlobalTargetName, config.GlobalWebhook.Template); err != nil {
+			return fmt.Errorf("global_webhook.template is invalid: %w", err)
+		}
+	}
+	switch config.GlobalWebhook.Mode {
+	case "", GlobalWebhookModeFallback, GlobalWebhookModeAlways:
+	default:
+		return fmt.Errorf("global_webhook.mode must be %q or %q", GlobalWebhookModeFallback, GlobalWebhookModeAlways)
+	}
+	if err := validateSigning("global_webhook", config.GlobalWebhook.SigningScheme, config.GlobalWebhook.TargetWebhookSecret, config.GlobalWebhook.PreviousSecrets); err != nil {
+		return err
+	}
+	if err := validateHTTPAuth("global_webhook", config.GlobalWebhook.BearerToken, config.GlobalWebhook.BasicAuthUsername, config.GlobalWebhook.BasicAuthPassword, config.GlobalWebhook.Headers); err != nil {
+		return err
+	}
+
+	// Validate alert webhook configuration
+	if config.AlertWebhook.Enabled {
+		if config.AlertWebhook.URL == "" {
+			return fmt.Errorf("alert_webhook.url is required when alert_webhook.enabled is true")
+		}
+		if !isValidURL(config.AlertWebhook.URL) {
+			return fmt.Errorf("alert_webhook.url must be a valid URL")
+		}
+	}
+
+	// Validate streamer configuration
+	for key, streamer := range config.Streamers {
+		seen := make(map[string]bool)
+		for _, event := range streamer.Events {
+			if !isSupportedEventType(event) {
+				return fmt.Errorf("streamers.%s.events contains unsupported event type %q", key, event)
+			}
+			if seen[event] {
+				return fmt.Errorf("streamers.%s.events contains duplicate event type %q", key, event)
+			}
+			seen[event] = true
+		}
+
+		if err := validateTargets(key, streamer); err != nil {
+			return err
+		}
+	}
 
 	// Ensure data directories exist
 	dataDirs := []string{
 		filepath.Dir(config.Twitch.TokenFile),
 		filepath.Dir(config.Retry.StateFile),
+		filepath.Dir(config.Retry.DeadLetterFile),
 		filepath.Dir(config.Output.FilePath),
 		config.Server.TLS.CertDir,
 		"data/image_cache",
@@ -308,6 +670,200 @@ func validateConfig(config *Config) error {
 	return nil
 }
 
+// validateTargets validates the named webhook targets of a streamer
+func validateTargets(streamerKey string, streamer StreamerConfig) error {
+	names := make(map[string]bool)
+	if streamer.TargetWebhookURL != "" {
+		names[DefaultTargetName] = true
+	}
+
+	for i, target := range streamer.Targets {
+		if target.Name == "" {
+			return fmt.Errorf("streamers.%s.targets[%d].name is required", streamerKey, i)
+		}
+		if target.Name == GlobalTargetName {
+			return fmt.Errorf("streamers.%s.targets uses reserved target name %q", streamerKey, GlobalTargetName)
+		}
+		if names[target.Name] {
+			return fmt.Errorf("streamers.%s.targets contains duplicate target name %q", streamerKey, target.Name)
+		}
+		names[target.Name] = true
+
+		if !isValidURL(target.URL) {
+			return fmt.Errorf("streamers.%s.targets.%s.url must be a valid URL", streamerKey, target.Name)
+		}
+		if target.Template != "" {
+			if _, err := ParsePayloadTemplate(target.Name, target.Template); err != nil {
+				return fmt.Errorf("streamers.%s.targets.%s.template is invalid: %w", streamerKey, target.Name, err)
+			}
+		}
+		if err := validateTargetFormat(streamerKey, target); err != nil {
+			return err
+		}
+		prefix := fmt.Sprintf("streamers.%s.targets.%s", streamerKey, target.Name)
+		if err := validateSigning(prefix, target.SigningScheme, target.Secret, target.PreviousSecrets); err != nil {
+			return err
+		}
+		if err := validateHTTPAuth(prefix, target.BearerToken, target.BasicAuthUsername, target.BasicAuthPassword, target.Headers); err != nil {
+			return err
+		}
+		if target.BearerToken != "" || target.BasicAuthUsername != "" {
+			// These formats authorize requests with their own credentials
+			if target.Format == TargetFormatMatrix || target.Format == TargetFormatMastodon || target.Format == TargetFormatBluesky ||
+				(target.Format == TargetFormatNtfy && target.AccessToken != "") {
+				return fmt.Errorf("%s cannot combine bearer_token or basic auth with the credentials of format %q", prefix, target.Format)
+			}
+		}
+		if IsSocialTargetFormat(target.Format) && (len(target.Headers) > 0 || target.Secret != "") {
+			// Social posts are API calls authorized with the account's own credentials
+			return fmt.Errorf("%s cannot combine headers or a signing secret with format %q", prefix, target.Format)
+		}
+	}
+
+	return nil
+}
+
+// validateTargetFormat validates the body format settings of a webhook target
+func validateTargetFormat(streamerKey string, target WebhookTargetConfig) error {
+	format := target.Format
+	if format == "" {
+		format = TargetFormatJSON
+	}
+
+	supported := false
+	for _, candidate := range SupportedTargetFormats {
+		if format == candidate {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return fmt.Errorf("streamers.%s.targets.%s.format %q is not supported", streamerKey, target.Name, target.Format)
+	}
+
+	// Social posts render their text from the template; the other formats build the whole body
+	if target.Template != "" && format != TargetFormatJSON && !IsSocialTargetFormat(format) {
+		return fmt.Errorf("streamers.%s.targets.%s cannot combine a template with format %q", streamerKey, target.Name, format)
+	}
+	if target.EditOnOffline && format != TargetFormatDiscord {
+		return fmt.Errorf("streamers.%s.targets.%s.edit_on_offline requires format %q", streamerKey, target.Name, TargetFormatDiscord)
+	}
+	if target.DeleteOnOffline && !IsSocialTargetFormat(format) {
+		return fmt.Errorf("streamers.%s.targets.%s.delete_on_offline requires format %q or %q", streamerKey, target.Name, TargetFormatMastodon, TargetFormatBluesky)
+	}
+
+	if target.CloudEventsMode != "" {
+		if format != TargetFormatCloudEvents {
+			return fmt.Errorf("streamers.%s.targets.%s.cloudevents_mode requires format %q", streamerKey, target.Name, TargetFormatCloudEvents)
+		}
+		if target.CloudEventsMode != CloudEventsModeStructured && target.CloudEventsMode != CloudEventsModeBinary {
+			return fmt.Errorf("streamers.%s.targets.%s.cloudevents_mode must be %q or %q", streamerKey, target.Name, CloudEventsModeStructured, CloudEventsModeBinary)
+		}
+	}
+
+	required := func(value, setting string) error {
+		if value == "" {
+			return fmt.Errorf("streamers.%s.targets.%s.%s is required for format %q", streamerKey, target.Name, setting, format)
+		}
+		return nil
+	}
+	switch format {
+	case TargetFormatMatrix:
+		if err := required(target.RoomID, "room_id"); err != nil {
+			return err
+		}
+		return required(target.AccessToken, "access_token")
+	case TargetFormatNtfy:
+		return required(target.Topic, "topic")
+	case TargetFormatTelegram:
+		if err := required(target.ChatID, "chat_id"); err != nil {
+			return err
+		}
+		return required(target.BotToken, "bot_token")
+	case TargetFormatMastodon:
+		return required(target.AccessToken, "access_token")
+	case TargetFormatBluesky:
+		if err := required(target.Handle, "handle"); err != nil {
+			return err
+		}
+		return required(target.AppPassword, "app_password")
+	}
+
+	return nil
+}
+
+// validateSigning validates the signing scheme and secrets of an outgoing webhook
+func validateSigning(prefix, scheme, secret string, previousSecrets []string) error {
+	switch scheme {
+	case "", SigningSchemeHMAC:
+		if len(previousSecrets) > 0 {
+			return fmt.Errorf("%s.previous_secrets requires signing_scheme %q", prefix, SigningSchemeStandardWebhooks)
+		}
+		return nil
+	case SigningSchemeStandardWebhooks:
+	default:
+		return fmt.Errorf("%s.signing_scheme must be %q or %q", prefix, SigningSchemeHMAC, SigningSchemeStandardWebhooks)
+	}
+
+	if secret == "" {
+		return fmt.Errorf("%s requires a secret for signing_scheme %q", prefix, SigningSchemeStandardWebhooks)
+	}
+	for _, candidate := range append([]string{secret}, previousSecrets...) {
+		if _, err := standardwebhooks.DecodeSecret(candidate); err != nil {
+			return fmt.Errorf("%s has an invalid secret: %w", prefix, err)
+		}
+	}
+	return nil
+}
+
+// validateHTTPAuth validates the HTTP authentication and static headers of an outgoing webhook
+func validateHTTPAuth(prefix, bearerToken, basicAuthUsername, basicAuthPassword string, headers map[string]string) error {
+	if bearerToken != "" && basicAuthUsername != "" {
+		return fmt.Errorf("%s cannot combine bearer_token and basic_auth_username", prefix)
+	}
+	if basicAuthPassword != "" && basicAuthUsername == "" {
+		return fmt.Errorf("%s.basic_auth_username is required with basic_auth_password", prefix)
+	}
+
+	for name := range headers {
+		if !isValidHeaderName(name) {
+			return fmt.Errorf("%s.headers contains invalid header name %q", prefix, name)
+		}
+		if (bearerToken != "" || basicAuthUsername != "") && strings.EqualFold(name, "Authorization") {
+			return fmt.Errorf("%s.headers cannot set Authorization together with bearer_token or basic auth", prefix)
+		}
+	}
+	return nil
+}
+
+// isValidHeaderName reports whether a string is a valid HTTP header field name
+func isValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > 0x7e || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSocialTargetFormat reports whether a target format publishes social media posts
+func IsSocialTargetFormat(format string) bool {
+	return format == TargetFormatMastodon || format == TargetFormatBluesky
+}
+
+// isSupportedEventType reports whether an EventSub subscription type can be configured
+func isSupportedEventType(eventType string) bool {
+	for _, supported := range SupportedEventTypes {
+		if eventType == supported {
+			return true
+		}
+	}
+	return false
+}
+
 // isValidURL performs basic URL validation
 func isValidURL(url string) bool {
 	if url == "" {
@@ -24,6 +24,13 @@ func TestDefaultConfig(t *testing.T) {
 	assert.Equal(t, time.Second, cfg.Retry.InitialDelay)
 	assert.Equal(t, time.Minute*5, cfg.Retry.MaxDelay)
 	assert.Equal(t, 2.0, cfg.Retry.BackoffFactor)
+	assert.Equal(t, 4, cfg.Retry.GetConcurrency())
+	assert.Equal(t, 0.2, cfg.Retry.Jitter)
+
+	assert.True(t, cfg.CircuitBreaker.Enabled)
+	assert.Equal(t, 5, cfg.CircuitBreaker.FailureThreshold)
+	assert.Equal(t, time.Minute, cfg.CircuitBreaker.OpenTimeout)
+	assert.Equal(t, 1, cfg.CircuitBreaker.SuccessThreshold)
 
 	assert.True(t, cfg.Output.Enabled)
 	assert.Equal(t, "data/output.json", cfg.Output.FilePath)
@@ -227,6 +234,102 @@ func TestConfigValidation(t *testing.T) {
 			expectError:   true,
 			errorContains: "backoff_factor must be greater than 1.0",
 		},
+		{
+			name: "negative retry concurrency",
+			modifyConfig: func(cfg *Config) {
+				cfg.Twitch.ClientID = "test_id"
+				cfg.Twitch.ClientSecret = "test_secret"
+				cfg.Twitch.WebhookSecret = "test_webhook_secret"
+				cfg.Retry.Concurrency = -1
+			},
+			expectError:   true,
+			errorContains: "retry.concurrency must not be negative",
+		},
+		{
+			name: "invalid retry jitter",
+			modifyConfig: func(cfg *Config) {
+				cfg.Twitch.ClientID = "test_id"
+				cfg.Twitch.ClientSecret = "test_secret"
+				cfg.Twitch.WebhookSecret = "test_webhook_secret"
+				cfg.Retry.Jitter = 1.0
+			},
+			expectError:   true,
+			errorContains: "retry.jitter must be at least 0 and less than 1",
+		},
+		{
+			name: "invalid circuit breaker threshold",
+			modifyConfig: func(cfg *Config) {
+				cfg.Twitch.ClientID = "test_id"
+				cfg.Twitch.ClientSecret = "test_secret"
+				cfg.Twitch.WebhookSecret = "test_webhook_secret"
+				cfg.CircuitBreaker.FailureThreshold = 0
+			},
+			expectError:   true,
+			errorContains: "circuit_breaker.failure_threshold must be greater than 0",
+		},
+		{
+			name: "websocket transport without webhook secret",
+			modifyConfig: func(cfg *Config) {
+				cfg.Twitch.ClientID = "test_id"
+				cfg.Twitch.ClientSecret = "test_secret"
+				cfg.Twitch.Transport = TransportWebSocket
+				cfg.Twitch.UserAccessToken = "user_token"
+			},
+			expectError: false,
+		},
+		{
+			name: "websocket transport without user access token",
+			modifyConfig: func(cfg *Config) {
+				cfg.Twitch.ClientID = "test_id"
+				cfg.Twitch.ClientSecret = "test_secret"
+				cfg.Twitch.Transport = TransportWebSocket
+			},
+			expectError:   true,
+			errorContains: "user_access_token is required",
+		},
+		{
+			name: "websocket transport with refresh token only",
+			modifyConfig: func(cfg *Config) {
+				cfg.Twitch.ClientID = "test_id"
+				cfg.Twitch.ClientSecret = "test_secret"
+				cfg.Twitch.Transport = TransportWebSocket
+				cfg.Twitch.UserRefreshToken = "refresh_token"
+			},
+			expectError: false,
+		},
+		{
+			name: "unknown transport",
+			modifyConfig: func(cfg *Config) {
+				cfg.Twitch.ClientID = "test_id"
+				cfg.Twitch.ClientSecret = "test_secret"
+				cfg.Twitch.WebhookSecret = "test_webhook_secret"
+				cfg.Twitch.Transport = "carrier_pigeon"
+			},
+			expectError:   true,
+			errorContains: "twitch.transport must be",
+		},
+		{
+			name: "alert webhook enabled without URL",
+			modifyConfig: func(cfg *Config) {
+				cfg.Twitch.ClientID = "test_id"
+				cfg.Twitch.ClientSecret = "test_secret"
+				cfg.Twitch.WebhookSecret = "test_webhook_secret"
+				cfg.AlertWebhook.Enabled = true
+			},
+			expectError:   true,
+			errorContains: "alert_webhook.url is required",
+		},
+		{
+			name: "invalid global webhook mode",
+			modifyConfig: func(cfg *Config) {
+				cfg.Twitch.ClientID = "test_id"
+				cfg.Twitch.ClientSecret = "test_secret"
+				cfg.Twitch.WebhookSecret = "test_webhook_secret"
+				cfg.GlobalWebhook.Mode = "sometimes"
+			},
+			expectError:   true,
+			errorContains: "global_webhook.mode must be",
+		},
 	}
 
 	for _, tt := range tests {
@@ -245,3 +348,285 @@ func TestConfigValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamerEventsValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  []string
+		wantErr string
+	}{
+		{
+			name:   "default events",
+			events: nil,
+		},
+		{
+			name:   "supported events",
+			events: []string{"stream.online", "channel.update"},
+		},
+		{
+			name:    "unsupported event",
+			events:  []string{"channel.follow"},
+			wantErr: `streamers.test_streamer.events contains unsupported event type "channel.follow"`,
+		},
+		{
+			name:    "duplicate event",
+			events:  []string{"stream.online", "stream.online"},
+			wantErr: `streamers.test_streamer.events contains duplicate event type "stream.online"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Twitch.ClientID = "test"
+			cfg.Twitch.ClientSecret = "test"
+			cfg.Twitch.WebhookSecret = "test"
+			cfg.Streamers["test_streamer"] = StreamerConfig{
+				UserID: "123",
+				Events: tt.events,
+			}
+
+			err := cfg.Validate()
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestStreamerConfigGetEvents(t *testing.T) {
+	assert.Equal(t, DefaultStreamerEvents, StreamerConfig{}.GetEvents())
+	assert.Equal(t, []string{"channel.update"}, StreamerConfig{Events: []string{"channel.update"}}.GetEvents())
+}
+
+func TestPreviousWebhookSecretsExpire(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Twitch.ClientID = "test_id"
+	cfg.Twitch.ClientSecret = "test_secret"
+	cfg.Twitch.WebhookSecret = "current"
+	cfg.Twitch.PreviousWebhookSecrets = []string{"previous"}
+
+	err := validateConfig(cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "previous_webhook_secrets_until is required")
+
+	cfg.Twitch.PreviousWebhookSecretsUntil = time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, validateConfig(cfg))
+}
+
+func TestLoadPreviousWebhookSecretsUntil(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+[twitch]
+client_id = "test_id"
+client_secret = "test_secret"
+webhook_secret = "current"
+previous_webhook_secrets = ["previous"]
+previous_webhook_secrets_until = 2026-02-01T00:00:00Z
+`), 0644))
+
+	cfg, err := LoadConfig(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), cfg.Twitch.PreviousWebhookSecretsUntil.UTC())
+
+	t.Setenv("ITSJUSTINTV_TWITCH_PREVIOUS_WEBHOOK_SECRETS_UNTIL", "not a time")
+	_, err = LoadConfig(configPath)
+	assert.Error(t, err)
+}
+
+func TestStreamerConfigGetTargets(t *testing.T) {
+	off := false
+	streamer := StreamerConfig{
+		TargetWebhookURL:    "https://example.com/legacy",
+		TargetWebhookSecret: "legacy_secret",
+		Targets: []WebhookTargetConfig{
+			{Name: "discord", URL: "https://discord.com/api/webhooks/1/abc"},
+			{Name: "cms", URL: "https://cms.example.com/hook", Enabled: &off},
+		},
+	}
+
+	targets := streamer.GetTargets()
+	require.Len(t, targets, 2)
+	assert.Equal(t, DefaultTargetName, targets[0].Name)
+	assert.Equal(t, "legacy_secret", targets[0].Secret)
+	assert.Equal(t, "discord", targets[1].Name)
+
+	assert.Empty(t, StreamerConfig{}.GetTargets())
+}
+
+func TestStreamerTargetsValidation(t *testing.T) {
+	tests := []struct {
+		name          string
+		streamer      StreamerConfig
+		errorContains string
+	}{
+		{
+			name: "valid targets",
+			streamer: StreamerConfig{Targets: []WebhookTargetConfig{
+				{Name: "discord", URL: "https://discord.com/api/webhooks/1/abc"},
+				{Name: "cms", URL: "https://cms.example.com/hook"},
+			}},
+		},
+		{
+			name:          "missing name",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{URL: "https://example.com"}}},
+			errorContains: "targets[0].name is required",
+		},
+		{
+			name: "duplicate name",
+			streamer: StreamerConfig{Targets: []WebhookTargetConfig{
+				{Name: "cms", URL: "https://cms.example.com/a"},
+				{Name: "cms", URL: "https://cms.example.com/b"},
+			}},
+			errorContains: "duplicate target name",
+		},
+		{
+			name: "name collides with target_webhook_url",
+			streamer: StreamerConfig{
+				TargetWebhookURL: "https://example.com/legacy",
+				Targets:          []WebhookTargetConfig{{Name: DefaultTargetName, URL: "https://example.com"}},
+			},
+			errorContains: "duplicate target name",
+		},
+		{
+			name:          "reserved global name",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: GlobalTargetName, URL: "https://example.com"}}},
+			errorContains: "reserved target name",
+		},
+		{
+			name:          "invalid template",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "cms", URL: "https://example.com", Template: "{{ .Payload"}}},
+			errorContains: "targets.cms.template is invalid",
+		},
+		{
+			name:          "unsupported format",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "cms", URL: "https://example.com", Format: "fax"}}},
+			errorContains: "format \"fax\" is not supported",
+		},
+		{
+			name:          "edit_on_offline without discord format",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "cms", URL: "https://example.com", EditOnOffline: true}}},
+			errorContains: "edit_on_offline requires format",
+		},
+		{
+			name: "template with discord format",
+			streamer: StreamerConfig{Targets: []WebhookTargetConfig{
+				{Name: "discord", URL: "https://discord.com/api/webhooks/1/abc", Format: TargetFormatDiscord, Template: "{}"},
+			}},
+			errorContains: "cannot combine a template",
+		},
+		{
+			name:          "matrix without room",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "matrix", URL: "https://matrix.example.org", Format: TargetFormatMatrix, AccessToken: "token"}}},
+			errorContains: "room_id is required",
+		},
+		{
+			name:          "telegram without bot token",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "telegram", URL: "https://api.telegram.org", Format: TargetFormatTelegram, ChatID: "-100123"}}},
+			errorContains: "bot_token is required",
+		},
+		{
+			name: "mastodon with template",
+			streamer: StreamerConfig{Targets: []WebhookTargetConfig{
+				{Name: "mastodon", URL: "https://mastodon.example", Format: TargetFormatMastodon, AccessToken: "token", Template: "Live: {{ .Payload.URL }}", DeleteOnOffline: true},
+			}},
+		},
+		{
+			name:          "delete_on_offline without social format",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "cms", URL: "https://example.com", DeleteOnOffline: true}}},
+			errorContains: "delete_on_offline requires format",
+		},
+		{
+			name:          "invalid cloudevents mode",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "bus", URL: "https://bus.example.com", Format: TargetFormatCloudEvents, CloudEventsMode: "batched"}}},
+			errorContains: "cloudevents_mode must be",
+		},
+		{
+			name: "standard webhooks signing with rotated secret",
+			streamer: StreamerConfig{Targets: []WebhookTargetConfig{
+				{Name: "cms", URL: "https://example.com", SigningScheme: SigningSchemeStandardWebhooks, Secret: "whsec_bmV3X3NlY3JldA==", PreviousSecrets: []string{"old_secret"}},
+			}},
+		},
+		{
+			name:          "unsupported signing scheme",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "cms", URL: "https://example.com", SigningScheme: "jws", Secret: "secret"}}},
+			errorContains: "signing_scheme must be",
+		},
+		{
+			name:          "standard webhooks signing without secret",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "cms", URL: "https://example.com", SigningScheme: SigningSchemeStandardWebhooks}}},
+			errorContains: "requires a secret",
+		},
+		{
+			name:          "invalid whsec secret",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "cms", URL: "https://example.com", SigningScheme: SigningSchemeStandardWebhooks, Secret: "whsec_%%%"}}},
+			errorContains: "invalid secret",
+		},
+		{
+			name:          "previous secrets with hmac signing",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "cms", URL: "https://example.com", Secret: "secret", PreviousSecrets: []string{"old"}}}},
+			errorContains: "previous_secrets requires signing_scheme",
+		},
+		{
+			name: "bearer token with static headers",
+			streamer: StreamerConfig{Targets: []WebhookTargetConfig{
+				{Name: "gateway", URL: "https://example.com", BearerToken: "token", Headers: map[string]string{"X-Api-Version": "2"}},
+			}},
+		},
+		{
+			name:          "bearer token with basic auth",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "gateway", URL: "https://example.com", BearerToken: "token", BasicAuthUsername: "user"}}},
+			errorContains: "cannot combine bearer_token and basic_auth_username",
+		},
+		{
+			name:          "basic auth password without username",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "gateway", URL: "https://example.com", BasicAuthPassword: "password"}}},
+			errorContains: "basic_auth_username is required",
+		},
+		{
+			name:          "invalid header name",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "gateway", URL: "https://example.com", Headers: map[string]string{"X Api Key": "key"}}}},
+			errorContains: "invalid header name",
+		},
+		{
+			name:          "authorization header with bearer token",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "gateway", URL: "https://example.com", BearerToken: "token", Headers: map[string]string{"authorization": "Basic abc"}}}},
+			errorContains: "cannot set Authorization",
+		},
+		{
+			name:          "bearer token with matrix format",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "matrix", URL: "https://matrix.example.org", Format: TargetFormatMatrix, RoomID: "!room:example.org", AccessToken: "token", BearerToken: "token"}}},
+			errorContains: "cannot combine bearer_token or basic auth",
+		},
+		{
+			name:          "headers with mastodon format",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "mastodon", URL: "https://mastodon.example", Format: TargetFormatMastodon, AccessToken: "token", Headers: map[string]string{"X-Api-Key": "key"}}}},
+			errorContains: "cannot combine headers or a signing secret",
+		},
+		{
+			name:          "signing secret with bluesky format",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "bluesky", URL: "https://bsky.social", Format: TargetFormatBluesky, Handle: "streamer.bsky.social", AppPassword: "password", Secret: "secret"}}},
+			errorContains: "cannot combine headers or a signing secret",
+		},
+		{
+			name:          "invalid URL",
+			streamer:      StreamerConfig{Targets: []WebhookTargetConfig{{Name: "cms", URL: "ftp://example.com"}}},
+			errorContains: "url must be a valid URL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTargets("test_streamer", tt.streamer)
+			if tt.errorContains == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+			}
+		})
+	}
+}
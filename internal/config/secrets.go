@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Prefixes of secret references that are resolved when the configuration is loaded
+const (
+	secretRefEnv  = "env:"
+	secretRefFile = "file:"
+)
+
+// resolveSecret returns the value of a secret setting. "env:NAME" reads the environment variable
+// NAME and "file:PATH" reads the file at PATH without its trailing newline; any other value is
+// returned as is.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretRefEnv):
+		name := strings.TrimPrefix(value, secretRefEnv)
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return resolved, nil
+	case strings.HasPrefix(value, secretRefFile):
+		path := strings.TrimPrefix(value, secretRefFile)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return value, nil
+}
+
+// resolveSecrets resolves the env: and file: references in the secret settings of outgoing webhooks
+// and the admin token
+func resolveSecrets(config *Config) error {
+	resolve := func(setting string, value *string) error {
+		resolved, err := resolveSecret(*value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", setting, err)
+		}
+		*value = resolved
+		return nil
+	}
+	resolveAll := func(setting string, values []string) error {
+		for i := range values {
+			if err := resolve(fmt.Sprintf("%s[%d]", setting, i), &values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	resolveHeaders := func(setting string, headers map[string]string) error {
+		for name, value := range headers {
+			if err := resolve(setting+"."+name, &value); err != nil {
+				return err
+			}
+			headers[name] = value
+		}
+		return nil
+	}
+
+	for key, streamer := range config.Streamers {
+		if err := resolve(fmt.Sprintf("streamers.%s.target_webhook_secret", key), &streamer.TargetWebhookSecret); err != nil {
+			return err
+		}
+
+		for i := range streamer.Targets {
+			target := &streamer.Targets[i]
+			prefix := fmt.Sprintf("streamers.%s.targets.%s", key, target.Name)
+			for setting, value := range map[string]*string{
+				"secret":              &target.Secret,
+				"bearer_token":        &target.BearerToken,
+				"basic_auth_password": &target.BasicAuthPassword,
+				"access_token":        &target.AccessToken,
+				"bot_token":           &target.BotToken,
+				"app_password":        &target.AppPassword,
+			} {
+				if err := resolve(prefix+"."+setting, value); err != nil {
+					return err
+				}
+			}
+			if err := resolveAll(prefix+".previous_secrets", target.PreviousSecrets); err != nil {
+				return err
+			}
+			if err := resolveHeaders(prefix+".headers", target.Headers); err != nil {
+				return err
+			}
+		}
+
+		config.Streamers[key] = streamer
+	}
+
+	global := &config.GlobalWebhook
+	for setting, value := range map[string]*string{
+		"target_webhook_secret": &global.TargetWebhookSecret,
+		"bearer_token":          &global.BearerToken,
+		"basic_auth_password":   &global.BasicAuthPassword,
+	} {
+		if err := resolve("global_webhook."+setting, value); err != nil {
+			return err
+		}
+	}
+	if err := resolveAll("global_webhook.previous_secrets", global.PreviousSecrets); err != nil {
+		return err
+	}
+	if err := resolveHeaders("global_webhook.headers", global.Headers); err != nil {
+		return err
+	}
+
+	if err := resolve("server.admin_token", &config.Server.AdminToken); err != nil {
+		return err
+	}
+	return resolve("alert_webhook.target_webhook_secret", &config.AlertWebhook.TargetWebhookSecret)
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("ITSJUSTINTV_TEST_TOKEN", "token_from_env")
+	secretFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(secretFile, []byte("token_from_file\n"), 0600))
+
+	value, err := resolveSecret("env:ITSJUSTINTV_TEST_TOKEN")
+	require.NoError(t, err)
+	assert.Equal(t, "token_from_env", value)
+
+	value, err = resolveSecret("file:" + secretFile)
+	require.NoError(t, err)
+	assert.Equal(t, "token_from_file", value)
+
+	value, err = resolveSecret("plain_token")
+	require.NoError(t, err)
+	assert.Equal(t, "plain_token", value)
+
+	_, err = resolveSecret("env:ITSJUSTINTV_TEST_UNSET")
+	assert.ErrorContains(t, err, "ITSJUSTINTV_TEST_UNSET is not set")
+
+	_, err = resolveSecret("file:" + filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestLoadConfigResolvesTargetSecrets(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("ITSJUSTINTV_TEST_BEARER", "gateway_token")
+	passwordFile := filepath.Join(tmpDir, "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("n8n_password\n"), 0600))
+
+	configPath := filepath.Join(tmpDir, "config.toml")
+	configContent := `
+[twitch]
+client_id = "test_client_id"
+client_secret = "test_client_secret"
+webhook_secret = "test_webhook_secret"
+
+[streamers.test_streamer]
+user_id = "123456"
+
+[[streamers.test_streamer.targets]]
+name = "gateway"
+url = "https://gateway.example.com/hook"
+bearer_token = "env:ITSJUSTINTV_TEST_BEARER"
+headers = { "X-Api-Key" = "env:ITSJUSTINTV_TEST_BEARER", "X-Source" = "itsjustintv" }
+
+[[streamers.test_streamer.targets]]
+name = "n8n"
+url = "https://n8n.example.com/webhook/twitch"
+basic_auth_username = "itsjustintv"
+basic_auth_password = "file:` + passwordFile + `"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(configContent), 0644))
+
+	cfg, err := LoadConfig(configPath)
+	require.NoError(t, err)
+
+	targets := cfg.Streamers["test_streamer"].Targets
+	require.Len(t, targets, 2)
+	assert.Equal(t, "gateway_token", targets[0].BearerToken)
+	assert.Equal(t, map[string]string{"X-Api-Key": "gateway_token", "X-Source": "itsjustintv"}, targets[0].Headers)
+	assert.Equal(t, "n8n_password", targets[1].BasicAuthPassword)
+
+	// A missing secret file fails loading instead of sending an empty password
+	require.NoError(t, os.Remove(passwordFile))
+	_, err = LoadConfig(configPath)
+	assert.ErrorContains(t, err, "streamers.test_streamer.targets.n8n.basic_auth_password")
+}
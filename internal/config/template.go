@@ -0,0 +1,22 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+)
+
+// PayloadTemplateFuncs are the functions available in outgoing webhook payload templates
+var PayloadTemplateFuncs = template.FuncMap{
+	// json encodes a value as JSON, e.g. {{ json .Payload.Title }} for a quoted, escaped string
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": strings.Join,
+}
+
+// ParsePayloadTemplate parses an outgoing webhook payload template
+func ParsePayloadTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(PayloadTemplateFuncs).Option("missingkey=error").Parse(text)
+}
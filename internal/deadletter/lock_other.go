@@ -0,0 +1,8 @@
+//go:build !unix
+
+package deadletter
+
+// lockFile is a no-op on platforms without flock; only a single process may use the store there
+func lockFile(path string, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
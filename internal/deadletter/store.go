@@ -0,0 +1,252 @@
+package deadletter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/webhook"
+)
+
+// Reasons a request is dead-lettered
+const (
+	ReasonMaxAttempts      = "max_attempts"      // every retry failed
+	ReasonPermanentFailure = "permanent_failure" // the target rejected the request, e.g. with 404 or 410
+	ReasonTargetRemoved    = "target_removed"    // the target was removed from the configuration
+)
+
+// ErrNotFound is returned for unknown dead letter IDs
+var ErrNotFound = errors.New("dead letter not found")
+
+// Entry is a webhook request that could not be delivered
+type Entry struct {
+	ID      string                   `json:"id"`
+	Reason  string                   `json:"reason"`
+	DeadAt  time.Time                `json:"dead_at"`
+	Request *webhook.DispatchRequest `json:"request"` // includes the attempt history
+}
+
+// Dispatcher delivers a webhook request
+type Dispatcher interface {
+	ResolveTarget(req *webhook.DispatchRequest) error
+	Dispatch(ctx context.Context, req *webhook.DispatchRequest) *webhook.DispatchResult
+}
+
+// Store persists dead letters in a JSON file. Every operation reads the file under a lock on a
+// ".lock" file next to it, so the CLI and a running server see and keep each other's changes.
+type Store struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// NewStore creates a dead letter store backed by the file at path
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Add stores a request that could not be delivered
+func (s *Store) Add(req *webhook.DispatchRequest, reason string) (*Entry, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{
+		ID:      id,
+		Reason:  reason,
+		DeadAt:  time.Now(),
+		Request: req,
+	}
+
+	err = s.update(func(entries []*Entry) ([]*Entry, error) {
+		return append(entries, entry), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// List returns all dead letters, oldest first
+func (s *Store) List() ([]*Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	unlock, err := lockFile(s.path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return s.load()
+}
+
+// Get returns the dead letter with the given ID
+func (s *Store) Get(id string) (*Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Remove deletes the dead letter with the given ID
+func (s *Store) Remove(id string) error {
+	return s.update(func(entries []*Entry) ([]*Entry, error) {
+		for i, entry := range entries {
+			if entry.ID == id {
+				return append(entries[:i], entries[i+1:]...), nil
+			}
+		}
+		return nil, ErrNotFound
+	})
+}
+
+// Purge deletes all dead letters and returns how many were deleted
+func (s *Store) Purge() (int, error) {
+	purged := 0
+	err := s.update(func(entries []*Entry) ([]*Entry, error) {
+		purged = len(entries)
+		return nil, nil
+	})
+	return purged, err
+}
+
+// Replay delivers a dead letter again to the current configuration of its target, e.g. after its
+// URL or credentials were fixed. It is removed from the store when the delivery succeeds; otherwise
+// the failed attempt is added to its history.
+func (s *Store) Replay(ctx context.Context, dispatcher Dispatcher, id string) (*webhook.DispatchResult, error) {
+	entry, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the payload and history are kept from the failed request
+	req := &webhook.DispatchRequest{
+		Payload:     entry.Request.Payload,
+		StreamerKey: entry.Request.StreamerKey,
+		TargetName:  entry.Request.TargetName,
+		MessageID:   entry.Request.MessageID,
+		Attempt:     entry.Request.Attempt + 1,
+		History:     entry.Request.History,
+	}
+	if err := dispatcher.ResolveTarget(req); err != nil {
+		return nil, err
+	}
+	result := dispatcher.Dispatch(ctx, req)
+
+	err = s.update(func(entries []*Entry) ([]*Entry, error) {
+		for i, candidate := range entries {
+			if candidate.ID != id {
+				continue
+			}
+			if result.Success {
+				return append(entries[:i], entries[i+1:]...), nil
+			}
+			entries[i].Request = req
+			return entries, nil
+		}
+		// Removed while the request was replayed
+		return entries, nil
+	})
+	return result, err
+}
+
+// update applies a change to the stored dead letters and saves them
+func (s *Store) update(change func([]*Entry) ([]*Entry, error)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Other processes must not save between load and save, or their changes are lost
+	unlock, err := lockFile(s.path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	entries, err = change(entries)
+	if err != nil {
+		return err
+	}
+	return s.save(entries)
+}
+
+// load reads the dead letters from disk
+func (s *Store) load() ([]*Entry, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letter file: %w", err)
+	}
+
+	var state struct {
+		Entries []*Entry `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal dead letters: %w", err)
+	}
+
+	sort.SliceStable(state.Entries, func(i, j int) bool {
+		return state.Entries[i].DeadAt.Before(state.Entries[j].DeadAt)
+	})
+	return state.Entries, nil
+}
+
+// save writes the dead letters to a temporary file and renames it over the dead letter file, so
+// readers never see a partially written file
+func (s *Store) save(entries []*Entry) error {
+	if entries == nil {
+		entries = []*Entry{}
+	}
+	data, err := json.MarshalIndent(struct {
+		Entries []*Entry `json:"entries"`
+	}{Entries: entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letters: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create dead letter file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write dead letter file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write dead letter file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace dead letter file: %w", err)
+	}
+	return nil
+}
+
+// newID returns a random dead letter ID
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate dead letter ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
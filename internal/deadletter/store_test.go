@@ -0,0 +1,167 @@
+package deadletter
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDispatcher returns a fixed result for every request to the targets it knows
+type fakeDispatcher struct {
+	result   webhook.DispatchResult
+	targets  map[string]string // URL by target name
+	requests []*webhook.DispatchRequest
+}
+
+func (f *fakeDispatcher) ResolveTarget(req *webhook.DispatchRequest) error {
+	url, ok := f.targets[req.TargetName]
+	if !ok {
+		return webhook.ErrTargetNotConfigured
+	}
+	req.WebhookURL = url
+	return nil
+}
+
+func (f *fakeDispatcher) Dispatch(ctx context.Context, req *webhook.DispatchRequest) *webhook.DispatchResult {
+	f.requests = append(f.requests, req)
+	result := f.result
+	return &result
+}
+
+func newTestRequest() *webhook.DispatchRequest {
+	return &webhook.DispatchRequest{
+		WebhookURL:    "https://example.com/hook",
+		WebhookSecret: "secret",
+		BearerToken:   "token",
+		Headers:       map[string]string{"X-Api-Key": "key"},
+		StreamerKey:   "test_streamer",
+		TargetName:    "cms",
+		Payload:       webhook.WebhookPayload{StreamerLogin: "teststreamer"},
+		Attempt:       3,
+		History: []webhook.AttemptRecord{
+			{Attempt: 1, Time: time.Now().Add(-time.Minute), StatusCode: 503, Error: "HTTP 503 (server_error)", FailureClass: webhook.FailureServerError},
+			{Attempt: 2, Time: time.Now().Add(-30 * time.Second), StatusCode: 503, Error: "HTTP 503 (server_error)", FailureClass: webhook.FailureServerError},
+			{Attempt: 3, Time: time.Now(), Error: "request failed: timeout", FailureClass: webhook.FailureNetwork},
+		},
+	}
+}
+
+func TestStoreAddListGet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letters.json")
+	store := NewStore(path)
+
+	entries, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	entry, err := store.Add(newTestRequest(), ReasonMaxAttempts)
+	require.NoError(t, err)
+	assert.NotEmpty(t, entry.ID)
+
+	// A second store on the same file, e.g. the CLI, sees the entry with its history
+	loaded, err := NewStore(path).Get(entry.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ReasonMaxAttempts, loaded.Reason)
+	assert.Equal(t, "cms", loaded.Request.TargetName)
+	require.Len(t, loaded.Request.History, 3)
+	assert.Equal(t, 503, loaded.Request.History[0].StatusCode)
+	assert.Equal(t, webhook.FailureNetwork, loaded.Request.History[2].FailureClass)
+
+	_, err = store.Get("unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestStoresShareFileWithoutLosingEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letters.json")
+	// Two stores on the same file stand in for the server and the CLI
+	server, cli := NewStore(path), NewStore(path)
+
+	var wg sync.WaitGroup
+	for _, store := range []*Store{server, cli} {
+		wg.Add(1)
+		go func(store *Store) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				_, err := store.Add(newTestRequest(), ReasonMaxAttempts)
+				assert.NoError(t, err)
+			}
+		}(store)
+	}
+	wg.Wait()
+
+	entries, err := server.List()
+	require.NoError(t, err)
+	assert.Len(t, entries, 50)
+}
+
+func TestStoreRemoveAndPurge(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "dead_letters.json"))
+
+	first, err := store.Add(newTestRequest(), ReasonMaxAttempts)
+	require.NoError(t, err)
+	_, err = store.Add(newTestRequest(), ReasonPermanentFailure)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Remove(first.ID))
+	assert.ErrorIs(t, store.Remove(first.ID), ErrNotFound)
+
+	entries, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, ReasonPermanentFailure, entries[0].Reason)
+
+	purged, err := store.Purge()
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	entries, err = store.List()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestStoreReplay(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "dead_letters.json"))
+	entry, err := store.Add(newTestRequest(), ReasonMaxAttempts)
+	require.NoError(t, err)
+
+	// The target was moved since the request failed
+	dispatcher := &fakeDispatcher{
+		result:  webhook.DispatchResult{Success: false, StatusCode: 500},
+		targets: map[string]string{"cms": "https://new.example.com/hook"},
+	}
+	result, err := store.Replay(context.Background(), dispatcher, entry.ID)
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+	require.Len(t, dispatcher.requests, 1)
+	assert.Equal(t, 4, dispatcher.requests[0].Attempt)
+	assert.Equal(t, "https://new.example.com/hook", dispatcher.requests[0].WebhookURL)
+	assert.Empty(t, dispatcher.requests[0].BearerToken, "credentials of the failed request are not reused")
+	assert.Equal(t, "teststreamer", dispatcher.requests[0].Payload.StreamerLogin)
+
+	// A failed replay keeps the dead letter
+	_, err = store.Get(entry.ID)
+	require.NoError(t, err)
+
+	dispatcher.result = webhook.DispatchResult{Success: true, StatusCode: 200}
+	result, err = store.Replay(context.Background(), dispatcher, entry.ID)
+	require.NoError(t, err)
+	assert.True(t, result.Success)
+
+	_, err = store.Get(entry.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = store.Replay(context.Background(), dispatcher, entry.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Dead letters of removed targets cannot be replayed
+	entry, err = store.Add(&webhook.DispatchRequest{StreamerKey: "test_streamer", TargetName: "removed"}, ReasonMaxAttempts)
+	require.NoError(t, err)
+	_, err = store.Replay(context.Background(), dispatcher, entry.ID)
+	assert.ErrorIs(t, err, webhook.ErrTargetNotConfigured)
+}
@@ -24,6 +24,7 @@ type Writer struct {
 type OutputEntry struct {
 	Timestamp time.Time              `json:"timestamp"`
 	Payload   webhook.WebhookPayload `json:"payload"`
+	Target    string                 `json:"target,omitempty"`
 	Success   bool                   `json:"success"`
 	Error     string                 `json:"error,omitempty"`
 }
@@ -67,8 +68,8 @@ func (w *Writer) Stop() error {
 	return nil
 }
 
-// WritePayload writes a webhook payload to the output file
-func (w *Writer) WritePayload(payload webhook.WebhookPayload, success bool, errorMsg string) error {
+// WritePayload writes a webhook payload delivered to the named target to the output file
+func (w *Writer) WritePayload(payload webhook.WebhookPayload, target string, success bool, errorMsg string) error {
 	if !w.config.Output.Enabled {
 		return nil
 	}
@@ -79,6 +80,7 @@ func (w *Writer) WritePayload(payload webhook.WebhookPayload, success bool, erro
 	entry := OutputEntry{
 		Timestamp: time.Now().UTC(),
 		Payload:   payload,
+		Target:    target,
 		Success:   success,
 		Error:     errorMsg,
 	}
@@ -98,6 +100,7 @@ func (w *Writer) WritePayload(payload webhook.WebhookPayload, success bool, erro
 
 	w.logger.Debug("Wrote payload to output file",
 		"streamer_login", payload.StreamerLogin,
+		"target", target,
 		"success", success,
 		"total_entries", len(w.payloads))
 
@@ -0,0 +1,252 @@
+package retry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/atomicfile"
+	"github.com/rmoriz/itsjustintv/internal/webhook"
+)
+
+// compactAfter is the number of journal records after which the journal is folded into the snapshot
+const compactAfter = 1000
+
+// Journal operations
+const (
+	journalOpPut    = "put"
+	journalOpDelete = "delete"
+)
+
+// journalRecord is a single change of the retry queue
+type journalRecord struct {
+	Sequence uint64                   `json:"seq"`
+	Op       string                   `json:"op"`
+	ID       string                   `json:"id"`
+	Request  *webhook.DispatchRequest `json:"request,omitempty"`
+}
+
+// snapshot is the compacted retry queue. Journal records up to Sequence are already applied.
+type snapshot struct {
+	Sequence uint64                     `json:"sequence"`
+	Queue    []*webhook.DispatchRequest `json:"queue"`
+}
+
+// journal persists the retry queue crash-safely: every change is appended to the journal file and
+// synced before it is acknowledged, and compaction atomically replaces the snapshot before the
+// journal is truncated. A record torn by a crash is discarded on recovery.
+type journal struct {
+	snapshotPath string
+	journalPath  string
+	file         *os.File
+	sequence     uint64 // sequence of the last record
+	records      int    // records written since the last compaction
+}
+
+// openJournal recovers the retry queue from the snapshot and journal at statePath and opens the
+// journal for appending
+func openJournal(statePath string) (*journal, map[string]*webhook.DispatchRequest, error) {
+	j := &journal{
+		snapshotPath: statePath,
+		journalPath:  statePath + ".journal",
+	}
+
+	pending := make(map[string]*webhook.DispatchRequest)
+	snap, err := readSnapshot(j.snapshotPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	j.sequence = snap.Sequence
+	for _, req := range snap.Queue {
+		if req.RetryID == "" {
+			// State files written before the journal existed
+			if req.RetryID, err = newRetryID(); err != nil {
+				return nil, nil, err
+			}
+		}
+		pending[req.RetryID] = req
+	}
+
+	file, err := os.OpenFile(j.journalPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open retry journal: %w", err)
+	}
+
+	validSize, err := j.replay(file, pending)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	// Drop a torn record so new records are not appended to it
+	if err := file.Truncate(validSize); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to truncate retry journal: %w", err)
+	}
+	if _, err := file.Seek(validSize, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to seek retry journal: %w", err)
+	}
+
+	j.file = file
+	return j, pending, nil
+}
+
+// moveStateAside renames the snapshot and journal at statePath that could not be recovered, keeping
+// them for inspection. It returns the new paths.
+func moveStateAside(statePath string, now time.Time) ([]string, error) {
+	suffix := fmt.Sprintf(".corrupt-%d", now.Unix())
+	var moved []string
+	for _, path := range []string{statePath, statePath + ".journal"} {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(path, path+suffix); err != nil {
+			return moved, fmt.Errorf("failed to move %s aside: %w", path, err)
+		}
+		moved = append(moved, path+suffix)
+	}
+	return moved, nil
+}
+
+// readSnapshot reads the snapshot file; a missing file is an empty snapshot
+func readSnapshot(path string) (*snapshot, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &snapshot{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state: %w", err)
+	}
+	return &snap, nil
+}
+
+// replay applies the journal records newer than the snapshot to pending, and returns the size of
+// the journal up to the last complete record. A damaged record other than the last one is an error.
+func (j *journal) replay(file *os.File, pending map[string]*webhook.DispatchRequest) (int64, error) {
+	reader := bufio.NewReader(file)
+	var validSize int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A record without its newline was torn by a crash
+			return validSize, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read retry journal: %w", err)
+		}
+
+		var record journalRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &record); err != nil || record.ID == "" {
+			if _, err := reader.Peek(1); errors.Is(err, io.EOF) {
+				// Only the last record can be damaged by a crash
+				return validSize, nil
+			}
+			// Skipping the records after a corrupt one would lose queued retries or resurrect
+			// delivered ones
+			return 0, fmt.Errorf("corrupt retry journal record at offset %d", validSize)
+		}
+		validSize += int64(len(line))
+		j.records++
+
+		if record.Sequence <= j.sequence {
+			// Already part of the snapshot, left over from a crash during compaction
+			continue
+		}
+		j.sequence = record.Sequence
+
+		switch record.Op {
+		case journalOpPut:
+			if record.Request != nil {
+				pending[record.ID] = record.Request
+			}
+		case journalOpDelete:
+			delete(pending, record.ID)
+		}
+	}
+}
+
+// put records that a request is queued, replacing an earlier version of it
+func (j *journal) put(req *webhook.DispatchRequest) error {
+	return j.append(journalRecord{Op: journalOpPut, ID: req.RetryID, Request: req})
+}
+
+// delete records that a request left the queue
+func (j *journal) delete(id string) error {
+	return j.append(journalRecord{Op: journalOpDelete, ID: id})
+}
+
+// append writes a record and syncs it to disk
+func (j *journal) append(record journalRecord) error {
+	record.Sequence = j.sequence + 1
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal record: %w", err)
+	}
+
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write retry journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync retry journal: %w", err)
+	}
+
+	j.sequence = record.Sequence
+	j.records++
+	return nil
+}
+
+// needsCompaction reports whether the journal has grown enough to be compacted
+func (j *journal) needsCompaction() bool {
+	return j.records >= compactAfter
+}
+
+// compact writes the pending requests as the new snapshot and truncates the journal
+func (j *journal) compact(pending map[string]*webhook.DispatchRequest) error {
+	snap := snapshot{
+		Sequence: j.sequence,
+		Queue:    make([]*webhook.DispatchRequest, 0, len(pending)),
+	}
+	for _, req := range pending {
+		snap.Queue = append(snap.Queue, req)
+	}
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+	if err := atomicfile.WriteFile(j.snapshotPath, data); err != nil {
+		return err
+	}
+
+	// Records up to the snapshot's sequence are skipped on recovery, so a crash before the
+	// truncation loses nothing
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate retry journal: %w", err)
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek retry journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync retry journal: %w", err)
+	}
+
+	j.records = 0
+	return nil
+}
+
+// close closes the journal file
+func (j *journal) close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,358 @@
+package retry
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newJournalRequest(id string) *webhook.DispatchRequest {
+	return &webhook.DispatchRequest{
+		RetryID:     id,
+		WebhookURL:  "https://example.com/hook",
+		StreamerKey: "test_streamer",
+		TargetName:  "cms",
+		Payload:     webhook.WebhookPayload{StreamerLogin: "teststreamer", Description: strings.Repeat("x", 4096)},
+		Attempt:     2,
+	}
+}
+
+func TestJournalRecoversQueue(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "retry_state.json")
+
+	j, pending, err := openJournal(statePath)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	require.NoError(t, j.put(newJournalRequest("a")))
+	require.NoError(t, j.put(newJournalRequest("b")))
+	require.NoError(t, j.put(newJournalRequest("c")))
+	require.NoError(t, j.delete("b"))
+
+	updated := newJournalRequest("a")
+	updated.Attempt = 3
+	require.NoError(t, j.put(updated))
+
+	// Simulate a crash: the journal is never compacted
+	require.NoError(t, j.close())
+
+	j, pending, err = openJournal(statePath)
+	require.NoError(t, err)
+	defer j.close()
+	assert.Len(t, pending, 2)
+	assert.Equal(t, 3, pending["a"].Attempt)
+	assert.Contains(t, pending, "c")
+}
+
+func TestJournalDiscardsTornRecord(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "retry_state.json")
+
+	j, _, err := openJournal(statePath)
+	require.NoError(t, err)
+	require.NoError(t, j.put(newJournalRequest("a")))
+	require.NoError(t, j.put(newJournalRequest("b")))
+	require.NoError(t, j.close())
+
+	// Cut the last record in half, as a power loss during the write would
+	info, err := os.Stat(statePath + ".journal")
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(statePath+".journal", info.Size()-100))
+
+	j, pending, err := openJournal(statePath)
+	require.NoError(t, err)
+	assert.Len(t, pending, 1)
+	assert.Contains(t, pending, "a")
+
+	// New records are not appended to the torn one
+	require.NoError(t, j.put(newJournalRequest("c")))
+	require.NoError(t, j.close())
+
+	j, pending, err = openJournal(statePath)
+	require.NoError(t, err)
+	defer j.close()
+	assert.Len(t, pending, 2)
+	assert.Contains(t, pending, "c")
+}
+
+func TestJournalRejectsCorruptRecordBeforeTheEnd(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "retry_state.json")
+
+	j, _, err := openJournal(statePath)
+	require.NoError(t, err)
+	require.NoError(t, j.put(newJournalRequest("a")))
+	require.NoError(t, j.put(newJournalRequest("b")))
+	require.NoError(t, j.delete("a"))
+	require.NoError(t, j.close())
+
+	// Damage the second record; the valid delete after it must not be dropped silently
+	data, err := os.ReadFile(statePath + ".journal")
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+	lines[1] = "{garbage\n"
+	require.NoError(t, os.WriteFile(statePath+".journal", []byte(strings.Join(lines, "")), 0600))
+
+	_, _, err = openJournal(statePath)
+	assert.ErrorContains(t, err, "corrupt retry journal record")
+
+	// A damaged last record is still treated as torn by a crash
+	lines = lines[:2]
+	require.NoError(t, os.WriteFile(statePath+".journal", []byte(strings.Join(lines, "")), 0600))
+	j, pending, err := openJournal(statePath)
+	require.NoError(t, err)
+	defer j.close()
+	assert.Len(t, pending, 1)
+	assert.Contains(t, pending, "a")
+}
+
+func TestJournalCompaction(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "retry_state.json")
+
+	j, pending, err := openJournal(statePath)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		req := newJournalRequest(fmt.Sprintf("req-%d", i))
+		pending[req.RetryID] = req
+		require.NoError(t, j.put(req))
+	}
+	delete(pending, "req-0")
+	require.NoError(t, j.delete("req-0"))
+
+	// Keep a copy of the journal to simulate a crash between writing the snapshot and truncating it
+	stale, err := os.ReadFile(statePath + ".journal")
+	require.NoError(t, err)
+
+	require.NoError(t, j.compact(pending))
+	info, err := os.Stat(statePath + ".journal")
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+	require.NoError(t, j.close())
+
+	require.NoError(t, os.WriteFile(statePath+".journal", stale, 0600))
+
+	j, recovered, err := openJournal(statePath)
+	require.NoError(t, err)
+	defer j.close()
+	assert.Len(t, recovered, 4)
+	assert.NotContains(t, recovered, "req-0")
+
+	// Sequences continue after the snapshot, so new records are not skipped on the next recovery
+	require.NoError(t, j.delete("req-1"))
+	require.NoError(t, j.close())
+	j, recovered, err = openJournal(statePath)
+	require.NoError(t, err)
+	assert.Len(t, recovered, 3)
+}
+
+func TestJournalLoadsLegacyStateFile(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "retry_state.json")
+	require.NoError(t, os.WriteFile(statePath, []byte(`{"queue":[{"webhook_url":"https://example.com","streamer_key":"test_streamer","attempt":2}]}`), 0644))
+
+	j, pending, err := openJournal(statePath)
+	require.NoError(t, err)
+	defer j.close()
+	require.Len(t, pending, 1)
+	for id, req := range pending {
+		assert.NotEmpty(t, id)
+		assert.Equal(t, id, req.RetryID)
+	}
+}
+
+func TestManagerPersistsEveryChange(t *testing.T) {
+	manager := newTestManager(t)
+	require.NoError(t, manager.Start(context.Background()))
+	defer manager.Stop()
+
+	kept := &webhook.DispatchRequest{StreamerKey: "test_streamer", TargetName: "cms", Attempt: 1}
+	done := &webhook.DispatchRequest{StreamerKey: "test_streamer", TargetName: "queue", Attempt: 1}
+	manager.AddRequest(kept)
+	manager.AddRequest(done)
+
+	manager.mutex.Lock()
+	manager.forget(done)
+	manager.mutex.Unlock()
+
+	// Recover from disk without stopping the manager, as after a crash
+	j, pending, err := openJournal(manager.config.Retry.StateFile)
+	require.NoError(t, err)
+	defer j.close()
+	require.Len(t, pending, 1)
+	assert.Equal(t, "cms", pending[kept.RetryID].TargetName)
+	assert.Equal(t, 2, pending[kept.RetryID].Attempt)
+}
+
+func TestManagerMovesCorruptStateAside(t *testing.T) {
+	manager := newTestManager(t)
+	require.NoError(t, os.WriteFile(manager.config.Retry.StateFile, []byte("{not json"), 0600))
+
+	require.NoError(t, manager.Start(context.Background()))
+	defer manager.Stop()
+
+	// The unreadable state is kept for inspection...
+	moved, err := filepath.Glob(manager.config.Retry.StateFile + ".corrupt-*")
+	require.NoError(t, err)
+	assert.Len(t, moved, 1)
+
+	// ...and new requests are persisted again
+	req := &webhook.DispatchRequest{StreamerKey: "test_streamer", TargetName: "cms", Attempt: 1}
+	manager.AddRequestAfter(req, time.Hour)
+	j, pending, err := openJournal(manager.config.Retry.StateFile)
+	require.NoError(t, err)
+	defer j.close()
+	assert.Contains(t, pending, req.RetryID)
+}
+
+func TestCompactionDuringInFlightRetry(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	manager := newTestManager(t)
+	manager.config.CircuitBreaker.Enabled = false
+	addTestTarget(manager, server.URL)
+	require.NoError(t, manager.Start(context.Background()))
+	defer manager.Stop()
+
+	manager.AddRequestAfter(&webhook.DispatchRequest{StreamerKey: "test_streamer", TargetName: "cms", Attempt: 1}, 10*time.Millisecond)
+	<-entered
+
+	// Compact continuously while the dispatcher records the attempt; run with -race
+	stop := make(chan struct{})
+	compacted := make(chan struct{})
+	go func() {
+		defer close(compacted)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			manager.mutex.Lock()
+			require.NoError(t, manager.journal.compact(manager.pending))
+			manager.mutex.Unlock()
+		}
+	}()
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		manager.mutex.RLock()
+		defer manager.mutex.RUnlock()
+		for _, req := range manager.pending {
+			return len(req.History) == 1 && req.Attempt == 3
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	close(stop)
+	<-compacted
+}
+
+func TestJournalDoesNotStoreCredentials(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "retry_state.json")
+	j, _, err := openJournal(statePath)
+	require.NoError(t, err)
+
+	req := newJournalRequest("a")
+	req.BearerToken = "bearer-secret"
+	req.WebhookSecret = "hmac-secret"
+	req.Headers = map[string]string{"X-Api-Key": "header-secret"}
+	require.NoError(t, j.put(req))
+	require.NoError(t, j.compact(map[string]*webhook.DispatchRequest{"a": req}))
+	require.NoError(t, j.put(req))
+	require.NoError(t, j.close())
+
+	for _, path := range []string{statePath, statePath + ".journal"} {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "secret")
+		assert.NotContains(t, string(data), "example.com/hook")
+	}
+}
+
+// crashStateEnv makes the test binary run as the journal writer killed by TestJournalRecoversAfterKill
+const crashStateEnv = "ITSJUSTINTV_RETRY_CRASH_STATE"
+
+func TestJournalRecoversAfterKill(t *testing.T) {
+	if statePath := os.Getenv(crashStateEnv); statePath != "" {
+		runCrashingJournalWriter(statePath)
+		return
+	}
+	if testing.Short() {
+		t.Skip("starts a subprocess")
+	}
+
+	statePath := filepath.Join(t.TempDir(), "retry_state.json")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestJournalRecoversAfterKill$")
+	cmd.Env = append(os.Environ(), crashStateEnv+"="+statePath)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+
+	// The writer prints the ID of every request once it is persisted
+	acknowledged := make([]string, 0)
+	scanner := bufio.NewScanner(stdout)
+	for len(acknowledged) < 150 && scanner.Scan() {
+		if id, ok := strings.CutPrefix(scanner.Text(), "persisted "); ok {
+			acknowledged = append(acknowledged, id)
+		}
+	}
+	require.Len(t, acknowledged, 150)
+
+	// Kill the writer in the middle of its next writes and compactions
+	require.NoError(t, cmd.Process.Kill())
+	_ = cmd.Wait()
+
+	j, pending, err := openJournal(statePath)
+	require.NoError(t, err)
+	defer j.close()
+
+	for _, id := range acknowledged {
+		assert.Contains(t, pending, id)
+	}
+	for id, req := range pending {
+		assert.Equal(t, id, req.RetryID)
+		assert.Equal(t, "test_streamer", req.StreamerKey)
+	}
+}
+
+// runCrashingJournalWriter persists requests until it is killed, compacting the journal regularly
+func runCrashingJournalWriter(statePath string) {
+	j, pending, err := openJournal(statePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for i := 0; ; i++ {
+		req := newJournalRequest(fmt.Sprintf("req-%d", i))
+		if err := j.put(req); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		pending[req.RetryID] = req
+		fmt.Printf("persisted %s\n", req.RetryID)
+
+		if i%25 == 24 {
+			if err := j.compact(pending); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
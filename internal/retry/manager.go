@@ -2,52 +2,82 @@ package retry
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"math"
-	"os"
+	mathrand "math/rand/v2"
 	"sync"
 	"time"
 
 	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/rmoriz/itsjustintv/internal/deadletter"
 	"github.com/rmoriz/itsjustintv/internal/webhook"
 )
 
 // Manager handles retry logic for failed webhook dispatches
 type Manager struct {
-	config     *config.Config
-	logger     *slog.Logger
-	dispatcher *webhook.Dispatcher
-	queue      []*webhook.DispatchRequest
-	mutex      sync.RWMutex
-	stopCh     chan struct{}
-	wg         sync.WaitGroup
+	config      *config.Config
+	logger      *slog.Logger
+	dispatcher  *webhook.Dispatcher
+	deadLetters *deadletter.Store
+	queue       schedule                            // waiting requests by next retry time
+	work        chan *webhook.DispatchRequest       // due requests for the workers
+	wakeCh      chan struct{}                       // signals the scheduler that the queue changed
+	pending     map[string]*webhook.DispatchRequest // queued and in-flight requests by RetryID
+	journal     *journal                            // nil until started
+	mutex       sync.RWMutex
+	stopCh      chan struct{}
+	wg          sync.WaitGroup
 }
 
 // NewManager creates a new retry manager
 func NewManager(cfg *config.Config, logger *slog.Logger, dispatcher *webhook.Dispatcher) *Manager {
 	return &Manager{
-		config:     cfg,
-		logger:     logger,
-		dispatcher: dispatcher,
-		queue:      make([]*webhook.DispatchRequest, 0),
-		stopCh:     make(chan struct{}),
+		config:      cfg,
+		logger:      logger,
+		dispatcher:  dispatcher,
+		deadLetters: deadletter.NewStore(cfg.Retry.DeadLetterFile),
+		queue:       make(schedule, 0),
+		work:        make(chan *webhook.DispatchRequest),
+		wakeCh:      make(chan struct{}, 1),
+		pending:     make(map[string]*webhook.DispatchRequest),
+		stopCh:      make(chan struct{}),
 	}
 }
 
+// DeadLetters returns the store of requests that could not be delivered
+func (m *Manager) DeadLetters() *deadletter.Store {
+	return m.deadLetters
+}
+
 // Start starts the retry manager background processing
 func (m *Manager) Start(ctx context.Context) error {
-	// Load existing retry state
+	// Load existing retry state. Unreadable state is moved aside rather than running without
+	// persistence.
 	if err := m.loadState(); err != nil {
-		m.logger.Warn("Failed to load retry state", "error", err)
+		moved, moveErr := moveStateAside(m.config.Retry.StateFile, time.Now())
+		if moveErr != nil {
+			return fmt.Errorf("failed to load retry state: %w (moving it aside failed: %v)", err, moveErr)
+		}
+		m.logger.Error("Failed to load retry state, moved it aside and started with an empty queue",
+			"error", err,
+			"moved_to", moved)
+		if err := m.loadState(); err != nil {
+			return fmt.Errorf("failed to load retry state: %w", err)
+		}
 	}
 
-	// Start background processor
-	m.wg.Add(1)
+	// Start the scheduler and a bounded pool of workers
+	concurrency := m.config.Retry.GetConcurrency()
+	m.wg.Add(1 + concurrency)
 	go m.processRetries(ctx)
+	for i := 0; i < concurrency; i++ {
+		go m.worker(ctx)
+	}
 
-	m.logger.Info("Retry manager started")
+	m.logger.Info("Retry manager started", "concurrency", concurrency)
 	return nil
 }
 
@@ -56,8 +86,8 @@ func (m *Manager) Stop() error {
 	close(m.stopCh)
 	m.wg.Wait()
 
-	// Save current state
-	if err := m.saveState(); err != nil {
+	// Fold the journal into the state file
+	if err := m.closeJournal(); err != nil {
 		m.logger.Error("Failed to save retry state", "error", err)
 		return err
 	}
@@ -68,22 +98,112 @@ func (m *Manager) Stop() error {
 
 // AddRequest adds a failed request to the retry queue
 func (m *Manager) AddRequest(req *webhook.DispatchRequest) {
+	m.AddRequestAfter(req, 0)
+}
+
+// AddFailedRequest adds a failed request to the retry queue. Requests that failed permanently or
+// used up their attempts are moved to the dead letter store instead. It reports whether the request
+// was queued.
+func (m *Manager) AddFailedRequest(req *webhook.DispatchRequest, result *webhook.DispatchResult) bool {
+	if result.Deferred() {
+		m.deferRequest(req, result.RetryAfter)
+		return true
+	}
+	if !result.Retryable() {
+		m.deadLetter(req, deadletter.ReasonPermanentFailure)
+		return false
+	}
+	if req.Attempt >= m.config.Retry.MaxAttempts {
+		m.deadLetter(req, deadletter.ReasonMaxAttempts)
+		return false
+	}
+
+	m.AddRequestAfter(req, result.RetryAfter)
+	return true
+}
+
+// deadLetter moves a request that will not be retried to the dead letter store
+func (m *Manager) deadLetter(req *webhook.DispatchRequest, reason string) {
+	entry, err := m.deadLetters.Add(req, reason)
+
+	m.mutex.Lock()
+	m.forget(req)
+	m.mutex.Unlock()
+
+	if err != nil {
+		m.logger.Error("Failed to store dead letter, dropping request",
+			"webhook_url", req.WebhookURL,
+			"streamer_key", req.StreamerKey,
+			"target_name", req.TargetName,
+			"reason", reason,
+			"error", err)
+		return
+	}
+
+	m.logger.Warn("Moved request to dead letter store",
+		"dead_letter_id", entry.ID,
+		"webhook_url", req.WebhookURL,
+		"streamer_key", req.StreamerKey,
+		"target_name", req.TargetName,
+		"reason", reason,
+		"attempts", len(req.History))
+}
+
+// AddRequestAfter adds a failed request to the retry queue. A positive retryAfter, e.g. from a
+// target's Retry-After header, replaces the backoff delay of the next attempt; it is capped at the
+// maximum delay, so a bogus header cannot hold a request for days.
+func (m *Manager) AddRequestAfter(req *webhook.DispatchRequest, retryAfter time.Duration) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	// Calculate next retry time
 	req.Attempt++
 	req.NextRetry = m.calculateNextRetry(req.Attempt)
-
-	m.queue = append(m.queue, req)
+	if retryAfter > 0 {
+		if retryAfter > m.config.Retry.MaxDelay {
+			m.logger.Warn("Retry-After exceeds the maximum retry delay, retrying earlier",
+				"streamer_key", req.StreamerKey,
+				"target_name", req.TargetName,
+				"retry_after", retryAfter,
+				"max_delay", m.config.Retry.MaxDelay)
+			retryAfter = m.config.Retry.MaxDelay
+		}
+		req.NextRetry = time.Now().Add(retryAfter)
+	}
+	m.enqueue(req)
 
 	m.logger.Info("Added request to retry queue",
 		"webhook_url", req.WebhookURL,
 		"streamer_key", req.StreamerKey,
+		"target_name", req.TargetName,
 		"attempt", req.Attempt,
 		"next_retry", req.NextRetry)
 }
 
+// deferRequest queues a request that was not sent, e.g. because the circuit breaker of its target
+// is open, to be sent after delay. The deferral does not use up an attempt.
+func (m *Manager) deferRequest(req *webhook.DispatchRequest, delay time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	req.NextRetry = time.Now().Add(delay)
+	m.enqueue(req)
+
+	m.logger.Debug("Deferred request",
+		"webhook_url", req.WebhookURL,
+		"streamer_key", req.StreamerKey,
+		"target_name", req.TargetName,
+		"attempt", req.Attempt,
+		"next_retry", req.NextRetry)
+}
+
+// enqueue persists a request and schedules it at its NextRetry; the caller must hold the mutex
+func (m *Manager) enqueue(req *webhook.DispatchRequest) {
+	m.persist(req)
+	m.queue.add(req)
+	m.wake()
+}
+
 // GetQueueSize returns the current size of the retry queue
 func (m *Manager) GetQueueSize() int {
 	m.mutex.RLock()
@@ -91,142 +211,278 @@ func (m *Manager) GetQueueSize() int {
 	return len(m.queue)
 }
 
-// processRetries runs the background retry processing loop
+// GetQueueSizeByTarget returns the number of queued requests per "streamer_key/target_name"
+func (m *Manager) GetQueueSizeByTarget() map[string]int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	sizes := make(map[string]int)
+	for _, req := range m.queue {
+		sizes[req.StreamerKey+"/"+req.TargetName]++
+	}
+	return sizes
+}
+
+// wake tells the scheduler to recompute when the next retry is due
+func (m *Manager) wake() {
+	select {
+	case m.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// processRetries runs the scheduler: it sleeps until the earliest retry is due and hands due
+// requests to the workers
 func (m *Manager) processRetries(ctx context.Context) {
 	defer m.wg.Done()
 
-	ticker := time.NewTicker(30 * time.Second) // Check every 30 seconds
-	defer ticker.Stop()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
 	for {
+		if !m.dispatchReady(ctx) {
+			return
+		}
+
+		// Sleep until the earliest retry; without queued requests only a wake-up ends the wait
+		var timerC <-chan time.Time
+		m.mutex.RLock()
+		next, ok := m.queue.next()
+		m.mutex.RUnlock()
+		if ok {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(next))
+			timerC = timer.C
+		}
+
 		select {
 		case <-ctx.Done():
 			return
 		case <-m.stopCh:
 			return
-		case <-ticker.C:
-			m.processReadyRetries(ctx)
+		case <-m.wakeCh:
+		case <-timerC:
 		}
 	}
 }
 
-// processReadyRetries processes requests that are ready for retry
-func (m *Manager) processReadyRetries(ctx context.Context) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// dispatchReady hands every due request to a worker, waiting while all workers are busy. It returns
+// false when the manager stops.
+func (m *Manager) dispatchReady(ctx context.Context) bool {
+	for {
+		m.mutex.Lock()
+		req, ok := m.queue.popReady(time.Now())
+		m.mutex.Unlock()
+		if !ok {
+			return true
+		}
 
-	now := time.Now()
-	readyRequests := make([]*webhook.DispatchRequest, 0)
-	remainingRequests := make([]*webhook.DispatchRequest, 0)
+		if req.Attempt > m.config.Retry.MaxAttempts {
+			// Max attempts reached, e.g. after max_attempts was lowered
+			m.deadLetter(req, deadletter.ReasonMaxAttempts)
+			continue
+		}
 
-	// Separate ready requests from remaining ones
-	for _, req := range m.queue {
-		if now.After(req.NextRetry) && req.Attempt <= m.config.Retry.MaxAttempts {
-			readyRequests = append(readyRequests, req)
-		} else if req.Attempt <= m.config.Retry.MaxAttempts {
-			remainingRequests = append(remainingRequests, req)
-		} else {
-			// Max attempts reached, drop the request
-			m.logger.Warn("Dropping request after max attempts",
-				"webhook_url", req.WebhookURL,
-				"streamer_key", req.StreamerKey,
-				"attempts", req.Attempt)
+		select {
+		case m.work <- req:
+		case <-ctx.Done():
+			m.requeue(req)
+			return false
+		case <-m.stopCh:
+			m.requeue(req)
+			return false
 		}
 	}
+}
 
-	m.queue = remainingRequests
+// requeue puts back a due request that no worker took; it is still persisted
+func (m *Manager) requeue(req *webhook.DispatchRequest) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.queue.add(req)
+}
 
-	// Process ready requests
-	for _, req := range readyRequests {
-		go m.retryRequest(ctx, req)
-	}
+// worker retries due requests until the manager stops
+func (m *Manager) worker(ctx context.Context) {
+	defer m.wg.Done()
 
-	if len(readyRequests) > 0 {
-		m.logger.Info("Processing retry requests",
-			"ready_count", len(readyRequests),
-			"remaining_count", len(remainingRequests))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.stopCh:
+			return
+		case req := <-m.work:
+			m.retryRequest(ctx, req)
+		}
 	}
 }
 
 // retryRequest attempts to retry a single request
 func (m *Manager) retryRequest(ctx context.Context, req *webhook.DispatchRequest) {
-	result := m.dispatcher.Dispatch(ctx, req)
+	// The request stays in pending while it is in flight, where compaction may read it, so the
+	// dispatcher records the attempt on a copy that is written back under the mutex
+	attempt := copyRequest(req)
+	if err := m.dispatcher.ResolveTarget(attempt); err != nil {
+		m.logger.Warn("Cannot retry request", "error", err)
+		m.deadLetter(req, deadletter.ReasonTargetRemoved)
+		return
+	}
+	result := m.dispatcher.Dispatch(ctx, attempt)
+	m.mutex.Lock()
+	*req = *attempt
+	m.mutex.Unlock()
 
 	if !result.Success {
 		// Add back to queue for another retry
-		m.AddRequest(req)
+		m.AddFailedRequest(req, result)
 	} else {
+		m.mutex.Lock()
+		m.forget(req)
+		m.mutex.Unlock()
+
 		m.logger.Info("Retry successful",
 			"webhook_url", req.WebhookURL,
 			"streamer_key", req.StreamerKey,
+			"target_name", req.TargetName,
 			"attempt", req.Attempt)
 	}
 }
 
 // calculateNextRetry calculates the next retry time using exponential backoff
 func (m *Manager) calculateNextRetry(attempt int) time.Time {
-	// Start with initial delay
-	delay := m.config.Retry.InitialDelay
+	maxDelay := float64(m.config.Retry.MaxDelay)
 
-	// Apply exponential backoff
+	// Apply exponential backoff to the initial delay and cap it at max delay; capping before
+	// converting to a duration keeps high attempt numbers from overflowing
 	backoffMultiplier := math.Pow(m.config.Retry.BackoffFactor, float64(attempt-1))
-	delay = time.Duration(float64(delay) * backoffMultiplier)
-
-	// Cap at max delay
-	if delay > m.config.Retry.MaxDelay {
-		delay = m.config.Retry.MaxDelay
+	delay := min(float64(m.config.Retry.InitialDelay)*backoffMultiplier, maxDelay)
+
+	// Spread retries of requests that failed together, e.g. when a target was down. The range is
+	// clamped to the max delay rather than the result, so capped retries are spread as well.
+	if jitter := m.config.Retry.Jitter; jitter > 0 {
+		low := delay * (1 - jitter)
+		high := min(delay*(1+jitter), maxDelay)
+		delay = low + (high-low)*mathrand.Float64()
 	}
 
-	return time.Now().Add(delay)
+	return time.Now().Add(time.Duration(delay))
 }
 
-// loadState loads retry state from disk
+// loadState recovers the retry queue from the state file and its journal, and keeps the journal
+// open to persist every change of the queue
 func (m *Manager) loadState() error {
-	if _, err := os.Stat(m.config.Retry.StateFile); os.IsNotExist(err) {
-		return nil // No state file exists yet
-	}
-
-	data, err := os.ReadFile(m.config.Retry.StateFile)
+	journal, pending, err := openJournal(m.config.Retry.StateFile)
 	if err != nil {
-		return fmt.Errorf("failed to read state file: %w", err)
+		return err
 	}
 
-	var state struct {
-		Queue []*webhook.DispatchRequest `json:"queue"`
-	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-	if err := json.Unmarshal(data, &state); err != nil {
-		return fmt.Errorf("failed to unmarshal state: %w", err)
+	// Requests queued before the state was loaded
+	for id, req := range m.pending {
+		pending[id] = req
+		if err := journal.put(req); err != nil {
+			m.logger.Warn("Failed to persist retry request", "error", err)
+		}
 	}
 
-	m.mutex.Lock()
-	m.queue = state.Queue
-	m.mutex.Unlock()
+	m.journal = journal
+	m.pending = pending
+	m.queue = make(schedule, 0, len(pending))
+	for _, req := range pending {
+		m.queue.add(req)
+	}
 
-	m.logger.Info("Loaded retry state", "queue_size", len(state.Queue))
+	m.logger.Info("Loaded retry state", "queue_size", len(m.queue))
 	return nil
 }
 
-// saveState saves retry state to disk
-func (m *Manager) saveState() error {
-	m.mutex.RLock()
-	state := struct {
-		Queue []*webhook.DispatchRequest `json:"queue"`
-	}{
-		Queue: m.queue,
+// persist records a queued request in the journal. The caller must hold the mutex.
+func (m *Manager) persist(req *webhook.DispatchRequest) {
+	if req.RetryID == "" {
+		id, err := newRetryID()
+		if err != nil {
+			m.logger.Warn("Failed to persist retry request", "error", err)
+			return
+		}
+		req.RetryID = id
 	}
-	m.mutex.RUnlock()
+	m.pending[req.RetryID] = req
 
-	data, err := json.MarshalIndent(state, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal state: %w", err)
+	if m.journal == nil {
+		return
+	}
+	if err := m.journal.put(req); err != nil {
+		m.logger.Error("Failed to persist retry request", "retry_id", req.RetryID, "error", err)
+		return
 	}
+	m.compactIfNeeded()
+}
 
-	if err := os.WriteFile(m.config.Retry.StateFile, data, 0644); err != nil {
-		return fmt.Errorf("failed to write state file: %w", err)
+// forget records that a request left the queue. The caller must hold the mutex.
+func (m *Manager) forget(req *webhook.DispatchRequest) {
+	if _, ok := m.pending[req.RetryID]; !ok || req.RetryID == "" {
+		return
 	}
+	delete(m.pending, req.RetryID)
 
-	return nil
+	if m.journal == nil {
+		return
+	}
+	if err := m.journal.delete(req.RetryID); err != nil {
+		m.logger.Error("Failed to persist retry request removal", "retry_id", req.RetryID, "error", err)
+		return
+	}
+	m.compactIfNeeded()
+}
+
+// compactIfNeeded folds a grown journal into the state file. The caller must hold the mutex.
+func (m *Manager) compactIfNeeded() {
+	if !m.journal.needsCompaction() {
+		return
+	}
+	if err := m.journal.compact(m.pending); err != nil {
+		m.logger.Error("Failed to compact retry journal", "error", err)
+	}
+}
+
+// closeJournal compacts and closes the journal
+func (m *Manager) closeJournal() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.journal == nil {
+		return nil
+	}
+	err := m.journal.compact(m.pending)
+	if closeErr := m.journal.close(); err == nil {
+		err = closeErr
+	}
+	m.journal = nil
+	return err
+}
+
+// copyRequest returns a copy of a request whose attempt history can be appended to independently
+func copyRequest(req *webhook.DispatchRequest) *webhook.DispatchRequest {
+	c := *req
+	c.History = append([]webhook.AttemptRecord(nil), req.History...)
+	return &c
+}
+
+// newRetryID returns a random ID for a queued request
+func newRetryID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate retry ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
 }
 
 // UpdateConfig updates the retry manager configuration
@@ -0,0 +1,251 @@
+package retry
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/rmoriz/itsjustintv/internal/deadletter"
+	"github.com/rmoriz/itsjustintv/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	cfg := config.DefaultConfig()
+	cfg.Retry.StateFile = t.TempDir() + "/retry_state.json"
+	cfg.Retry.DeadLetterFile = t.TempDir() + "/dead_letters.json"
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	return NewManager(cfg, logger, webhook.NewDispatcher(cfg, logger))
+}
+
+// addTestTarget configures the cms target of test_streamer, which test requests are delivered to
+func addTestTarget(manager *Manager, url string) {
+	manager.config.Streamers["test_streamer"] = config.StreamerConfig{
+		Login:   "teststreamer",
+		Targets: []config.WebhookTargetConfig{{Name: "cms", URL: url}},
+	}
+}
+
+func TestAddFailedRequestSkipsPermanentFailures(t *testing.T) {
+	manager := newTestManager(t)
+
+	queued := manager.AddFailedRequest(&webhook.DispatchRequest{StreamerKey: "test_streamer", Attempt: 1},
+		&webhook.DispatchResult{StatusCode: 404, FailureClass: webhook.FailurePermanent})
+	assert.False(t, queued)
+	assert.Equal(t, 0, manager.GetQueueSize())
+
+	queued = manager.AddFailedRequest(&webhook.DispatchRequest{StreamerKey: "test_streamer", Attempt: 1},
+		&webhook.DispatchResult{StatusCode: 503, FailureClass: webhook.FailureServerError})
+	assert.True(t, queued)
+	assert.Equal(t, 1, manager.GetQueueSize())
+
+	entries, err := manager.DeadLetters().List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, deadletter.ReasonPermanentFailure, entries[0].Reason)
+}
+
+func TestAddFailedRequestDeadLettersExhaustedRequests(t *testing.T) {
+	manager := newTestManager(t)
+
+	req := &webhook.DispatchRequest{
+		StreamerKey: "test_streamer",
+		TargetName:  "cms",
+		Attempt:     manager.config.Retry.MaxAttempts,
+		History:     []webhook.AttemptRecord{{Attempt: 1, StatusCode: 503}, {Attempt: 2, StatusCode: 503}, {Attempt: 3, StatusCode: 502}},
+	}
+	queued := manager.AddFailedRequest(req, &webhook.DispatchResult{StatusCode: 502, FailureClass: webhook.FailureServerError})
+	assert.False(t, queued)
+	assert.Equal(t, 0, manager.GetQueueSize())
+
+	entries, err := manager.DeadLetters().List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, deadletter.ReasonMaxAttempts, entries[0].Reason)
+	assert.Len(t, entries[0].Request.History, 3)
+}
+
+func TestAddRequestAfterOverridesBackoff(t *testing.T) {
+	manager := newTestManager(t)
+
+	// The backoff of the second attempt is far shorter than the requested delay
+	req := &webhook.DispatchRequest{StreamerKey: "test_streamer", Attempt: 1}
+	manager.AddRequestAfter(req, 3*time.Minute)
+	assert.Equal(t, 2, req.Attempt)
+	assert.WithinDuration(t, time.Now().Add(3*time.Minute), req.NextRetry, time.Second)
+
+	// ...and it also wins when it is shorter
+	req = &webhook.DispatchRequest{StreamerKey: "test_streamer", Attempt: 5}
+	manager.AddRequestAfter(req, time.Second)
+	assert.WithinDuration(t, time.Now().Add(time.Second), req.NextRetry, 500*time.Millisecond)
+
+	req = &webhook.DispatchRequest{StreamerKey: "test_streamer", Attempt: 1}
+	manager.AddRequestAfter(req, 0)
+	assert.WithinDuration(t, time.Now().Add(manager.config.Retry.InitialDelay*2), req.NextRetry, time.Second)
+}
+
+func TestSchedulerRetriesWhenDue(t *testing.T) {
+	delivered := make(chan time.Time, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- time.Now()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	manager := newTestManager(t)
+	addTestTarget(manager, server.URL)
+	require.NoError(t, manager.Start(context.Background()))
+	defer manager.Stop()
+
+	// Far shorter than the old 30 second polling interval
+	queuedAt := time.Now()
+	manager.AddRequestAfter(&webhook.DispatchRequest{StreamerKey: "test_streamer", TargetName: "cms", Attempt: 1}, 200*time.Millisecond)
+
+	select {
+	case at := <-delivered:
+		assert.GreaterOrEqual(t, at.Sub(queuedAt), 200*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry was not delivered when due")
+	}
+	assert.Eventually(t, func() bool {
+		manager.mutex.RLock()
+		defer manager.mutex.RUnlock()
+		return len(manager.pending) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSchedulerLimitsConcurrency(t *testing.T) {
+	var inFlight, maxInFlight, delivered atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		for {
+			max := maxInFlight.Load()
+			if n <= max || maxInFlight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		inFlight.Add(-1)
+		delivered.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	manager := newTestManager(t)
+	addTestTarget(manager, server.URL)
+	manager.config.Retry.Concurrency = 2
+	require.NoError(t, manager.Start(context.Background()))
+	defer manager.Stop()
+
+	for i := 0; i < 8; i++ {
+		manager.AddRequestAfter(&webhook.DispatchRequest{StreamerKey: "test_streamer", TargetName: "cms", Attempt: 1}, 10*time.Millisecond)
+	}
+
+	assert.Eventually(t, func() bool { return delivered.Load() == 8 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), maxInFlight.Load())
+}
+
+func TestCalculateNextRetryJitter(t *testing.T) {
+	manager := newTestManager(t)
+	manager.config.Retry.InitialDelay = time.Second
+	manager.config.Retry.BackoffFactor = 2.0
+	manager.config.Retry.MaxDelay = time.Minute
+
+	manager.config.Retry.Jitter = 0
+	assert.WithinDuration(t, time.Now().Add(4*time.Second), manager.calculateNextRetry(3), 100*time.Millisecond)
+
+	manager.config.Retry.Jitter = 0.5
+	spread := make(map[int64]bool)
+	for i := 0; i < 50; i++ {
+		delay := time.Until(manager.calculateNextRetry(3))
+		assert.GreaterOrEqual(t, delay, 2*time.Second-100*time.Millisecond)
+		assert.LessOrEqual(t, delay, 6*time.Second)
+		spread[int64(delay/(100*time.Millisecond))] = true
+	}
+	assert.Greater(t, len(spread), 1, "jitter should vary the delay")
+
+	// Retries past the cap are spread below it rather than all landing on max_delay
+	spread = make(map[int64]bool)
+	for attempt := 10; attempt < 60; attempt++ {
+		delay := time.Until(manager.calculateNextRetry(attempt))
+		assert.LessOrEqual(t, delay, time.Minute)
+		assert.GreaterOrEqual(t, delay, 30*time.Second-100*time.Millisecond)
+		spread[int64(delay/time.Second)] = true
+	}
+	assert.Greater(t, len(spread), 10, "capped retries should not fire in lockstep")
+}
+
+func TestAddRequestAfterCapsRetryAfter(t *testing.T) {
+	manager := newTestManager(t)
+	manager.config.Retry.MaxDelay = time.Minute
+
+	honored := &webhook.DispatchRequest{StreamerKey: "test_streamer", TargetName: "cms", Attempt: 1}
+	manager.AddRequestAfter(honored, 30*time.Second)
+	assert.WithinDuration(t, time.Now().Add(30*time.Second), honored.NextRetry, time.Second)
+
+	capped := &webhook.DispatchRequest{StreamerKey: "test_streamer", TargetName: "cms", Attempt: 1}
+	manager.AddRequestAfter(capped, 48*time.Hour)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), capped.NextRetry, time.Second)
+}
+
+func TestAddFailedRequestDefersWithoutUsingAttempts(t *testing.T) {
+	manager := newTestManager(t)
+
+	req := &webhook.DispatchRequest{StreamerKey: "test_streamer", Attempt: manager.config.Retry.MaxAttempts}
+	queued := manager.AddFailedRequest(req, &webhook.DispatchResult{FailureClass: webhook.FailureCircuitOpen, RetryAfter: 30 * time.Second})
+	assert.True(t, queued)
+	assert.Equal(t, 1, manager.GetQueueSize())
+	assert.Equal(t, manager.config.Retry.MaxAttempts, req.Attempt)
+	assert.WithinDuration(t, time.Now().Add(30*time.Second), req.NextRetry, time.Second)
+
+	entries, err := manager.DeadLetters().List()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestRetryUsesCurrentTargetConfig(t *testing.T) {
+	var authorization atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization.Store(r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	manager := newTestManager(t)
+	addTestTarget(manager, "https://old.example.com/hook")
+	require.NoError(t, manager.Start(context.Background()))
+	defer manager.Stop()
+
+	// Queued with the old settings, then the target is moved and its token rotated
+	req := webhook.NewDispatchRequest("test_streamer", config.WebhookTargetConfig{Name: "cms", URL: "https://old.example.com/hook", BearerToken: "old"}, webhook.WebhookPayload{}, "")
+	manager.AddRequestAfter(req, 100*time.Millisecond)
+	newConfig := *manager.config
+	newConfig.Streamers = map[string]config.StreamerConfig{
+		"test_streamer": {Targets: []config.WebhookTargetConfig{{Name: "cms", URL: server.URL, BearerToken: "new"}}},
+	}
+	manager.dispatcher.UpdateConfig(&newConfig)
+
+	assert.Eventually(t, func() bool { return authorization.Load() == "Bearer new" }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRetryDeadLettersRemovedTargets(t *testing.T) {
+	manager := newTestManager(t)
+	require.NoError(t, manager.Start(context.Background()))
+	defer manager.Stop()
+
+	manager.AddRequestAfter(&webhook.DispatchRequest{StreamerKey: "test_streamer", TargetName: "removed", Attempt: 1}, 10*time.Millisecond)
+
+	var entries []*deadletter.Entry
+	assert.Eventually(t, func() bool {
+		entries, _ = manager.DeadLetters().List()
+		return len(entries) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, deadletter.ReasonTargetRemoved, entries[0].Reason)
+}
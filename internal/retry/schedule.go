@@ -0,0 +1,49 @@
+package retry
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/webhook"
+)
+
+// schedule is a min-heap of queued requests ordered by their next retry time
+type schedule []*webhook.DispatchRequest
+
+func (s schedule) Len() int           { return len(s) }
+func (s schedule) Less(i, j int) bool { return s[i].NextRetry.Before(s[j].NextRetry) }
+func (s schedule) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (s *schedule) Push(x any) {
+	*s = append(*s, x.(*webhook.DispatchRequest))
+}
+
+func (s *schedule) Pop() any {
+	old := *s
+	n := len(old)
+	req := old[n-1]
+	old[n-1] = nil
+	*s = old[:n-1]
+	return req
+}
+
+// add queues a request
+func (s *schedule) add(req *webhook.DispatchRequest) {
+	heap.Push(s, req)
+}
+
+// next returns the time of the earliest retry
+func (s schedule) next() (time.Time, bool) {
+	if len(s) == 0 {
+		return time.Time{}, false
+	}
+	return s[0].NextRetry, true
+}
+
+// popReady removes and returns the earliest request if it is due at now
+func (s *schedule) popReady(now time.Time) (*webhook.DispatchRequest, bool) {
+	if len(*s) == 0 || (*s)[0].NextRetry.After(now) {
+		return nil, false
+	}
+	return heap.Pop(s).(*webhook.DispatchRequest), true
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rmoriz/itsjustintv/internal/deadletter"
+	"github.com/rmoriz/itsjustintv/internal/webhook"
+)
+
+// setupAdminRoutes configures the admin endpoints; they are only served when an admin token is set
+func (s *Server) setupAdminRoutes(mux *http.ServeMux) {
+	if s.config.Server.AdminToken == "" {
+		return
+	}
+
+	mux.HandleFunc("GET /admin/dead-letters", s.instrumentHandler(s.requireAdmin(s.handleListDeadLetters), "admin_dead_letters_list"))
+	mux.HandleFunc("DELETE /admin/dead-letters", s.instrumentHandler(s.requireAdmin(s.handlePurgeDeadLetters), "admin_dead_letters_purge"))
+	mux.HandleFunc("GET /admin/dead-letters/{id}", s.instrumentHandler(s.requireAdmin(s.handleGetDeadLetter), "admin_dead_letters_get"))
+	mux.HandleFunc("DELETE /admin/dead-letters/{id}", s.instrumentHandler(s.requireAdmin(s.handleDeleteDeadLetter), "admin_dead_letters_delete"))
+	mux.HandleFunc("POST /admin/dead-letters/{id}/replay", s.instrumentHandler(s.requireAdmin(s.handleReplayDeadLetter), "admin_dead_letters_replay"))
+}
+
+// requireAdmin rejects requests without the admin token as bearer token
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Server.AdminToken)) != 1 {
+			s.logger.Warn("Unauthorized admin request", "remote_addr", r.RemoteAddr, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="itsjustintv"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleListDeadLetters lists the dead letters
+func (s *Server) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.retryManager.DeadLetters().List()
+	if err != nil {
+		s.writeAdminError(w, err)
+		return
+	}
+	if entries == nil {
+		entries = []*deadletter.Entry{}
+	}
+	s.writeAdminJSON(w, http.StatusOK, map[string]interface{}{"dead_letters": entries})
+}
+
+// handleGetDeadLetter returns a dead letter with its attempt history
+func (s *Server) handleGetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	entry, err := s.retryManager.DeadLetters().Get(r.PathValue("id"))
+	if err != nil {
+		s.writeAdminError(w, err)
+		return
+	}
+	s.writeAdminJSON(w, http.StatusOK, entry)
+}
+
+// handleReplayDeadLetter delivers a dead letter again
+func (s *Server) handleReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	result, err := s.retryManager.DeadLetters().Replay(r.Context(), s.webhookDispatcher, id)
+	if err != nil {
+		s.writeAdminError(w, err)
+		return
+	}
+
+	s.logger.Info("Replayed dead letter", "dead_letter_id", id, "success", result.Success, "status_code", result.StatusCode)
+	s.writeAdminJSON(w, http.StatusOK, result)
+}
+
+// handleDeleteDeadLetter deletes a single dead letter
+func (s *Server) handleDeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if err := s.retryManager.DeadLetters().Remove(r.PathValue("id")); err != nil {
+		s.writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePurgeDeadLetters deletes all dead letters
+func (s *Server) handlePurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	purged, err := s.retryManager.DeadLetters().Purge()
+	if err != nil {
+		s.writeAdminError(w, err)
+		return
+	}
+
+	s.logger.Info("Purged dead letters", "count", purged)
+	s.writeAdminJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
+
+// writeAdminJSON writes a JSON response of an admin endpoint
+func (s *Server) writeAdminJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.logger.Warn("Failed to write admin response", "error", err)
+	}
+}
+
+// writeAdminError writes the error of an admin endpoint
+func (s *Server) writeAdminError(w http.ResponseWriter, err error) {
+	if errors.Is(err, deadletter.ErrNotFound) {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, webhook.ErrTargetNotConfigured) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	s.logger.Error("Admin request failed", "error", err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/rmoriz/itsjustintv/internal/deadletter"
+	"github.com/rmoriz/itsjustintv/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminDeadLetters(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Output.Enabled = false
+	cfg.Server.AdminToken = "admin_token"
+	cfg.Retry.DeadLetterFile = filepath.Join(t.TempDir(), "dead_letters.json")
+	cfg.Streamers["test_streamer"] = config.StreamerConfig{
+		Login:   "teststreamer",
+		Targets: []config.WebhookTargetConfig{{Name: "cms", URL: target.URL}},
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	server := New(cfg, logger)
+
+	mux := http.NewServeMux()
+	server.setupRoutes(mux)
+
+	store := server.retryManager.DeadLetters()
+	first, err := store.Add(&webhook.DispatchRequest{WebhookURL: target.URL, WebhookSecret: "secret", StreamerKey: "test_streamer", TargetName: "cms", Attempt: 3}, deadletter.ReasonMaxAttempts)
+	require.NoError(t, err)
+	second, err := store.Add(&webhook.DispatchRequest{WebhookURL: target.URL, StreamerKey: "test_streamer", TargetName: "queue", Attempt: 1}, deadletter.ReasonPermanentFailure)
+	require.NoError(t, err)
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/admin/dead-letters", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/admin/dead-letters", "wrong").Code)
+
+	w := request(http.MethodGet, "/admin/dead-letters", "admin_token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		DeadLetters []deadletter.Entry `json:"dead_letters"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.DeadLetters, 2)
+	assert.NotContains(t, w.Body.String(), "secret", "target credentials are not stored")
+
+	w = request(http.MethodGet, "/admin/dead-letters/"+first.ID, "admin_token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"reason":"max_attempts"`)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, "/admin/dead-letters/unknown", "admin_token").Code)
+
+	w = request(http.MethodPost, "/admin/dead-letters/"+first.ID+"/replay", "admin_token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"success":true`)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/admin/dead-letters/"+first.ID, "admin_token").Code)
+
+	// The target of the second dead letter is not configured
+	assert.Equal(t, http.StatusConflict, request(http.MethodPost, "/admin/dead-letters/"+second.ID+"/replay", "admin_token").Code)
+
+	w = request(http.MethodDelete, "/admin/dead-letters", "admin_token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"purged":1}`, w.Body.String())
+}
+
+func TestAdminRoutesRequireToken(t *testing.T) {
+	cfg := config.DefaultConfig()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	server := New(cfg, logger)
+
+	mux := http.NewServeMux()
+	server.setupRoutes(mux)
+
+	// Without an admin token the admin endpoints are not served
+	req := httptest.NewRequest(http.MethodGet, "/admin/dead-letters", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+}
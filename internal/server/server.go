@@ -4,12 +4,15 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 
@@ -30,7 +33,7 @@ type Server struct {
 	httpServer          *http.Server
 	logger              *slog.Logger
 	certManager         *autocert.Manager
-	webhookValidator    *webhook.Validator
+	eventSubVerifier    *twitch.EventSubVerifier
 	twitchProcessor     *twitch.Processor
 	webhookDispatcher   *webhook.Dispatcher
 	retryManager        *retry.Manager
@@ -39,8 +42,12 @@ type Server struct {
 	enricher            *twitch.Enricher
 	outputWriter        *output.Writer
 	subscriptionManager *twitch.SubscriptionManager
+	webSocketClient     *twitch.WebSocketClient
 	telemetryManager    *telemetry.Manager
 	configWatcher       *config.Watcher
+
+	// revocations are handled after they were acknowledged
+	revocations sync.WaitGroup
 }
 
 // New creates a new server instance
@@ -53,12 +60,34 @@ func New(cfg *config.Config, logger *slog.Logger) *Server {
 	outputWriter := output.NewWriter(cfg, logger)
 	subscriptionManager := twitch.NewSubscriptionManager(cfg, logger, twitchClient)
 	telemetryManager := telemetry.NewManager(cfg, logger)
+	telemetryManager.ObserveCircuitBreakers(webhookDispatcher.CircuitBreakers)
+	twitchProcessor := twitch.NewProcessor(cfg, logger)
+
+	webSocketClient := twitch.NewWebSocketClient(cfg, logger, twitchProcessor)
+
+	// Channel info fetched during enrichment is the baseline for channel.update diffs
+	enricher.SetChannelInfoHandler(twitchProcessor.RecordChannelInfo)
+
+	// Events of streamers disabled after a revocation are not processed
+	twitchProcessor.SetDisabledCheck(subscriptionManager.IsStreamerDisabled)
+
+	// Problems with the user access token of the WebSocket transport are reported to the operator
+	subscriptionManager.SetAlertHandler(func(alert webhook.AlertPayload) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if result := webhookDispatcher.DispatchAlert(ctx, alert); result != nil && !result.Success {
+			logger.Warn("Failed to send operator alert",
+				"alert", alert.Alert,
+				"error", result.Error,
+				"status_code", result.StatusCode)
+		}
+	})
 
-	return &Server{
+	s := &Server{
 		config:              cfg,
 		logger:              logger,
-		webhookValidator:    webhook.NewValidator(cfg.Twitch.WebhookSecret),
-		twitchProcessor:     twitch.NewProcessor(cfg, logger),
+		eventSubVerifier:    newEventSubVerifier(cfg.Twitch),
+		twitchProcessor:     twitchProcessor,
 		webhookDispatcher:   webhookDispatcher,
 		retryManager:        retryManager,
 		cacheManager:        cacheManager,
@@ -66,9 +95,16 @@ func New(cfg *config.Config, logger *slog.Logger) *Server {
 		enricher:            enricher,
 		outputWriter:        outputWriter,
 		subscriptionManager: subscriptionManager,
+		webSocketClient:     webSocketClient,
 		telemetryManager:    telemetryManager,
 		configWatcher:       nil, // Will be initialized in Start
 	}
+
+	// The WebSocket transport feeds notifications into the same processing path as /twitch
+	webSocketClient.SetEventHandler(s.processStreamEvent)
+	webSocketClient.SetSessionHandler(s.handleWebSocketSession)
+
+	return s
 }
 
 // Start starts the HTTP server with optional HTTPS
@@ -94,6 +130,9 @@ func (s *Server) Start(ctx context.Context) error {
 		// Don't fail startup, just log the warning
 	}
 
+	// Fetch the baseline channel.update changes are diffed against
+	s.twitchProcessor.SeedChannelInfo(ctx, s.twitchClient.GetChannelInfo)
+
 	// Start enricher
 	if err := s.enricher.Start(); err != nil {
 		return fmt.Errorf("failed to start enricher: %w", err)
@@ -104,6 +143,12 @@ func (s *Server) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to start cache manager: %w", err)
 	}
 
+	// Load the IDs of go-live messages to edit or delete on stream.offline, before retries are sent
+	sentMessagesFile := filepath.Join(filepath.Dir(s.config.Retry.StateFile), "sent_messages.json")
+	if err := s.webhookDispatcher.LoadSentMessages(sentMessagesFile); err != nil {
+		s.logger.Warn("Failed to load sent messages, go-live messages sent before the restart are not edited or deleted", "error", err)
+	}
+
 	// Start retry manager
 	if err := s.retryManager.Start(ctx); err != nil {
 		return fmt.Errorf("failed to start retry manager: %w", err)
@@ -151,11 +196,28 @@ func (s *Server) Start(ctx context.Context) error {
 	// Wait a moment for the server to start listening
 	time.Sleep(100 * time.Millisecond)
 
+	// Recreate webhook subscriptions with webhook_secret once it was rotated
+	if err := s.subscriptionManager.LoadWebhookSecretFingerprint(twitch.WebhookSecretStateFile(s.config)); err != nil {
+		s.logger.Warn("Failed to load webhook secret state, subscriptions are not recreated after a secret rotation", "error", err)
+	}
+
 	// Start subscription manager AFTER HTTP server is running
 	if err := s.subscriptionManager.Start(ctx); err != nil {
 		return fmt.Errorf("failed to start subscription manager: %w", err)
 	}
 
+	// Connect to EventSub over WebSocket instead of waiting for callbacks
+	if s.config.Twitch.Transport == config.TransportWebSocket {
+		webSocketCtx, cancelWebSocket := context.WithCancel(ctx)
+		defer cancelWebSocket()
+
+		go func() {
+			if err := s.webSocketClient.Run(webSocketCtx); err != nil {
+				s.logger.Error("EventSub WebSocket client stopped", "error", err)
+			}
+		}()
+	}
+
 	// Wait for shutdown signal or server error
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
@@ -188,6 +250,9 @@ func (s *Server) Start(ctx context.Context) error {
 		}
 	}
 
+	// Let revocations that are being handled send their alerts
+	s.revocations.Wait()
+
 	// Stop managers
 	if err := s.retryManager.Stop(); err != nil {
 		s.logger.Error("Retry manager stop error", "error", err)
@@ -226,6 +291,9 @@ func (s *Server) setupRoutes(mux *http.ServeMux) {
 	// Twitch webhook endpoint
 	mux.HandleFunc("/twitch", s.instrumentHandler(s.handleTwitchWebhook, "twitch_webhook"))
 
+	// Admin endpoints
+	s.setupAdminRoutes(mux)
+
 	// Root endpoint
 	mux.HandleFunc("/", s.instrumentHandler(s.handleRoot, "root"))
 }
@@ -297,6 +365,14 @@ func (s *Server) startConfigWatcher(ctx context.Context) error {
 func (s *Server) handleConfigReload(newConfig *config.Config) error {
 	ctx := context.Background()
 
+	// Reject templates that would fail on live dispatches, keeping the current configuration
+	if err := webhook.ValidatePayloadTemplates(newConfig); err != nil {
+		if s.telemetryManager != nil {
+			s.telemetryManager.RecordConfigReload(ctx, false)
+		}
+		return fmt.Errorf("invalid payload template: %w", err)
+	}
+
 	// Record config reload metric
 	if s.telemetryManager != nil {
 		s.telemetryManager.RecordConfigReload(ctx, true)
@@ -306,6 +382,16 @@ func (s *Server) handleConfigReload(newConfig *config.Config) error {
 
 	// Update config reference
 	s.config = newConfig
+	s.eventSubVerifier = newEventSubVerifier(newConfig.Twitch)
+
+	// Update the processor and fetch the channel.update baseline of added streamers before they are
+	// subscribed
+	if s.twitchProcessor != nil {
+		s.twitchProcessor.UpdateConfig(newConfig)
+		if s.twitchClient != nil {
+			s.twitchProcessor.SeedChannelInfo(ctx, s.twitchClient.GetChannelInfo)
+		}
+	}
 
 	// Update subscription manager with new config
 	if s.subscriptionManager != nil {
@@ -317,8 +403,12 @@ func (s *Server) handleConfigReload(newConfig *config.Config) error {
 			return fmt.Errorf("failed to update subscription manager: %w", err)
 		}
 
-		// Refresh subscriptions based on new configuration
-		if err := s.subscriptionManager.RefreshSubscriptions(ctx); err != nil {
+		// Refresh subscriptions based on new configuration. Subscriptions that could not be
+		// changed are retried by the background sync.
+		var syncErr *twitch.SyncFailedError
+		if err := s.subscriptionManager.RefreshSubscriptions(ctx); errors.As(err, &syncErr) {
+			s.logger.Error("Subscription refresh incomplete", "error", err)
+		} else if err != nil {
 			s.logger.Error("Failed to refresh subscriptions", "error", err)
 			if s.telemetryManager != nil {
 				s.telemetryManager.RecordConfigReload(ctx, false)
@@ -327,6 +417,11 @@ func (s *Server) handleConfigReload(newConfig *config.Config) error {
 		}
 	}
 
+	// Update WebSocket client with new config
+	if s.webSocketClient != nil {
+		s.webSocketClient.UpdateConfig(newConfig)
+	}
+
 	// Update webhook dispatcher with new config
 	if s.webhookDispatcher != nil {
 		s.webhookDispatcher.UpdateConfig(newConfig)
@@ -404,11 +499,21 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	response := struct {
+		Status          string                  `json:"status"`
+		Service         string                  `json:"service"`
+		Timestamp       string                  `json:"timestamp"`
+		CircuitBreakers []webhook.CircuitStatus `json:"circuit_breakers"`
+	}{
+		Status:          "healthy",
+		Service:         "itsjustintv",
+		Timestamp:       time.Now().UTC().Format(time.RFC3339),
+		CircuitBreakers: s.webhookDispatcher.CircuitBreakers(),
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-
-	response := `{"status":"healthy","service":"itsjustintv","timestamp":"` + time.Now().UTC().Format(time.RFC3339) + `"}`
-	_, _ = w.Write([]byte(response))
+	_ = json.NewEncoder(w).Encode(response)
 
 	s.logger.Debug("Health check requested", "remote_addr", r.RemoteAddr)
 }
@@ -447,7 +552,7 @@ func (s *Server) handleTwitchWebhook(w http.ResponseWriter, r *http.Request) {
 		"message_id", headers.MessageID)
 
 	// Validate HMAC signature
-	if err := s.webhookValidator.ValidateSignature(body, headers.MessageSignature); err != nil {
+	if err := s.eventSubVerifier.Verify(headers, body); err != nil {
 		s.logger.Warn("Invalid webhook signature",
 			"error", err,
 			"remote_addr", r.RemoteAddr,
@@ -456,12 +561,39 @@ func (s *Server) handleTwitchWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Reject stale messages that could be replays
+	if err := checkMessageTimestamp(headers.MessageTimestamp, time.Now()); err != nil {
+		s.logger.Warn("Rejected EventSub message",
+			"error", err,
+			"remote_addr", r.RemoteAddr,
+			"message_id", headers.MessageID,
+			"message_timestamp", headers.MessageTimestamp)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Answer redeliveries of already handled messages without processing them again.
+	// Verification challenges are idempotent and always answered.
+	trackMessage := headers.MessageType != twitch.MessageTypeWebhookCallbackVerification
+	if trackMessage && s.cacheManager.MarkMessageSeen(headers.MessageID, messageIDTTL) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"status":"duplicate"}`))
+		s.logger.Info("Duplicate EventSub message, skipping",
+			"message_id", headers.MessageID,
+			"message_retry", headers.MessageRetry)
+		return
+	}
+
 	// Process the notification
 	processedEvent, err := s.twitchProcessor.ProcessNotification(headers, body)
 	if err != nil {
 		s.logger.Error("Failed to process notification",
 			"error", err,
 			"message_id", headers.MessageID)
+		if trackMessage {
+			s.cacheManager.ForgetMessage(headers.MessageID)
+		}
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -482,6 +614,8 @@ func (s *Server) handleTwitchWebhook(w http.ResponseWriter, r *http.Request) {
 			s.logger.Error("Failed to process stream event",
 				"error", err,
 				"message_id", headers.MessageID)
+			// Let Twitch's retry of this message through
+			s.cacheManager.ForgetMessage(headers.MessageID)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -516,6 +650,55 @@ func (s *Server) handleTwitchWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// newEventSubVerifier creates the verifier of incoming EventSub messages
+func newEventSubVerifier(twitchConfig config.TwitchConfig) *twitch.EventSubVerifier {
+	return twitch.NewEventSubVerifier(twitchConfig.WebhookSecret, twitchConfig.PreviousWebhookSecrets, twitchConfig.PreviousWebhookSecretsUntil)
+}
+
+// maxMessageAge is the maximum age of an EventSub message before it is rejected as a possible replay
+const maxMessageAge = 10 * time.Minute
+
+// messageIDTTL is how long EventSub message IDs are remembered; it outlives maxMessageAge so a
+// replay is either too old or a known duplicate
+const messageIDTTL = 15 * time.Minute
+
+// checkMessageTimestamp verifies an EventSub message timestamp is within maxMessageAge of now
+func checkMessageTimestamp(timestamp string, now time.Time) error {
+	if timestamp == "" {
+		return fmt.Errorf("missing message timestamp")
+	}
+
+	sentAt, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid message timestamp: %w", err)
+	}
+
+	age := now.Sub(sentAt)
+	if age > maxMessageAge {
+		return fmt.Errorf("message is %s old", age.Round(time.Second))
+	}
+	if age < -maxMessageAge {
+		return fmt.Errorf("message timestamp is %s in the future", (-age).Round(time.Second))
+	}
+
+	return nil
+}
+
+// handleWebSocketSession binds subscriptions to a newly established EventSub WebSocket session
+func (s *Server) handleWebSocketSession(ctx context.Context, sessionID string) error {
+	s.subscriptionManager.SetWebSocketSession(sessionID)
+
+	// Reconnecting would not help subscriptions that could not be changed; the background sync
+	// retries them
+	var syncErr *twitch.SyncFailedError
+	if err := s.subscriptionManager.SyncSubscriptions(ctx); errors.As(err, &syncErr) {
+		s.logger.Error("Subscription sync for the WebSocket session incomplete", "error", err)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
 // handleRoot handles requests to the root path
 func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
@@ -528,20 +711,51 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("itsjustintv - Twitch EventSub webhook bridge\n"))
 }
 
-// processStreamEvent processes a stream.online event and dispatches webhooks
+// streamSessionTTL bounds how long an online event is remembered for duration tracking
+// (Twitch ends broadcasts after 48 hours)
+const streamSessionTTL = 48 * time.Hour
+
+// streamSessionKey returns the cache key under which the current live session is stored
+func streamSessionKey(broadcasterUserID string) string {
+	return "stream_session:" + broadcasterUserID
+}
+
+// processStreamEvent processes a stream event and dispatches webhooks
 func (s *Server) processStreamEvent(processedEvent *twitch.ProcessedEvent, messageID string) error {
+	switch streamEvent := processedEvent.Event.(type) {
+	case twitch.StreamOnlineEvent:
+		return s.processStreamOnline(streamEvent, messageID)
+	case twitch.StreamOfflineEvent:
+		return s.processStreamOffline(streamEvent, messageID)
+	case twitch.ChannelChangeEvent:
+		if err := s.processChannelUpdate(streamEvent, messageID); err != nil {
+			// Let Twitch's redelivery report the change again
+			s.twitchProcessor.RevertChannelChange(streamEvent)
+			return err
+		}
+		return nil
+	case twitch.SubscriptionRevocation:
+		// Recreating the subscription and alerting can take longer than Twitch waits for the
+		// acknowledgement, so the revocation is handled after it was acknowledged
+		s.revocations.Add(1)
+		go func() {
+			defer s.revocations.Done()
+			s.processRevocation(streamEvent, messageID)
+		}()
+		return nil
+	default:
+		return fmt.Errorf("invalid stream event type")
+	}
+}
+
+// processStreamOnline processes a stream.online event and dispatches webhooks
+func (s *Server) processStreamOnline(streamEvent twitch.StreamOnlineEvent, messageID string) error {
 	ctx, span := s.telemetryManager.StartSpan(context.Background(), "process_stream_event",
 		attribute.String("message_id", messageID),
-		attribute.String("broadcaster_user_id", processedEvent.Event.(twitch.StreamOnlineEvent).BroadcasterUserID))
+		attribute.String("event_type", twitch.SubscriptionTypeStreamOnline),
+		attribute.String("broadcaster_user_id", streamEvent.BroadcasterUserID))
 	defer span.End()
 
-	// Extract stream event data
-	streamEvent, ok := processedEvent.Event.(twitch.StreamOnlineEvent)
-	if !ok {
-		span.RecordError(fmt.Errorf("invalid stream event type"))
-		return fmt.Errorf("invalid stream event type")
-	}
-
 	// Check for duplicates
 	eventKey := s.cacheManager.GenerateEventKey(streamEvent.BroadcasterUserID, streamEvent.ID, streamEvent.StartedAt)
 	if s.cacheManager.IsDuplicate(eventKey) {
@@ -559,19 +773,7 @@ func (s *Server) processStreamEvent(processedEvent *twitch.ProcessedEvent, messa
 	s.telemetryManager.RecordCacheOperation(ctx, "add", true)
 
 	// Find streamer configuration
-	var streamerKey string
-	var streamerConfig config.StreamerConfig
-	found := false
-
-	for key, cfg := range s.config.Streamers {
-		if cfg.UserID == streamEvent.BroadcasterUserID || cfg.Login == streamEvent.BroadcasterUserLogin {
-			streamerKey = key
-			streamerConfig = cfg
-			found = true
-			break
-		}
-	}
-
+	streamerKey, streamerConfig, found := s.findStreamer(streamEvent.BroadcasterUserID, streamEvent.BroadcasterUserLogin)
 	if !found {
 		return fmt.Errorf("streamer configuration not found")
 	}
@@ -587,6 +789,9 @@ func (s *Server) processStreamEvent(processedEvent *twitch.ProcessedEvent, messa
 	}
 
 	payload := s.webhookDispatcher.CreatePayload(streamerKey, streamerConfig, eventDataMap)
+	payload.EventType = twitch.SubscriptionTypeStreamOnline
+	startedAt := streamEvent.StartedAt.UTC()
+	payload.StartedAt = &startedAt
 
 	// Enrich payload with metadata and apply tag filtering
 	enrichCtx, enrichCancel := context.WithTimeout(context.Background(), 15*time.Second)
@@ -605,70 +810,321 @@ func (s *Server) processStreamEvent(processedEvent *twitch.ProcessedEvent, messa
 			"streamer_key", streamerKey)
 	}
 
-	// Determine webhook URL and secret
-	webhookURL := streamerConfig.TargetWebhookURL
-	webhookSecret := streamerConfig.TargetWebhookSecret
-	webhookHeader := streamerConfig.TargetWebhookHeader
-	webhookHashing := streamerConfig.TargetWebhookHashing
+	// Remember the live session so the matching stream.offline can report its duration
+	s.cacheManager.AddEventWithTTL(streamSessionKey(streamEvent.BroadcasterUserID), eventData, streamSessionTTL)
+
+	return s.dispatchPayload(ctx, streamerKey, streamerConfig, payload, messageID)
+}
 
-	// Use global webhook if streamer-specific URL is not provided and global is enabled
-	if webhookURL == "" && s.config.GlobalWebhook.Enabled && s.config.GlobalWebhook.URL != "" {
-		webhookURL = s.config.GlobalWebhook.URL
-		webhookSecret = s.config.GlobalWebhook.TargetWebhookSecret
-		webhookHeader = s.config.GlobalWebhook.TargetWebhookHeader
-		webhookHashing = s.config.GlobalWebhook.TargetWebhookHashing
-		s.logger.Debug("Using global webhook configuration",
+// processStreamOffline processes a stream.offline event and dispatches webhooks
+func (s *Server) processStreamOffline(streamEvent twitch.StreamOfflineEvent, messageID string) error {
+	ctx, span := s.telemetryManager.StartSpan(context.Background(), "process_stream_event",
+		attribute.String("message_id", messageID),
+		attribute.String("event_type", twitch.SubscriptionTypeStreamOffline),
+		attribute.String("broadcaster_user_id", streamEvent.BroadcasterUserID))
+	defer span.End()
+
+	// stream.offline carries no event ID, so deduplicate on the EventSub message ID
+	eventKey := s.cacheManager.GenerateEventKey(streamEvent.BroadcasterUserID, messageID, time.Time{})
+	if s.cacheManager.IsDuplicate(eventKey) {
+		s.logger.Info("Duplicate event detected, skipping",
+			"event_key", eventKey,
+			"broadcaster_login", streamEvent.BroadcasterUserLogin,
+			"message_id", messageID)
+		span.SetAttributes(attribute.Bool("duplicate", true))
+		return nil
+	}
+
+	eventData, _ := json.Marshal(streamEvent)
+	s.cacheManager.AddEvent(eventKey, eventData)
+	s.telemetryManager.RecordCacheOperation(ctx, "add", true)
+
+	streamerKey, streamerConfig, found := s.findStreamer(streamEvent.BroadcasterUserID, streamEvent.BroadcasterUserLogin)
+	if !found {
+		return fmt.Errorf("streamer configuration not found")
+	}
+
+	eventDataMap := map[string]interface{}{
+		"broadcaster_user_id":    streamEvent.BroadcasterUserID,
+		"broadcaster_user_login": streamEvent.BroadcasterUserLogin,
+		"broadcaster_user_name":  streamEvent.BroadcasterUserName,
+	}
+
+	payload := s.webhookDispatcher.CreatePayload(streamerKey, streamerConfig, eventDataMap)
+	payload.EventType = twitch.SubscriptionTypeStreamOffline
+	endedAt := payload.Timestamp
+	payload.EndedAt = &endedAt
+
+	// Compute the stream duration from the matching online event
+	sessionKey := streamSessionKey(streamEvent.BroadcasterUserID)
+	if sessionData, ok := s.cacheManager.GetEvent(sessionKey); ok {
+		var onlineEvent twitch.StreamOnlineEvent
+		if err := json.Unmarshal(sessionData, &onlineEvent); err == nil && !onlineEvent.StartedAt.IsZero() {
+			startedAt := onlineEvent.StartedAt.UTC()
+			payload.StartedAt = &startedAt
+			payload.DurationSeconds = int64(endedAt.Sub(startedAt).Seconds())
+		}
+		s.cacheManager.RemoveEvent(sessionKey)
+	} else if len(streamerConfig.TagFilter) > 0 {
+		// Without a recorded session the online event was blocked by the tag filter
+		// (or happened before we started), so there is nothing to close downstream
+		s.logger.Info("No matching online event for filtered streamer, skipping offline dispatch",
 			"streamer_key", streamerKey,
-			"webhook_url", webhookURL)
+			"streamer_login", streamEvent.BroadcasterUserLogin)
+		return nil
+	}
+
+	return s.dispatchPayload(ctx, streamerKey, streamerConfig, payload, messageID)
+}
+
+// processChannelUpdate processes a channel.update event that changed the title or category
+func (s *Server) processChannelUpdate(changeEvent twitch.ChannelChangeEvent, messageID string) error {
+	ctx, span := s.telemetryManager.StartSpan(context.Background(), "process_stream_event",
+		attribute.String("message_id", messageID),
+		attribute.String("event_type", twitch.SubscriptionTypeChannelUpdate),
+		attribute.String("broadcaster_user_id", changeEvent.BroadcasterUserID))
+	defer span.End()
+
+	eventKey := s.cacheManager.GenerateEventKey(changeEvent.BroadcasterUserID, messageID, time.Time{})
+	if s.cacheManager.IsDuplicate(eventKey) {
+		s.logger.Info("Duplicate event detected, skipping",
+			"event_key", eventKey,
+			"broadcaster_login", changeEvent.BroadcasterUserLogin,
+			"message_id", messageID)
+		span.SetAttributes(attribute.Bool("duplicate", true))
+		return nil
+	}
+
+	eventData, _ := json.Marshal(changeEvent)
+	s.cacheManager.AddEvent(eventKey, eventData)
+	s.telemetryManager.RecordCacheOperation(ctx, "add", true)
+
+	streamerKey, streamerConfig, found := s.findStreamer(changeEvent.BroadcasterUserID, changeEvent.BroadcasterUserLogin)
+	if !found {
+		return fmt.Errorf("streamer configuration not found")
+	}
+
+	eventDataMap := map[string]interface{}{
+		"broadcaster_user_id":    changeEvent.BroadcasterUserID,
+		"broadcaster_user_login": changeEvent.BroadcasterUserLogin,
+		"broadcaster_user_name":  changeEvent.BroadcasterUserName,
+	}
+
+	payload := s.webhookDispatcher.CreatePayload(streamerKey, streamerConfig, eventDataMap)
+	payload.EventType = twitch.SubscriptionTypeChannelUpdate
+	payload.Language = changeEvent.Language
+	payload.Title = changeEvent.Title
+	payload.CategoryID = changeEvent.CategoryID
+	payload.CategoryName = changeEvent.CategoryName
+	payload.Changes = changeEvent.Changes
+	payload.PreviousTitle = changeEvent.PreviousTitle
+	payload.PreviousCategoryName = changeEvent.PreviousCategoryName
+
+	if err := s.dispatchPayload(ctx, streamerKey, streamerConfig, payload, messageID); err != nil {
+		s.cacheManager.RemoveEvent(eventKey)
+		return err
+	}
+	return nil
+}
+
+// Revocation reactions reported in operator alerts
+const (
+	revocationReactionRecreated      = "recreated"
+	revocationReactionRecreateFailed = "recreate_failed"
+	revocationReactionNotRequired    = "not_required"
+	revocationReactionDisabled       = "streamer_disabled"
+	revocationReactionNone           = "none"
+)
+
+// processRevocation reacts to a revoked subscription based on its status and alerts the operator
+func (s *Server) processRevocation(revocation twitch.SubscriptionRevocation, messageID string) {
+	ctx, span := s.telemetryManager.StartSpan(context.Background(), "process_revocation",
+		attribute.String("message_id", messageID),
+		attribute.String("subscription_id", revocation.Subscription.ID),
+		attribute.String("status", revocation.Subscription.Status))
+	defer span.End()
+
+	sub := revocation.Subscription
+	broadcasterUserID, _ := sub.Condition["broadcaster_user_id"].(string)
+
+	eventKey := s.cacheManager.GenerateEventKey(sub.ID, messageID, time.Time{})
+	if s.cacheManager.IsDuplicate(eventKey) {
+		s.logger.Info("Duplicate revocation detected, skipping",
+			"subscription_id", sub.ID,
+			"message_id", messageID)
+		return
+	}
+	eventData, _ := json.Marshal(revocation)
+	s.cacheManager.AddEvent(eventKey, eventData)
+
+	streamerKey, _, found := s.findConfiguredStreamer(broadcasterUserID, "")
+
+	alert := webhook.AlertPayload{
+		Alert:             "subscription_revoked",
+		Severity:          "warning",
+		StreamerKey:       streamerKey,
+		BroadcasterUserID: broadcasterUserID,
+		SubscriptionID:    sub.ID,
+		SubscriptionType:  sub.Type,
+		Status:            sub.Status,
+		Timestamp:         time.Now().UTC(),
+	}
+
+	switch {
+	case !found:
+		alert.Reaction = revocationReactionNone
+		alert.Message = fmt.Sprintf("Twitch revoked %s subscription %s (%s) for an unconfigured broadcaster", sub.Type, sub.ID, sub.Status)
+
+	case sub.Status == twitch.SubscriptionStatusNotificationFailuresExceeded:
+		recreated, err := s.subscriptionManager.RecreateSubscription(ctx, sub)
+		switch {
+		case err != nil:
+			alert.Reaction = revocationReactionRecreateFailed
+			alert.Severity = "critical"
+			alert.Error = err.Error()
+			alert.Message = fmt.Sprintf("Twitch revoked %s subscription for %s after repeated delivery failures and recreating it failed", sub.Type, streamerKey)
+			s.logger.Error("Failed to recreate revoked subscription",
+				"error", err,
+				"streamer_key", streamerKey,
+				"subscription_id", sub.ID)
+		case recreated:
+			alert.Reaction = revocationReactionRecreated
+			alert.Message = fmt.Sprintf("Twitch revoked %s subscription for %s after repeated delivery failures; it was recreated", sub.Type, streamerKey)
+		default:
+			alert.Reaction = revocationReactionNotRequired
+			alert.Message = fmt.Sprintf("Twitch revoked %s subscription for %s after repeated delivery failures; it is no longer configured", sub.Type, streamerKey)
+		}
+
+	case sub.Status == twitch.SubscriptionStatusUserRemoved || sub.Status == twitch.SubscriptionStatusAuthorizationRevoked:
+		s.subscriptionManager.DisableStreamer(streamerKey, sub.Status)
+		alert.Reaction = revocationReactionDisabled
+		alert.Severity = "critical"
+		alert.Message = fmt.Sprintf("Twitch revoked %s subscription for %s (%s); the streamer was disabled, set disabled = true in the configuration to make this permanent", sub.Type, streamerKey, sub.Status)
+
+	default:
+		alert.Reaction = revocationReactionNone
+		alert.Message = fmt.Sprintf("Twitch revoked %s subscription for %s (%s)", sub.Type, streamerKey, sub.Status)
 	}
 
-	// Validate webhook URL
-	if webhookURL == "" {
+	span.SetAttributes(attribute.String("reaction", alert.Reaction))
+	s.logger.Warn("Handled subscription revocation",
+		"streamer_key", streamerKey,
+		"subscription_id", sub.ID,
+		"subscription_type", sub.Type,
+		"status", sub.Status,
+		"reaction", alert.Reaction)
+
+	alertCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if result := s.webhookDispatcher.DispatchAlert(alertCtx, alert); result != nil && !result.Success {
+		s.logger.Warn("Failed to send operator alert",
+			"alert", alert.Alert,
+			"error", result.Error,
+			"status_code", result.StatusCode)
+	}
+}
+
+// findStreamer finds the configuration key and settings for a broadcaster whose streamer is not
+// disabled, in the configuration or at runtime
+func (s *Server) findStreamer(userID, login string) (string, config.StreamerConfig, bool) {
+	key, cfg, found := s.findConfiguredStreamer(userID, login)
+	if !found || cfg.Disabled || s.subscriptionManager.IsStreamerDisabled(key) {
+		return "", config.StreamerConfig{}, false
+	}
+	return key, cfg, true
+}
+
+// findConfiguredStreamer finds the configuration key and settings for a broadcaster
+func (s *Server) findConfiguredStreamer(userID, login string) (string, config.StreamerConfig, bool) {
+	for key, cfg := range s.config.Streamers {
+		if (userID != "" && cfg.UserID == userID) || (login != "" && cfg.Login == login) {
+			return key, cfg, true
+		}
+	}
+	return "", config.StreamerConfig{}, false
+}
+
+// dispatchPayload delivers a payload to all of the streamer's webhook targets in parallel,
+// queueing failed deliveries for retry per target
+func (s *Server) dispatchPayload(ctx context.Context, streamerKey string, streamerConfig config.StreamerConfig, payload *webhook.WebhookPayload, messageID string) error {
+	targets := s.webhookTargets(streamerKey, streamerConfig)
+	if len(targets) == 0 {
 		s.logger.Error("No webhook URL configured for streamer",
 			"streamer_key", streamerKey,
 			"has_global_webhook", s.config.GlobalWebhook.Enabled)
 		return fmt.Errorf("no webhook URL configured for streamer: %s", streamerKey)
 	}
 
-	// Create dispatch request
-	dispatchReq := &webhook.DispatchRequest{
-		WebhookURL:     webhookURL,
-		Payload:        *payload,
-		WebhookSecret:  webhookSecret,
-		WebhookHeader:  webhookHeader,
-		WebhookHashing: webhookHashing,
-		StreamerKey:    streamerKey,
-		Attempt:        1,
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target config.WebhookTargetConfig) {
+			defer wg.Done()
+			s.dispatchToTarget(ctx, streamerKey, target, payload, messageID)
+		}(target)
 	}
+	wg.Wait()
 
-	// Attempt initial dispatch
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	return nil
+}
+
+// webhookTargets returns the targets a streamer's events are delivered to. The global webhook
+// is added when the streamer has no targets, or for every event in "always" mode, unless the
+// streamer opted out of it.
+func (s *Server) webhookTargets(streamerKey string, streamerConfig config.StreamerConfig) []config.WebhookTargetConfig {
+	targets := streamerConfig.GetTargets()
+
+	global := s.config.GlobalWebhook
+	if !global.Enabled || global.URL == "" || streamerConfig.SkipGlobalWebhook {
+		return targets
+	}
+	if len(targets) > 0 && global.Mode != config.GlobalWebhookModeAlways {
+		return targets
+	}
+
+	s.logger.Debug("Using global webhook configuration",
+		"streamer_key", streamerKey,
+		"webhook_url", global.URL,
+		"mode", global.Mode)
+	return append(targets, global.Target())
+}
+
+// dispatchToTarget delivers a payload to a single target, queueing it for retry on failure. The
+// EventSub message ID identifies the event at targets that support it.
+func (s *Server) dispatchToTarget(ctx context.Context, streamerKey string, target config.WebhookTargetConfig, payload *webhook.WebhookPayload, messageID string) {
+	dispatchReq := webhook.NewDispatchRequest(streamerKey, target, *payload, messageID)
 
 	result := s.webhookDispatcher.Dispatch(ctx, dispatchReq)
 
-	// Write to output file
 	errorMsg := ""
 	if !result.Success {
 		errorMsg = result.Error
-		// Add to retry queue
-		s.retryManager.AddRequest(dispatchReq)
-		s.logger.Warn("Initial webhook dispatch failed, added to retry queue",
-			"webhook_url", dispatchReq.WebhookURL,
-			"streamer_key", streamerKey,
-			"error", result.Error,
-			"status_code", result.StatusCode)
+		// Add to retry queue unless the failure is permanent
+		if s.retryManager.AddFailedRequest(dispatchReq, result) {
+			s.logger.Warn("Initial webhook dispatch failed, added to retry queue",
+				"webhook_url", dispatchReq.WebhookURL,
+				"streamer_key", streamerKey,
+				"target_name", target.Name,
+				"event_type", payload.EventType,
+				"error", result.Error,
+				"status_code", result.StatusCode,
+				"failure_class", result.FailureClass)
+		}
 	} else {
 		s.logger.Info("Webhook dispatched successfully",
 			"webhook_url", dispatchReq.WebhookURL,
 			"streamer_key", streamerKey,
+			"target_name", target.Name,
+			"event_type", payload.EventType,
 			"response_time", result.ResponseTime)
 	}
 
 	// Write payload to output file
-	if err := s.outputWriter.WritePayload(*payload, result.Success, errorMsg); err != nil {
+	if err := s.outputWriter.WritePayload(*payload, target.Name, result.Success, errorMsg); err != nil {
 		s.logger.Warn("Failed to write payload to output file", "error", err)
 	}
-
-	return nil
 }
@@ -2,15 +2,20 @@ package server
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/rmoriz/itsjustintv/internal/twitch"
+	"github.com/rmoriz/itsjustintv/internal/webhook"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -66,6 +71,14 @@ func TestHandleHealth(t *testing.T) {
 
 			if tt.expectedStatus == http.StatusOK {
 				assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+				var health struct {
+					Status          string                  `json:"status"`
+					CircuitBreakers []webhook.CircuitStatus `json:"circuit_breakers"`
+				}
+				require.NoError(t, json.Unmarshal(body, &health))
+				assert.Equal(t, "healthy", health.Status)
+				assert.NotNil(t, health.CircuitBreakers)
 			}
 		})
 	}
@@ -81,7 +94,9 @@ func TestHandleTwitchWebhook(t *testing.T) {
 	validPayload := `{"challenge":"test_challenge","subscription":{"id":"test","type":"stream.online"}}`
 
 	// Generate valid signature
-	signature := server.webhookValidator.GenerateSignature([]byte(validPayload), "SHA-256")
+	messageID := "msg_verification"
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+	signature := twitch.SignEventSubMessage("test_secret", messageID, timestamp, []byte(validPayload))
 
 	tests := []struct {
 		name           string
@@ -131,6 +146,8 @@ func TestHandleTwitchWebhook(t *testing.T) {
 			if tt.signature != "" {
 				req.Header.Set("Twitch-Eventsub-Message-Signature", tt.signature)
 			}
+			req.Header.Set("Twitch-Eventsub-Message-Id", messageID)
+			req.Header.Set("Twitch-Eventsub-Message-Timestamp", timestamp)
 
 			for key, value := range tt.headers {
 				req.Header.Set(key, value)
@@ -149,6 +166,333 @@ func TestHandleTwitchWebhook(t *testing.T) {
 	}
 }
 
+func TestHandleTwitchWebhookReplayProtection(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Twitch.WebhookSecret = "test_secret"
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	server := New(cfg, logger)
+
+	// A notification for an unconfigured streamer is answered with 410 Gone when processed
+	payload := `{"subscription":{"id":"sub_1","type":"stream.online"},"event":{"id":"stream_1","broadcaster_user_id":"999","broadcaster_user_login":"unknown"}}`
+
+	send := func(messageID string, sentAt time.Time) *http.Response {
+		timestamp := sentAt.UTC().Format(time.RFC3339Nano)
+		req := httptest.NewRequest(http.MethodPost, "/twitch", strings.NewReader(payload))
+		req.Header.Set("Twitch-Eventsub-Message-Signature", twitch.SignEventSubMessage("test_secret", messageID, timestamp, []byte(payload)))
+		req.Header.Set("Twitch-Eventsub-Message-Type", twitch.MessageTypeNotification)
+		req.Header.Set("Twitch-Eventsub-Subscription-Type", twitch.SubscriptionTypeStreamOnline)
+		req.Header.Set("Twitch-Eventsub-Message-Id", messageID)
+		req.Header.Set("Twitch-Eventsub-Message-Timestamp", timestamp)
+
+		w := httptest.NewRecorder()
+		server.handleTwitchWebhook(w, req)
+		return w.Result()
+	}
+
+	t.Run("stale message is rejected", func(t *testing.T) {
+		resp := send("msg_stale", time.Now().Add(-11*time.Minute))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("duplicate message is acknowledged without processing", func(t *testing.T) {
+		resp := send("msg_1", time.Now())
+		assert.Equal(t, http.StatusGone, resp.StatusCode)
+
+		resp = send("msg_1", time.Now())
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, string(body), "duplicate")
+	})
+
+	t.Run("missing message ID is rejected", func(t *testing.T) {
+		resp := send("", time.Now())
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestCheckMessageTimestamp(t *testing.T) {
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		timestamp   string
+		expectError bool
+	}{
+		{"recent", now.Add(-time.Minute).Format(time.RFC3339Nano), false},
+		{"nanosecond precision", "2025-01-15T11:59:30.123456789Z", false},
+		{"just within window", now.Add(-maxMessageAge + time.Second).Format(time.RFC3339), false},
+		{"too old", now.Add(-maxMessageAge - time.Second).Format(time.RFC3339), true},
+		{"too far in the future", now.Add(maxMessageAge + time.Second).Format(time.RFC3339), true},
+		{"missing", "", true},
+		{"malformed", "yesterday", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMessageTimestamp(tt.timestamp, now)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestProcessStreamOffline(t *testing.T) {
+	var received []webhook.WebhookPayload
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhook.WebhookPayload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		received = append(received, payload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Output.Enabled = false
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"test_streamer": {
+			UserID:           "123456789",
+			Login:            "teststreamer",
+			TargetWebhookURL: target.URL,
+		},
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	server := New(cfg, logger)
+
+	// Simulate the session recorded by the matching stream.online event
+	startedAt := time.Now().UTC().Add(-90 * time.Minute)
+	onlineData, err := json.Marshal(twitch.StreamOnlineEvent{
+		ID:                "stream_123",
+		BroadcasterUserID: "123456789",
+		StartedAt:         startedAt,
+	})
+	require.NoError(t, err)
+	server.cacheManager.AddEventWithTTL(streamSessionKey("123456789"), onlineData, streamSessionTTL)
+
+	offlineEvent := &twitch.ProcessedEvent{
+		Type: twitch.SubscriptionTypeStreamOffline,
+		Event: twitch.StreamOfflineEvent{
+			BroadcasterUserID:    "123456789",
+			BroadcasterUserLogin: "teststreamer",
+			BroadcasterUserName:  "Test Streamer",
+		},
+		Action: "process",
+	}
+
+	require.NoError(t, server.processStreamEvent(offlineEvent, "msg_1"))
+	require.Len(t, received, 1)
+
+	payload := received[0]
+	assert.Equal(t, twitch.SubscriptionTypeStreamOffline, payload.EventType)
+	require.NotNil(t, payload.StartedAt)
+	require.NotNil(t, payload.EndedAt)
+	assert.True(t, payload.StartedAt.Equal(startedAt))
+	assert.InDelta(t, 90*60, payload.DurationSeconds, 5)
+
+	// The session is closed and the same message is not dispatched twice
+	_, ok := server.cacheManager.GetEvent(streamSessionKey("123456789"))
+	assert.False(t, ok)
+	require.NoError(t, server.processStreamEvent(offlineEvent, "msg_1"))
+	assert.Len(t, received, 1)
+}
+
+func TestProcessChannelUpdateFailureIsReportedOnRedelivery(t *testing.T) {
+	var received []webhook.WebhookPayload
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhook.WebhookPayload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		received = append(received, payload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Output.Enabled = false
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"test_streamer": {
+			UserID: "123456789",
+			Login:  "teststreamer",
+			Events: []string{twitch.SubscriptionTypeChannelUpdate},
+		},
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	server := New(cfg, logger)
+	server.twitchProcessor.RecordChannelInfo(twitch.ChannelInfo{BroadcasterID: "123456789", Title: "Morning coffee"})
+
+	payload, err := json.Marshal(twitch.EventSubNotification{
+		Subscription: twitch.EventSubSubscription{Type: twitch.SubscriptionTypeChannelUpdate, Version: "2"},
+		Event: twitch.ChannelUpdateEvent{
+			BroadcasterUserID:    "123456789",
+			BroadcasterUserLogin: "teststreamer",
+			Title:                "Ranked grind",
+		},
+	})
+	require.NoError(t, err)
+	headers := twitch.EventSubHeaders{MessageType: twitch.MessageTypeNotification, SubscriptionType: twitch.SubscriptionTypeChannelUpdate}
+
+	// Without a webhook URL the change cannot be delivered
+	processed, err := server.twitchProcessor.ProcessNotification(headers, payload)
+	require.NoError(t, err)
+	require.Equal(t, "process", processed.Action)
+	require.Error(t, server.processStreamEvent(processed, "msg_1"))
+
+	// Twitch's redelivery reports the change against the original baseline
+	streamer := cfg.Streamers["test_streamer"]
+	streamer.TargetWebhookURL = target.URL
+	cfg.Streamers["test_streamer"] = streamer
+
+	processed, err = server.twitchProcessor.ProcessNotification(headers, payload)
+	require.NoError(t, err)
+	require.Equal(t, "process", processed.Action)
+	require.NoError(t, server.processStreamEvent(processed, "msg_1"))
+	require.Len(t, received, 1)
+	assert.Equal(t, "Morning coffee", received[0].PreviousTitle)
+	assert.Equal(t, "Ranked grind", received[0].Title)
+}
+
+func TestProcessRevocationDisablesStreamer(t *testing.T) {
+	var alerts []webhook.AlertPayload
+	alertTarget := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert webhook.AlertPayload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&alert))
+		alerts = append(alerts, alert)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer alertTarget.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Output.Enabled = false
+	cfg.AlertWebhook.Enabled = true
+	cfg.AlertWebhook.URL = alertTarget.URL
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"test_streamer": {
+			UserID: "123456789",
+			Login:  "teststreamer",
+		},
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	server := New(cfg, logger)
+
+	revocation := &twitch.ProcessedEvent{
+		Type: "revocation",
+		Event: twitch.SubscriptionRevocation{
+			Subscription: twitch.EventSubSubscription{
+				ID:        "sub_123",
+				Type:      twitch.SubscriptionTypeStreamOnline,
+				Version:   "1",
+				Status:    twitch.SubscriptionStatusUserRemoved,
+				Condition: map[string]interface{}{"broadcaster_user_id": "123456789"},
+			},
+		},
+		Action: "process",
+	}
+
+	require.NoError(t, server.processStreamEvent(revocation, "msg_1"))
+	server.revocations.Wait()
+	assert.True(t, server.subscriptionManager.IsStreamerDisabled("test_streamer"))
+
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "subscription_revoked", alerts[0].Alert)
+	assert.Equal(t, "critical", alerts[0].Severity)
+	assert.Equal(t, revocationReactionDisabled, alerts[0].Reaction)
+	assert.Equal(t, "test_streamer", alerts[0].StreamerKey)
+	assert.Equal(t, "sub_123", alerts[0].SubscriptionID)
+
+	// Twitch retries are not handled twice
+	require.NoError(t, server.processStreamEvent(revocation, "msg_1"))
+	server.revocations.Wait()
+	assert.Len(t, alerts, 1)
+
+	// Events of the disabled streamer are no longer processed
+	_, _, found := server.findStreamer("123456789", "teststreamer")
+	assert.False(t, found)
+	notification := []byte(`{"subscription":{"type":"stream.online"},"event":{"id":"1","broadcaster_user_id":"123456789","broadcaster_user_login":"teststreamer","type":"live","started_at":"2024-01-01T00:00:00Z"}}`)
+	processed, err := server.twitchProcessor.ProcessNotification(twitch.EventSubHeaders{MessageType: twitch.MessageTypeNotification, SubscriptionType: twitch.SubscriptionTypeStreamOnline}, notification)
+	require.NoError(t, err)
+	assert.Equal(t, "revoke", processed.Action)
+}
+
+func TestDispatchPayloadFansOutToTargets(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]http.Header)
+	newTarget := func(name string, status int) *httptest.Server {
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			received[name] = r.Header.Clone()
+			mu.Unlock()
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(target.Close)
+		return target
+	}
+
+	legacy := newTarget("default", http.StatusOK)
+	discord := newTarget("discord", http.StatusNoContent)
+	queue := newTarget("queue", http.StatusServiceUnavailable)
+	disabled := newTarget("disabled", http.StatusOK)
+	off := false
+
+	cfg := config.DefaultConfig()
+	cfg.Output.Enabled = false
+	streamerConfig := config.StreamerConfig{
+		UserID:           "123456789",
+		Login:            "teststreamer",
+		TargetWebhookURL: legacy.URL,
+		Targets: []config.WebhookTargetConfig{
+			{Name: "discord", URL: discord.URL, Secret: "discord_secret", Header: "X-Discord-Signature"},
+			{Name: "queue", URL: queue.URL},
+			{Name: "disabled", URL: disabled.URL, Enabled: &off},
+		},
+	}
+	cfg.Streamers = map[string]config.StreamerConfig{"test_streamer": streamerConfig}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	server := New(cfg, logger)
+
+	payload := &webhook.WebhookPayload{StreamerLogin: "teststreamer", EventType: twitch.SubscriptionTypeStreamOnline}
+	require.NoError(t, server.dispatchPayload(t.Context(), "test_streamer", streamerConfig, payload, "msg-1"))
+
+	assert.Len(t, received, 3)
+	assert.Contains(t, received, "default")
+	assert.NotContains(t, received, "disabled")
+	assert.NotEmpty(t, received["discord"].Get("X-Discord-Signature"))
+	assert.Empty(t, received["queue"].Get("X-Hub-Signature-256"))
+
+	// Only the failed target is queued for retry
+	assert.Equal(t, map[string]int{"test_streamer/queue": 1}, server.retryManager.GetQueueSizeByTarget())
+}
+
+func TestWebhookTargetsGlobalWebhookMode(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.GlobalWebhook.Enabled = true
+	cfg.GlobalWebhook.URL = "https://ops.example.com/twitch"
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	server := New(cfg, logger)
+
+	withTarget := config.StreamerConfig{TargetWebhookURL: "https://example.com/webhook"}
+	targetNames := func(streamerConfig config.StreamerConfig) []string {
+		var names []string
+		for _, target := range server.webhookTargets("test_streamer", streamerConfig) {
+			names = append(names, target.Name)
+		}
+		return names
+	}
+
+	// Fallback mode only delivers to the global webhook when the streamer has no targets
+	assert.Equal(t, []string{config.DefaultTargetName}, targetNames(withTarget))
+	assert.Equal(t, []string{config.GlobalTargetName}, targetNames(config.StreamerConfig{}))
+
+	// Always mode delivers to both
+	cfg.GlobalWebhook.Mode = config.GlobalWebhookModeAlways
+	assert.Equal(t, []string{config.DefaultTargetName, config.GlobalTargetName}, targetNames(withTarget))
+
+	// Streamers can opt out of the global webhook
+	withTarget.SkipGlobalWebhook = true
+	assert.Equal(t, []string{config.DefaultTargetName}, targetNames(withTarget))
+	assert.Empty(t, targetNames(config.StreamerConfig{SkipGlobalWebhook: true}))
+}
+
 func TestHandleRoot(t *testing.T) {
 	cfg := config.DefaultConfig()
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
@@ -247,3 +591,19 @@ func TestServerIntegration(t *testing.T) {
 	// Skip this test as it requires real Twitch API credentials
 	t.Skip("Skipping integration test that requires Twitch API credentials")
 }
+
+func TestHandleConfigReloadRejectsBrokenTemplates(t *testing.T) {
+	cfg := config.DefaultConfig()
+	server := New(cfg, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+	newConfig := config.DefaultConfig()
+	newConfig.Streamers["test_streamer"] = config.StreamerConfig{
+		Login:   "teststreamer",
+		Targets: []config.WebhookTargetConfig{{Name: "cms", URL: "https://cms.example.com", Template: `{{ .Payload.Image.URL }}`}},
+	}
+
+	err := server.handleConfigReload(newConfig)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "streamers.test_streamer.targets.cms.template is invalid")
+	assert.Same(t, cfg, server.config)
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/rmoriz/itsjustintv/internal/webhook"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
@@ -39,6 +40,17 @@ type Manager struct {
 	twitchAPIDuration  metric.Float64Histogram
 	configReloads      metric.Int64Counter
 	configReloadErrors metric.Int64Counter
+	circuitState       metric.Int64ObservableGauge
+
+	// circuitBreakers reports the circuit breaker states observed by circuitState
+	circuitBreakers func() []webhook.CircuitStatus
+}
+
+// Values of the webhook_circuit_breaker_state gauge
+var circuitStateValues = map[webhook.CircuitState]int64{
+	webhook.CircuitClosed:   0,
+	webhook.CircuitHalfOpen: 1,
+	webhook.CircuitOpen:     2,
 }
 
 // NewManager creates a new telemetry manager
@@ -199,6 +211,27 @@ func (m *Manager) initMetrics() error {
 		return err
 	}
 
+	// Circuit breaker metrics
+	m.circuitState, err = m.meter.Int64ObservableGauge("webhook_circuit_breaker_state",
+		metric.WithDescription("Circuit breaker state per webhook target host (0 closed, 1 half-open, 2 open)"),
+		metric.WithUnit("{state}"))
+	if err != nil {
+		return err
+	}
+
+	if m.circuitBreakers != nil {
+		_, err = m.meter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+			for _, status := range m.circuitBreakers() {
+				observer.ObserveInt64(m.circuitState, circuitStateValues[status.State],
+					metric.WithAttributes(attribute.String("host", status.Host)))
+			}
+			return nil
+		}, m.circuitState)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -226,6 +259,12 @@ func (m *Manager) Stop(ctx context.Context) error {
 	return nil
 }
 
+// ObserveCircuitBreakers reports the circuit breaker states returned by statuses as metrics; it must
+// be called before Start
+func (m *Manager) ObserveCircuitBreakers(statuses func() []webhook.CircuitStatus) {
+	m.circuitBreakers = statuses
+}
+
 // StartSpan starts a new span
 func (m *Manager) StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
 	if !m.config.Telemetry.Enabled {
@@ -18,11 +18,12 @@ import (
 
 // Enricher handles metadata enrichment for stream events
 type Enricher struct {
-	config     *config.Config
-	logger     *slog.Logger
-	client     *Client
-	httpClient *http.Client
-	cacheDir   string
+	config        *config.Config
+	logger        *slog.Logger
+	client        *Client
+	httpClient    *http.Client
+	cacheDir      string
+	onChannelInfo func(ChannelInfo)
 }
 
 // NewEnricher creates a new metadata enricher
@@ -38,6 +39,11 @@ func NewEnricher(cfg *config.Config, logger *slog.Logger, client *Client) *Enric
 	}
 }
 
+// SetChannelInfoHandler registers a callback invoked with every channel info fetched during enrichment
+func (e *Enricher) SetChannelInfoHandler(handler func(ChannelInfo)) {
+	e.onChannelInfo = handler
+}
+
 // Start initializes the enricher
 func (e *Enricher) Start() error {
 	// Ensure cache directory exists
@@ -82,6 +88,10 @@ func (e *Enricher) EnrichPayload(ctx context.Context, payload *webhook.WebhookPa
 		e.logger.Warn("Failed to get channel info", "error", err, "streamer_id", payload.StreamerID)
 		// Continue with basic data, tag filtering will be skipped
 	} else {
+		if e.onChannelInfo != nil {
+			e.onChannelInfo(*channelInfo)
+		}
+
 		// Apply tag filtering according to PRD requirements
 		if len(streamerConfig.TagFilter) > 0 {
 			if !e.checkTagFilter(channelInfo.Tags, streamerConfig.TagFilter) {
@@ -101,6 +111,15 @@ func (e *Enricher) EnrichPayload(ctx context.Context, payload *webhook.WebhookPa
 
 		// Set language from channel info
 		payload.Language = e.detectLanguage(channelInfo.Tags, channelInfo.BroadcasterLanguage)
+
+		// Stream title and category, unless the event already provided them
+		if payload.Title == "" {
+			payload.Title = channelInfo.Title
+		}
+		if payload.CategoryName == "" {
+			payload.CategoryID = channelInfo.GameID
+			payload.CategoryName = channelInfo.GameName
+		}
 	}
 
 	// Get followers count
@@ -1,28 +1,108 @@
 package twitch
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/rmoriz/itsjustintv/internal/config"
 )
 
 // Processor handles Twitch EventSub webhook processing
 type Processor struct {
-	config *config.Config
-	logger *slog.Logger
+	config       *config.Config
+	logger       *slog.Logger
+	channelInfo  map[string]ChannelInfo // last-known channel info by broadcaster ID
+	channelMutex sync.Mutex
+
+	// isDisabled reports streamers disabled at runtime, e.g. after a revocation
+	isDisabled func(streamerKey string) bool
 }
 
 // NewProcessor creates a new Twitch webhook processor
 func NewProcessor(cfg *config.Config, logger *slog.Logger) *Processor {
 	return &Processor{
-		config: cfg,
-		logger: logger,
+		config:      cfg,
+		logger:      logger,
+		channelInfo: make(map[string]ChannelInfo),
+	}
+}
+
+// RecordChannelInfo stores the last-known channel info used to detect channel.update changes
+func (p *Processor) RecordChannelInfo(info ChannelInfo) {
+	p.channelMutex.Lock()
+	defer p.channelMutex.Unlock()
+
+	p.channelInfo[info.BroadcasterID] = info
+}
+
+// RevertChannelChange restores the baseline a change was detected against when the change could not
+// be delivered, so that Twitch's redelivery of the update reports it again. A newer update that was
+// recorded meanwhile is kept.
+func (p *Processor) RevertChannelChange(change ChannelChangeEvent) {
+	p.channelMutex.Lock()
+	defer p.channelMutex.Unlock()
+
+	info, ok := p.channelInfo[change.BroadcasterUserID]
+	if !ok || info.Title != change.Title || info.GameID != change.CategoryID {
+		return
+	}
+	info.Title = change.PreviousTitle
+	info.GameID = change.PreviousCategoryID
+	info.GameName = change.PreviousCategoryName
+	p.channelInfo[change.BroadcasterUserID] = info
+}
+
+// ChannelInfoFetcher fetches the current channel info of a broadcaster, e.g. Client.GetChannelInfo
+type ChannelInfoFetcher func(ctx context.Context, broadcasterID string) (*ChannelInfo, error)
+
+// SeedChannelInfo fetches the channel info of the configured streamers subscribed to channel.update
+// that have no baseline yet, so that their first update can be diffed instead of being dropped
+func (p *Processor) SeedChannelInfo(ctx context.Context, fetch ChannelInfoFetcher) {
+	for key, streamerConfig := range p.config.Streamers {
+		if streamerConfig.Disabled || streamerConfig.UserID == "" || !slices.Contains(streamerConfig.GetEvents(), SubscriptionTypeChannelUpdate) {
+			continue
+		}
+
+		p.channelMutex.Lock()
+		_, known := p.channelInfo[streamerConfig.UserID]
+		p.channelMutex.Unlock()
+		if known {
+			continue
+		}
+
+		info, err := fetch(ctx, streamerConfig.UserID)
+		if err != nil {
+			p.logger.Warn("Failed to fetch channel info baseline, the first channel.update is not reported",
+				"streamer_key", key,
+				"error", err)
+			continue
+		}
+
+		// An update that arrived meanwhile is newer than the fetched info
+		p.channelMutex.Lock()
+		if _, known := p.channelInfo[streamerConfig.UserID]; !known {
+			p.channelInfo[streamerConfig.UserID] = *info
+		}
+		p.channelMutex.Unlock()
 	}
 }
 
+// UpdateConfig updates the processor with new configuration
+func (p *Processor) UpdateConfig(newConfig *config.Config) {
+	p.config = newConfig
+}
+
+// SetDisabledCheck registers a callback reporting streamers disabled at runtime, whose events are
+// handled like those of disabled streamers
+func (p *Processor) SetDisabledCheck(isDisabled func(streamerKey string) bool) {
+	p.isDisabled = isDisabled
+}
+
 // ProcessNotification processes a Twitch EventSub notification
 func (p *Processor) ProcessNotification(headers EventSubHeaders, payload []byte) (*ProcessedEvent, error) {
 	var notification EventSubNotification
@@ -71,8 +151,12 @@ func (p *Processor) handleVerification(notification EventSubNotification) (*Proc
 // handleNotification handles actual event notifications
 func (p *Processor) handleNotification(headers EventSubHeaders, notification EventSubNotification) (*ProcessedEvent, error) {
 	switch notification.Subscription.Type {
-	case "stream.online":
+	case SubscriptionTypeStreamOnline:
 		return p.handleStreamOnline(notification)
+	case SubscriptionTypeStreamOffline:
+		return p.handleStreamOffline(notification)
+	case SubscriptionTypeChannelUpdate:
+		return p.handleChannelUpdate(notification)
 	default:
 		p.logger.Warn("Unsupported subscription type", "type", notification.Subscription.Type)
 		return &ProcessedEvent{
@@ -129,13 +213,134 @@ func (p *Processor) handleStreamOnline(notification EventSubNotification) (*Proc
 	}
 
 	return &ProcessedEvent{
-		Type:   "stream.online",
+		Type:   SubscriptionTypeStreamOnline,
+		Event:  streamEvent,
+		Action: "process",
+	}, nil
+}
+
+// handleStreamOffline handles stream.offline events
+func (p *Processor) handleStreamOffline(notification EventSubNotification) (*ProcessedEvent, error) {
+	eventData, err := json.Marshal(notification.Event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event data: %w", err)
+	}
+
+	var streamEvent StreamOfflineEvent
+	if err := json.Unmarshal(eventData, &streamEvent); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal stream event: %w", err)
+	}
+
+	p.logger.Info("Stream offline event received",
+		"broadcaster_id", streamEvent.BroadcasterUserID,
+		"broadcaster_login", streamEvent.BroadcasterUserLogin,
+		"broadcaster_name", streamEvent.BroadcasterUserName)
+
+	if p.findStreamerConfig(streamEvent.BroadcasterUserID, streamEvent.BroadcasterUserLogin) == nil {
+		p.logger.Info("Stream event for unconfigured streamer, responding with 410 Gone",
+			"broadcaster_login", streamEvent.BroadcasterUserLogin)
+		return &ProcessedEvent{
+			Type:   "unconfigured_streamer",
+			Action: "revoke",
+		}, nil
+	}
+
+	return &ProcessedEvent{
+		Type:   SubscriptionTypeStreamOffline,
 		Event:  streamEvent,
 		Action: "process",
 	}, nil
 }
 
-// handleRevocation handles subscription revocation
+// handleChannelUpdate handles channel.update events, reporting only title or category changes
+func (p *Processor) handleChannelUpdate(notification EventSubNotification) (*ProcessedEvent, error) {
+	eventData, err := json.Marshal(notification.Event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event data: %w", err)
+	}
+
+	var updateEvent ChannelUpdateEvent
+	if err := json.Unmarshal(eventData, &updateEvent); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal channel update event: %w", err)
+	}
+
+	p.logger.Info("Channel update event received",
+		"broadcaster_id", updateEvent.BroadcasterUserID,
+		"broadcaster_login", updateEvent.BroadcasterUserLogin,
+		"title", updateEvent.Title,
+		"category_name", updateEvent.CategoryName)
+
+	if p.findStreamerConfig(updateEvent.BroadcasterUserID, updateEvent.BroadcasterUserLogin) == nil {
+		p.logger.Info("Channel update for unconfigured streamer, responding with 410 Gone",
+			"broadcaster_login", updateEvent.BroadcasterUserLogin)
+		return &ProcessedEvent{
+			Type:   "unconfigured_streamer",
+			Action: "revoke",
+		}, nil
+	}
+
+	p.channelMutex.Lock()
+	previous, known := p.channelInfo[updateEvent.BroadcasterUserID]
+	p.channelInfo[updateEvent.BroadcasterUserID] = ChannelInfo{
+		BroadcasterID:       updateEvent.BroadcasterUserID,
+		BroadcasterLogin:    updateEvent.BroadcasterUserLogin,
+		BroadcasterName:     updateEvent.BroadcasterUserName,
+		BroadcasterLanguage: updateEvent.Language,
+		GameID:              updateEvent.CategoryID,
+		GameName:            updateEvent.CategoryName,
+		Title:               updateEvent.Title,
+		Tags:                previous.Tags,
+		IsMature:            previous.IsMature,
+	}
+	p.channelMutex.Unlock()
+
+	if !known {
+		// Without a baseline, e.g. when seeding it failed, we cannot tell what changed, so only
+		// remember the current state
+		p.logger.Warn("No previous channel info known, recording baseline",
+			"broadcaster_login", updateEvent.BroadcasterUserLogin)
+		return &ProcessedEvent{
+			Type:   "channel_unchanged",
+			Action: "ignore",
+		}, nil
+	}
+
+	changes := diffChannelInfo(previous, updateEvent)
+	if len(changes) == 0 {
+		p.logger.Debug("Channel update without title or category change",
+			"broadcaster_login", updateEvent.BroadcasterUserLogin)
+		return &ProcessedEvent{
+			Type:   "channel_unchanged",
+			Action: "ignore",
+		}, nil
+	}
+
+	return &ProcessedEvent{
+		Type: SubscriptionTypeChannelUpdate,
+		Event: ChannelChangeEvent{
+			ChannelUpdateEvent:   updateEvent,
+			Changes:              changes,
+			PreviousTitle:        previous.Title,
+			PreviousCategoryID:   previous.GameID,
+			PreviousCategoryName: previous.GameName,
+		},
+		Action: "process",
+	}, nil
+}
+
+// diffChannelInfo returns the changes between the last-known channel info and an update
+func diffChannelInfo(previous ChannelInfo, update ChannelUpdateEvent) []string {
+	var changes []string
+	if previous.Title != update.Title {
+		changes = append(changes, ChannelChangeTitle)
+	}
+	if previous.GameID != update.CategoryID {
+		changes = append(changes, ChannelChangeCategory)
+	}
+	return changes
+}
+
+// handleRevocation handles subscription revocation; the reaction depends on the revocation status
 func (p *Processor) handleRevocation(notification EventSubNotification) (*ProcessedEvent, error) {
 	p.logger.Warn("Subscription revoked",
 		"subscription_id", notification.Subscription.ID,
@@ -144,13 +349,17 @@ func (p *Processor) handleRevocation(notification EventSubNotification) (*Proces
 
 	return &ProcessedEvent{
 		Type:   "revocation",
-		Action: "ignore",
+		Event:  SubscriptionRevocation{Subscription: notification.Subscription},
+		Action: "process",
 	}, nil
 }
 
-// findStreamerConfig finds a streamer configuration by user ID or login
+// findStreamerConfig finds an enabled streamer configuration by user ID or login
 func (p *Processor) findStreamerConfig(userID, login string) *config.StreamerConfig {
-	for _, streamerConfig := range p.config.Streamers {
+	for key, streamerConfig := range p.config.Streamers {
+		if streamerConfig.Disabled || (p.isDisabled != nil && p.isDisabled(key)) {
+			continue
+		}
 		if streamerConfig.UserID == userID ||
 			strings.EqualFold(streamerConfig.Login, login) {
 			return &streamerConfig
@@ -1,7 +1,9 @@
 package twitch
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"os"
 	"testing"
@@ -136,6 +138,228 @@ func TestProcessNotificationStreamOnlineUnconfiguredStreamer(t *testing.T) {
 	assert.Equal(t, "revoke", result.Action)
 }
 
+func TestProcessNotificationStreamOffline(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"test_streamer": {
+			UserID:           "123456789",
+			Login:            "teststreamer",
+			TargetWebhookURL: "https://example.com/webhook",
+		},
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	processor := NewProcessor(cfg, logger)
+
+	headers := EventSubHeaders{
+		MessageType:      MessageTypeNotification,
+		SubscriptionType: SubscriptionTypeStreamOffline,
+	}
+
+	tests := []struct {
+		name           string
+		event          StreamOfflineEvent
+		expectedType   string
+		expectedAction string
+	}{
+		{
+			name: "configured streamer",
+			event: StreamOfflineEvent{
+				BroadcasterUserID:    "123456789",
+				BroadcasterUserLogin: "teststreamer",
+				BroadcasterUserName:  "Test Streamer",
+			},
+			expectedType:   SubscriptionTypeStreamOffline,
+			expectedAction: "process",
+		},
+		{
+			name: "unconfigured streamer",
+			event: StreamOfflineEvent{
+				BroadcasterUserID:    "999999999",
+				BroadcasterUserLogin: "unknownstreamer",
+			},
+			expectedType:   "unconfigured_streamer",
+			expectedAction: "revoke",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notification := EventSubNotification{
+				Event: tt.event,
+				Subscription: EventSubSubscription{
+					ID:   "sub_456",
+					Type: SubscriptionTypeStreamOffline,
+				},
+			}
+
+			payload, err := json.Marshal(notification)
+			require.NoError(t, err)
+
+			result, err := processor.ProcessNotification(headers, payload)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedType, result.Type)
+			assert.Equal(t, tt.expectedAction, result.Action)
+			if tt.expectedAction == "process" {
+				assert.Equal(t, tt.event, result.Event)
+			}
+		})
+	}
+}
+
+func TestProcessNotificationChannelUpdate(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"test_streamer": {
+			UserID: "123456789",
+			Login:  "teststreamer",
+			Events: []string{SubscriptionTypeChannelUpdate},
+		},
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	processor := NewProcessor(cfg, logger)
+
+	headers := EventSubHeaders{
+		MessageType:      MessageTypeNotification,
+		SubscriptionType: SubscriptionTypeChannelUpdate,
+	}
+
+	process := func(event ChannelUpdateEvent) *ProcessedEvent {
+		notification := EventSubNotification{
+			Event: event,
+			Subscription: EventSubSubscription{
+				ID:      "sub_789",
+				Type:    SubscriptionTypeChannelUpdate,
+				Version: "2",
+			},
+		}
+
+		payload, err := json.Marshal(notification)
+		require.NoError(t, err)
+
+		result, err := processor.ProcessNotification(headers, payload)
+		require.NoError(t, err)
+		return result
+	}
+
+	event := ChannelUpdateEvent{
+		BroadcasterUserID:    "123456789",
+		BroadcasterUserLogin: "teststreamer",
+		BroadcasterUserName:  "Test Streamer",
+		Title:                "Morning coffee",
+		Language:             "en",
+		CategoryID:           "509658",
+		CategoryName:         "Just Chatting",
+	}
+
+	// The first update only establishes the baseline
+	result := process(event)
+	assert.Equal(t, "channel_unchanged", result.Type)
+	assert.Equal(t, "ignore", result.Action)
+
+	// Same title and category (e.g. only the language changed) is not reported
+	event.Language = "de"
+	result = process(event)
+	assert.Equal(t, "ignore", result.Action)
+
+	// A category switch is reported with the previous category
+	event.CategoryID = "32399"
+	event.CategoryName = "Counter-Strike"
+	result = process(event)
+	require.Equal(t, "process", result.Action)
+	change, ok := result.Event.(ChannelChangeEvent)
+	require.True(t, ok)
+	assert.Equal(t, []string{ChannelChangeCategory}, change.Changes)
+	assert.Equal(t, "Just Chatting", change.PreviousCategoryName)
+	assert.Equal(t, "Counter-Strike", change.CategoryName)
+
+	// Title changes are diffed against the channel info recorded during enrichment
+	processor.RecordChannelInfo(ChannelInfo{
+		BroadcasterID: "123456789",
+		Title:         "Ranked grind",
+		GameID:        "32399",
+		GameName:      "Counter-Strike",
+	})
+	result = process(event)
+	require.Equal(t, "process", result.Action)
+	change = result.Event.(ChannelChangeEvent)
+	assert.Equal(t, []string{ChannelChangeTitle}, change.Changes)
+	assert.Equal(t, "Ranked grind", change.PreviousTitle)
+
+	// A change that could not be delivered is reported again on redelivery
+	processor.RevertChannelChange(change)
+	result = process(event)
+	require.Equal(t, "process", result.Action)
+	assert.Equal(t, change, result.Event)
+
+	// Reverting does not undo a newer update
+	event.Title = "Late night"
+	result = process(event)
+	require.Equal(t, "process", result.Action)
+	processor.RevertChannelChange(change)
+	assert.Equal(t, "Late night", processor.channelInfo["123456789"].Title)
+}
+
+func TestSeedChannelInfo(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"updates":  {UserID: "111", Login: "updates", Events: []string{SubscriptionTypeChannelUpdate}},
+		"online":   {UserID: "222", Login: "online", Events: []string{SubscriptionTypeStreamOnline}},
+		"known":    {UserID: "333", Login: "known", Events: []string{SubscriptionTypeChannelUpdate}},
+		"failing":  {UserID: "444", Login: "failing", Events: []string{SubscriptionTypeChannelUpdate}},
+		"disabled": {UserID: "555", Login: "disabled", Events: []string{SubscriptionTypeChannelUpdate}, Disabled: true},
+	}
+	processor := NewProcessor(cfg, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	processor.RecordChannelInfo(ChannelInfo{BroadcasterID: "333", Title: "Recorded"})
+
+	var fetched []string
+	processor.SeedChannelInfo(context.Background(), func(ctx context.Context, broadcasterID string) (*ChannelInfo, error) {
+		fetched = append(fetched, broadcasterID)
+		if broadcasterID == "444" {
+			return nil, errors.New("helix unavailable")
+		}
+		return &ChannelInfo{BroadcasterID: broadcasterID, Title: "Morning coffee", GameID: "509658", GameName: "Just Chatting"}, nil
+	})
+	assert.ElementsMatch(t, []string{"111", "444"}, fetched)
+	assert.Equal(t, "Recorded", processor.channelInfo["333"].Title)
+
+	// Streamers added on a config reload are seeded too
+	reloaded := config.DefaultConfig()
+	reloaded.Streamers = map[string]config.StreamerConfig{
+		"updates": cfg.Streamers["updates"],
+		"added":   {UserID: "666", Login: "added", Events: []string{SubscriptionTypeChannelUpdate}},
+	}
+	processor.UpdateConfig(reloaded)
+	fetched = nil
+	processor.SeedChannelInfo(context.Background(), func(ctx context.Context, broadcasterID string) (*ChannelInfo, error) {
+		fetched = append(fetched, broadcasterID)
+		return &ChannelInfo{BroadcasterID: broadcasterID, Title: "Fresh start"}, nil
+	})
+	assert.Equal(t, []string{"666"}, fetched)
+
+	// The first update is diffed against the seeded baseline
+	notification := EventSubNotification{
+		Subscription: EventSubSubscription{Type: SubscriptionTypeChannelUpdate, Version: "2"},
+		Event: ChannelUpdateEvent{
+			BroadcasterUserID:    "111",
+			BroadcasterUserLogin: "updates",
+			Title:                "Ranked grind",
+			CategoryID:           "509658",
+			CategoryName:         "Just Chatting",
+		},
+	}
+	payload, err := json.Marshal(notification)
+	require.NoError(t, err)
+	result, err := processor.ProcessNotification(EventSubHeaders{MessageType: MessageTypeNotification, SubscriptionType: SubscriptionTypeChannelUpdate}, payload)
+	require.NoError(t, err)
+	require.Equal(t, "process", result.Action)
+	change := result.Event.(ChannelChangeEvent)
+	assert.Equal(t, []string{ChannelChangeTitle}, change.Changes)
+	assert.Equal(t, "Morning coffee", change.PreviousTitle)
+}
+
 func TestProcessNotificationRevocation(t *testing.T) {
 	cfg := config.DefaultConfig()
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
@@ -160,7 +384,12 @@ func TestProcessNotificationRevocation(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, "revocation", result.Type)
-	assert.Equal(t, "ignore", result.Action)
+	assert.Equal(t, "process", result.Action)
+
+	revocation, ok := result.Event.(SubscriptionRevocation)
+	require.True(t, ok)
+	assert.Equal(t, "sub_123", revocation.Subscription.ID)
+	assert.Equal(t, SubscriptionStatusAuthorizationRevoked, revocation.Subscription.Status)
 }
 
 func TestProcessNotificationUnsupportedSubscriptionType(t *testing.T) {
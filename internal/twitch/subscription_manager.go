@@ -4,13 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/rmoriz/itsjustintv/internal/webhook"
 )
 
 // SubscriptionManager handles Twitch EventSub subscription lifecycle
@@ -20,6 +27,26 @@ type SubscriptionManager struct {
 	client      *Client
 	httpClient  *http.Client
 	callbackURL string
+	apiBaseURL  string
+
+	// disabled holds streamers disabled at runtime (e.g. after a revocation) with the reason
+	disabled      map[string]string
+	disabledMutex sync.RWMutex
+
+	// sessionID is the EventSub WebSocket session subscriptions are bound to (websocket transport)
+	sessionID    string
+	sessionMutex sync.RWMutex
+
+	// userToken authenticates WebSocket subscriptions (websocket transport)
+	oauthBaseURL   string
+	userToken      userToken
+	userTokenMutex sync.Mutex
+	alertHandler   func(webhook.AlertPayload)
+
+	// secretFingerprint identifies the secret the webhook subscriptions were created with
+	secretFile        string
+	secretFingerprint string
+	secretMutex       sync.Mutex
 }
 
 // SubscriptionRequest represents a request to create an EventSub subscription
@@ -32,42 +59,77 @@ type SubscriptionRequest struct {
 
 // SubscriptionTransport represents the transport configuration for subscriptions
 type SubscriptionTransport struct {
-	Method   string `json:"method"`
-	Callback string `json:"callback"`
-	Secret   string `json:"secret"`
+	Method    string `json:"method"`
+	Callback  string `json:"callback,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
 }
 
-// SubscriptionResponse represents the response from creating a subscription
+// SubscriptionResponse represents the response from creating or listing subscriptions
 type SubscriptionResponse struct {
 	Data         []EventSubSubscription `json:"data"`
 	Total        int                    `json:"total"`
 	TotalCost    int                    `json:"total_cost"`
 	MaxTotalCost int                    `json:"max_total_cost"`
+	Pagination   Pagination             `json:"pagination"`
+}
+
+// Pagination represents the Helix pagination cursor
+type Pagination struct {
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// SubscriptionFilter narrows down the subscriptions returned by GetSubscriptions.
+// Twitch accepts at most one of Status and Type per request.
+type SubscriptionFilter struct {
+	Status string
+	Type   string
+}
+
+// helixBaseURL is the base URL of the Twitch Helix API
+const helixBaseURL = "https://api.twitch.tv/helix"
+
+// subscriptionVersions maps the supported EventSub subscription types to the version we subscribe to
+var subscriptionVersions = map[string]string{
+	SubscriptionTypeStreamOnline:  "1",
+	SubscriptionTypeStreamOffline: "1",
+	SubscriptionTypeChannelUpdate: "2",
 }
 
 // NewSubscriptionManager creates a new subscription manager
 func NewSubscriptionManager(cfg *config.Config, logger *slog.Logger, client *Client) *SubscriptionManager {
-	// Use incoming_webhook_url if specified, otherwise build from server config
-	callbackURL := cfg.Twitch.IncomingWebhookURL
-	if callbackURL == "" {
-		callbackURL = buildCallbackURL(cfg)
-	}
-
 	return &SubscriptionManager{
-		config:      cfg,
-		logger:      logger,
-		client:      client,
-		httpClient:  &http.Client{Timeout: 30 * time.Second},
-		callbackURL: callbackURL,
+		config:       cfg,
+		logger:       logger,
+		client:       client,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		callbackURL:  resolveCallbackURL(cfg),
+		apiBaseURL:   helixBaseURL,
+		oauthBaseURL: oauthBaseURL,
+		disabled:     make(map[string]string),
 	}
 }
 
 // Start initializes subscription management
 func (sm *SubscriptionManager) Start(ctx context.Context) error {
+	if sm.config.Twitch.Transport == config.TransportWebSocket {
+		// Subscriptions are synced once the WebSocket session is established
+		sm.logger.Info("Starting EventSub subscription manager", "transport", config.TransportWebSocket)
+		if _, err := sm.userAccessToken(ctx); err != nil {
+			return fmt.Errorf("invalid user access token: %w", err)
+		}
+		go sm.backgroundSync(ctx)
+		return nil
+	}
+
 	sm.logger.Info("Starting EventSub subscription manager", "callback_url", sm.callbackURL)
 
-	// Initial subscription sync
-	if err := sm.syncSubscriptions(ctx); err != nil {
+	// Initial subscription sync. Subscriptions that could not be changed are retried by the
+	// background sync.
+	var syncErr *SyncFailedError
+	if err := sm.syncSubscriptions(ctx); errors.As(err, &syncErr) {
+		sm.logger.Error("Initial subscription sync incomplete", "error", err)
+	} else if err != nil {
 		return fmt.Errorf("failed to sync subscriptions: %w", err)
 	}
 
@@ -77,19 +139,69 @@ func (sm *SubscriptionManager) Start(ctx context.Context) error {
 	return nil
 }
 
-// SyncSubscriptions fetches current subscriptions and creates missing ones
+// SyncSubscriptions reconciles the current subscriptions with the configuration
 func (sm *SubscriptionManager) SyncSubscriptions(ctx context.Context) error {
 	return sm.syncSubscriptions(ctx)
 }
 
-// syncSubscriptions fetches current subscriptions and creates missing ones
+// syncSubscriptions reconciles the current subscriptions with the configuration: missing
+// subscriptions are created, failed or stale ones recreated and unwanted ones deleted
 func (sm *SubscriptionManager) syncSubscriptions(ctx context.Context) error {
 	sm.logger.Info("Syncing EventSub subscriptions")
 
-	// Get current subscriptions
-	currentSubs, err := sm.getSubscriptions(ctx)
+	plan, err := sm.PlanSync(ctx)
+	if err != nil {
+		return err
+	}
+
+	return sm.ApplySyncPlan(ctx, plan)
+}
+
+// Sync action kinds
+const (
+	SyncActionKeep     = "keep"
+	SyncActionCreate   = "create"
+	SyncActionDelete   = "delete"
+	SyncActionRecreate = "recreate"
+)
+
+// estimatedSubscriptionCost is the cost Twitch charges for a subscription created with an
+// app access token for a broadcaster that has not authorized the application
+const estimatedSubscriptionCost = 1
+
+// SyncPlan describes the actions a subscription sync takes and their effect on subscription cost
+type SyncPlan struct {
+	Actions            []SyncAction   `json:"actions"`
+	Summary            map[string]int `json:"summary"`
+	TotalCost          int            `json:"total_cost"`
+	MaxTotalCost       int            `json:"max_total_cost"`
+	CostDelta          int            `json:"cost_delta"`
+	ProjectedTotalCost int            `json:"projected_total_cost"`
+	ExceedsMaxCost     bool           `json:"exceeds_max_total_cost"`
+}
+
+// SyncAction describes a single reconciliation step
+type SyncAction struct {
+	Action         string                 `json:"action"`
+	Reason         string                 `json:"reason,omitempty"`
+	StreamerKey    string                 `json:"streamer_key,omitempty"`
+	Type           string                 `json:"type"`
+	Version        string                 `json:"version"`
+	Condition      map[string]interface{} `json:"condition"`
+	SubscriptionID string                 `json:"subscription_id,omitempty"`
+	Status         string                 `json:"status,omitempty"`
+	CostDelta      int                    `json:"cost_delta"`
+
+	subscription *EventSubSubscription // existing subscription (keep, delete, recreate)
+	desired      *desiredSubscription  // wanted subscription (keep, create, recreate)
+}
+
+// PlanSync fetches the current subscriptions and computes the actions needed to reconcile
+// them with the configuration, without changing anything
+func (sm *SubscriptionManager) PlanSync(ctx context.Context) (*SyncPlan, error) {
+	currentSubs, err := sm.getSubscriptions(ctx, SubscriptionFilter{})
 	if err != nil {
-		return fmt.Errorf("failed to get current subscriptions: %w", err)
+		return nil, fmt.Errorf("failed to get current subscriptions: %w", err)
 	}
 
 	sm.logger.Info("Current EventSub subscriptions",
@@ -97,72 +209,403 @@ func (sm *SubscriptionManager) syncSubscriptions(ctx context.Context) error {
 		"total_cost", currentSubs.TotalCost,
 		"max_total_cost", currentSubs.MaxTotalCost)
 
-	// Build map of existing subscriptions by broadcaster_user_id
-	existingSubs := make(map[string]*EventSubSubscription)
-	for i := range currentSubs.Data {
-		sub := &currentSubs.Data[i]
-		if sub.Type == "stream.online" && sub.Status == SubscriptionStatusEnabled {
-			if broadcasterID, ok := sub.Condition["broadcaster_user_id"].(string); ok {
-				existingSubs[broadcasterID] = sub
+	actions := sm.planSync(currentSubs.Data, sm.desiredSubscriptions())
+
+	plan := &SyncPlan{
+		Actions:      actions,
+		Summary:      make(map[string]int),
+		TotalCost:    currentSubs.TotalCost,
+		MaxTotalCost: currentSubs.MaxTotalCost,
+	}
+	for _, action := range actions {
+		plan.Summary[action.Action]++
+		plan.CostDelta += action.CostDelta
+	}
+	plan.ProjectedTotalCost = plan.TotalCost + plan.CostDelta
+	plan.ExceedsMaxCost = plan.MaxTotalCost > 0 && plan.ProjectedTotalCost > plan.MaxTotalCost
+
+	return plan, nil
+}
+
+// ApplySyncPlan executes the create and delete calls of a sync plan
+func (sm *SubscriptionManager) ApplySyncPlan(ctx context.Context, plan *SyncPlan) error {
+	if sm.config.Twitch.Transport == config.TransportWebSocket && sm.WebSocketSession() == "" {
+		return fmt.Errorf("no EventSub WebSocket session established")
+	}
+
+	if plan.ExceedsMaxCost {
+		sm.logger.Warn("Subscription sync will exceed the maximum total cost",
+			"projected_total_cost", plan.ProjectedTotalCost,
+			"max_total_cost", plan.MaxTotalCost)
+	}
+
+	var kept, created, deleted, recreated, failed int
+	for _, action := range plan.Actions {
+		switch action.Action {
+		case SyncActionKeep:
+			kept++
+			sm.logger.Debug("Subscription already exists",
+				"subscription_id", action.SubscriptionID,
+				"streamer_key", action.StreamerKey,
+				"type", action.Type)
+
+		case SyncActionDelete:
+			if err := sm.deleteSubscription(ctx, action.SubscriptionID); err != nil {
+				failed++
+				sm.logger.Error("Failed to delete subscription",
+					"error", err,
+					"subscription_id", action.SubscriptionID,
+					"type", action.Type,
+					"reason", action.Reason)
+				continue
 			}
+			deleted++
+			sm.logger.Info("Deleted EventSub subscription",
+				"subscription_id", action.SubscriptionID,
+				"type", action.Type,
+				"condition", action.Condition,
+				"reason", action.Reason)
+
+		case SyncActionRecreate:
+			if err := sm.deleteSubscription(ctx, action.SubscriptionID); err != nil {
+				failed++
+				sm.logger.Error("Failed to delete subscription for recreation",
+					"error", err,
+					"subscription_id", action.SubscriptionID,
+					"streamer_key", action.StreamerKey,
+					"type", action.Type,
+					"reason", action.Reason)
+				continue
+			}
+			if err := sm.createSubscription(ctx, *action.desired); err != nil {
+				failed++
+				sm.logger.Error("Failed to recreate subscription",
+					"error", err,
+					"streamer_key", action.StreamerKey,
+					"type", action.Type,
+					"reason", action.Reason)
+				continue
+			}
+			recreated++
+			sm.logger.Info("Recreated EventSub subscription",
+				"streamer_key", action.StreamerKey,
+				"type", action.Type,
+				"condition", action.Condition,
+				"reason", action.Reason)
+
+		case SyncActionCreate:
+			if err := sm.createSubscription(ctx, *action.desired); err != nil {
+				failed++
+				sm.logger.Error("Failed to create subscription",
+					"error", err,
+					"streamer_key", action.StreamerKey,
+					"type", action.Type,
+					"condition", action.Condition)
+				continue
+			}
+			created++
+			sm.logger.Info("Created EventSub subscription",
+				"streamer_key", action.StreamerKey,
+				"type", action.Type,
+				"condition", action.Condition)
 		}
 	}
 
-	// Check each configured streamer
-	var created, existing int
-	for streamerKey, streamerConfig := range sm.config.Streamers {
-		if streamerConfig.UserID == "" {
-			sm.logger.Warn("Skipping streamer with missing user_id", "streamer_key", streamerKey)
+	sm.logger.Info("Subscription sync complete",
+		"existing", kept,
+		"created", created,
+		"recreated", recreated,
+		"deleted", deleted,
+		"failed", failed)
+
+	if failed > 0 {
+		return &SyncFailedError{Failed: failed, Total: failed + created + recreated + deleted}
+	}
+	sm.saveWebhookSecretFingerprint()
+
+	return nil
+}
+
+// SyncFailedError reports that some actions of a subscription sync failed; the others were applied
+// and the next sync retries the failed ones
+type SyncFailedError struct {
+	Failed int // failed actions
+	Total  int // create, delete and recreate actions
+}
+
+func (e *SyncFailedError) Error() string {
+	return fmt.Sprintf("%d of %d subscription changes failed", e.Failed, e.Total)
+}
+
+// newSyncAction builds a sync action from an existing and/or desired subscription
+func newSyncAction(action, reason string, sub *EventSubSubscription, desired *desiredSubscription) SyncAction {
+	result := SyncAction{
+		Action:       action,
+		Reason:       reason,
+		subscription: sub,
+		desired:      desired,
+	}
+
+	if desired != nil {
+		result.StreamerKey = desired.StreamerKey
+		result.Type = desired.Type
+		result.Version = desired.Version
+		result.Condition = desired.Condition
+	}
+	if sub != nil {
+		result.Type = sub.Type
+		result.Version = sub.Version
+		result.Condition = sub.Condition
+		result.SubscriptionID = sub.ID
+		result.Status = sub.Status
+	}
+
+	switch action {
+	case SyncActionCreate:
+		result.CostDelta = estimatedSubscriptionCost
+	case SyncActionDelete:
+		result.CostDelta = -sub.Cost
+	case SyncActionRecreate:
+		result.CostDelta = estimatedSubscriptionCost - sub.Cost
+	}
+
+	return result
+}
+
+// planSync compares the current subscriptions with the desired set and returns the
+// actions needed to reconcile them, with deletions ordered before creations. Wanted webhook
+// subscriptions with another callback URL are recreated; unwanted ones are only deleted if
+// they are ours.
+func (sm *SubscriptionManager) planSync(current []EventSubSubscription, desired []desiredSubscription) []SyncAction {
+	desiredByKey := make(map[string]*desiredSubscription, len(desired))
+	for i := range desired {
+		desiredByKey[desired[i].key()] = &desired[i]
+	}
+
+	actions := make([]SyncAction, 0, len(current)+len(desired))
+	covered := make(map[string]bool)
+	handled := make(map[int]bool)
+
+	// Keep healthy subscriptions first so duplicates of them are deleted rather than recreated
+	for i := range current {
+		sub := &current[i]
+		key := subscriptionKey(sub.Type, sub.Version, sub.Condition)
+		wanted, ok := desiredByKey[key]
+		if !ok || covered[key] || sm.replacementReason(sub) != "" {
 			continue
 		}
+		covered[key] = true
+		handled[i] = true
+		actions = append(actions, newSyncAction(SyncActionKeep, "", sub, wanted))
+	}
 
-		if _, exists := existingSubs[streamerConfig.UserID]; exists {
-			existing++
-			sm.logger.Debug("Subscription already exists",
-				"streamer_key", streamerKey,
-				"user_id", streamerConfig.UserID)
+	for i := range current {
+		if handled[i] {
 			continue
 		}
+		sub := &current[i]
+		key := subscriptionKey(sub.Type, sub.Version, sub.Condition)
+		wanted, ok := desiredByKey[key]
+
+		switch {
+		case (!ok || covered[key]) && !sm.ownsSubscription(sub):
+			// Another deployment's subscription; it is not ours to delete
+		case !ok:
+			actions = append(actions, newSyncAction(SyncActionDelete, "not in configuration", sub, nil))
+		case covered[key]:
+			actions = append(actions, newSyncAction(SyncActionDelete, "duplicate subscription", sub, nil))
+		case sub.Transport.Method == config.TransportWebSocket && !sm.ownsSubscription(sub):
+			// Bound to another live session; ours is created alongside it
+		default:
+			covered[key] = true
+			actions = append(actions, newSyncAction(SyncActionRecreate, sm.replacementReason(sub), sub, wanted))
+		}
+	}
 
-		// Create subscription
-		if err := sm.createSubscription(ctx, streamerConfig.UserID); err != nil {
-			sm.logger.Error("Failed to create subscription",
-				"error", err,
-				"streamer_key", streamerKey,
-				"user_id", streamerConfig.UserID)
+	for i := range desired {
+		if covered[desired[i].key()] {
 			continue
 		}
+		actions = append(actions, newSyncAction(SyncActionCreate, "missing subscription", nil, &desired[i]))
+	}
+
+	return actions
+}
 
-		created++
-		sm.logger.Info("Created EventSub subscription",
-			"streamer_key", streamerKey,
-			"user_id", streamerConfig.UserID)
+// ownsSubscription reports whether an unwanted subscription may be deleted: a webhook pointing
+// at our callback URL or one of previous_callback_urls, or a subscription of our WebSocket
+// session. Subscriptions of a WebSocket session that is gone (websocket_disconnected and the
+// other failure statuses) never deliver again and are cleaned up too. Other deployments may
+// share the client ID, so their subscriptions are left alone.
+func (sm *SubscriptionManager) ownsSubscription(sub *EventSubSubscription) bool {
+	switch sub.Transport.Method {
+	case config.TransportWebhook:
+		return sub.Transport.Callback == sm.callbackURL || slices.Contains(sm.config.Twitch.PreviousCallbackURLs, sub.Transport.Callback)
+	case config.TransportWebSocket:
+		session := sm.WebSocketSession()
+		return sub.Status != SubscriptionStatusEnabled || (session != "" && sub.Transport.SessionID == session)
+	default:
+		return false
 	}
+}
 
-	sm.logger.Info("Subscription sync complete",
-		"existing", existing,
-		"created", created)
+// replacementReason returns why an existing subscription has to be replaced, or an
+// empty string if it is healthy
+func (sm *SubscriptionManager) replacementReason(sub *EventSubSubscription) string {
+	switch sub.Status {
+	case SubscriptionStatusEnabled, SubscriptionStatusWebhookCallbackVerificationPending:
+	default:
+		return "status " + sub.Status
+	}
 
-	return nil
+	wantMethod := sm.transport().Method
+	if sub.Transport.Method != wantMethod {
+		return "transport changed"
+	}
+	if sub.Transport.Method == config.TransportWebhook && sub.Transport.Callback != sm.callbackURL {
+		return "callback URL changed"
+	}
+	if sub.Transport.Method == config.TransportWebhook && sm.webhookSecretChanged() {
+		return "webhook secret changed"
+	}
+	if sub.Transport.Method == config.TransportWebSocket && sub.Transport.SessionID != sm.WebSocketSession() {
+		return "WebSocket session changed"
+	}
+
+	return ""
 }
 
-// createSubscription creates a new EventSub subscription for a broadcaster
-func (sm *SubscriptionManager) createSubscription(ctx context.Context, broadcasterUserID string) error {
-	if err := sm.client.EnsureValidToken(ctx); err != nil {
-		return fmt.Errorf("failed to ensure valid token: %w", err)
+// desiredSubscription describes an EventSub subscription required by the configuration
+type desiredSubscription struct {
+	StreamerKey string
+	Type        string
+	Version     string
+	Condition   map[string]interface{}
+}
+
+// key returns the reconciliation key of the desired subscription
+func (d desiredSubscription) key() string {
+	return subscriptionKey(d.Type, d.Version, d.Condition)
+}
+
+// desiredSubscriptions computes the set of subscriptions required by the configured streamers
+func (sm *SubscriptionManager) desiredSubscriptions() []desiredSubscription {
+	streamerKeys := make([]string, 0, len(sm.config.Streamers))
+	for key := range sm.config.Streamers {
+		streamerKeys = append(streamerKeys, key)
+	}
+	sort.Strings(streamerKeys)
+
+	desired := make([]desiredSubscription, 0, len(streamerKeys))
+	seen := make(map[string]bool)
+	for _, streamerKey := range streamerKeys {
+		streamerConfig := sm.config.Streamers[streamerKey]
+		if streamerConfig.Disabled || sm.IsStreamerDisabled(streamerKey) {
+			continue
+		}
+		if streamerConfig.UserID == "" {
+			sm.logger.Warn("Skipping streamer with missing user_id", "streamer_key", streamerKey)
+			continue
+		}
+
+		for _, eventType := range streamerConfig.GetEvents() {
+			version, ok := subscriptionVersions[eventType]
+			if !ok {
+				sm.logger.Warn("Skipping unsupported event type",
+					"streamer_key", streamerKey,
+					"type", eventType)
+				continue
+			}
+
+			sub := desiredSubscription{
+				StreamerKey: streamerKey,
+				Type:        eventType,
+				Version:     version,
+				Condition: map[string]interface{}{
+					"broadcaster_user_id": streamerConfig.UserID,
+				},
+			}
+
+			// Two config entries may point at the same broadcaster
+			if seen[sub.key()] {
+				continue
+			}
+			seen[sub.key()] = true
+			desired = append(desired, sub)
+		}
 	}
 
+	return desired
+}
+
+// DisableStreamer stops maintaining subscriptions for a streamer until the process restarts
+func (sm *SubscriptionManager) DisableStreamer(streamerKey, reason string) {
+	sm.disabledMutex.Lock()
+	defer sm.disabledMutex.Unlock()
+
+	sm.disabled[streamerKey] = reason
+	sm.logger.Warn("Streamer disabled", "streamer_key", streamerKey, "reason", reason)
+}
+
+// IsStreamerDisabled reports whether a streamer was disabled at runtime
+func (sm *SubscriptionManager) IsStreamerDisabled(streamerKey string) bool {
+	sm.disabledMutex.RLock()
+	defer sm.disabledMutex.RUnlock()
+
+	_, ok := sm.disabled[streamerKey]
+	return ok
+}
+
+// RecreateSubscription replaces a revoked subscription if the configuration still requires it.
+// It reports whether a replacement was created.
+func (sm *SubscriptionManager) RecreateSubscription(ctx context.Context, sub EventSubSubscription) (bool, error) {
+	key := subscriptionKey(sub.Type, sub.Version, sub.Condition)
+	for _, desired := range sm.desiredSubscriptions() {
+		if desired.key() != key {
+			continue
+		}
+
+		// Revoked subscriptions may linger in the list; remove it before creating the replacement
+		if err := sm.deleteSubscription(ctx, sub.ID); err != nil {
+			return false, fmt.Errorf("failed to delete revoked subscription: %w", err)
+		}
+		if err := sm.createSubscription(ctx, desired); err != nil {
+			return false, fmt.Errorf("failed to recreate subscription: %w", err)
+		}
+
+		sm.logger.Info("Recreated revoked subscription",
+			"streamer_key", desired.StreamerKey,
+			"type", sub.Type,
+			"previous_id", sub.ID)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// subscriptionKey builds a lookup key from a subscription type, version and condition
+func subscriptionKey(subscriptionType, version string, condition map[string]interface{}) string {
+	conditionKeys := make([]string, 0, len(condition))
+	for key := range condition {
+		conditionKeys = append(conditionKeys, key)
+	}
+	sort.Strings(conditionKeys)
+
+	parts := make([]string, 0, len(conditionKeys)+2)
+	parts = append(parts, subscriptionType, version)
+	for _, key := range conditionKeys {
+		parts = append(parts, fmt.Sprintf("%s=%v", key, condition[key]))
+	}
+	return strings.Join(parts, "|")
+}
+
+// createSubscription creates a new EventSub subscription
+func (sm *SubscriptionManager) createSubscription(ctx context.Context, desired desiredSubscription) error {
 	request := SubscriptionRequest{
-		Type:    "stream.online",
-		Version: "1",
-		Condition: map[string]interface{}{
-			"broadcaster_user_id": broadcasterUserID,
-		},
-		Transport: SubscriptionTransport{
-			Method:   "webhook",
-			Callback: sm.callbackURL,
-			Secret:   sm.config.Twitch.WebhookSecret,
-		},
+		Type:      desired.Type,
+		Version:   desired.Version,
+		Condition: desired.Condition,
+		Transport: sm.transport(),
 	}
 
 	jsonData, err := json.Marshal(request)
@@ -170,12 +613,14 @@ func (sm *SubscriptionManager) createSubscription(ctx context.Context, broadcast
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.twitch.tv/helix/eventsub/subscriptions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", sm.apiBaseURL+"/eventsub/subscriptions", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	sm.client.setAuthHeaders(req)
+	if err := sm.authorize(ctx, req); err != nil {
+		return err
+	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := sm.httpClient.Do(req)
@@ -206,28 +651,157 @@ func (sm *SubscriptionManager) createSubscription(ctx context.Context, broadcast
 	sm.logger.Debug("Subscription created successfully",
 		"subscription_id", sub.ID,
 		"status", sub.Status,
-		"broadcaster_user_id", broadcasterUserID)
+		"type", sub.Type,
+		"condition", sub.Condition)
 
 	return nil
 }
 
-// GetSubscriptions retrieves current EventSub subscriptions
-func (sm *SubscriptionManager) GetSubscriptions(ctx context.Context) (*SubscriptionResponse, error) {
-	return sm.getSubscriptions(ctx)
+// transport returns the transport new subscriptions are created with
+func (sm *SubscriptionManager) transport() SubscriptionTransport {
+	if sm.config.Twitch.Transport == config.TransportWebSocket {
+		return SubscriptionTransport{
+			Method:    config.TransportWebSocket,
+			SessionID: sm.WebSocketSession(),
+		}
+	}
+
+	return SubscriptionTransport{
+		Method:   config.TransportWebhook,
+		Callback: sm.callbackURL,
+		Secret:   sm.config.Twitch.WebhookSecret,
+	}
 }
 
-// getSubscriptions retrieves current EventSub subscriptions
-func (sm *SubscriptionManager) getSubscriptions(ctx context.Context) (*SubscriptionResponse, error) {
+// authorize sets the authentication headers for a Helix request. Webhook subscriptions use the
+// app access token, WebSocket subscriptions require a user access token.
+func (sm *SubscriptionManager) authorize(ctx context.Context, req *http.Request) error {
+	if sm.config.Twitch.Transport == config.TransportWebSocket {
+		accessToken, err := sm.userAccessToken(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		req.Header.Set("Client-Id", sm.config.Twitch.ClientID)
+		return nil
+	}
+
 	if err := sm.client.EnsureValidToken(ctx); err != nil {
-		return nil, fmt.Errorf("failed to ensure valid token: %w", err)
+		return fmt.Errorf("failed to ensure valid token: %w", err)
+	}
+	sm.client.setAuthHeaders(req)
+	return nil
+}
+
+// SetWebSocketSession sets the WebSocket session new subscriptions are bound to
+func (sm *SubscriptionManager) SetWebSocketSession(sessionID string) {
+	sm.sessionMutex.Lock()
+	defer sm.sessionMutex.Unlock()
+
+	sm.sessionID = sessionID
+}
+
+// WebSocketSession returns the current WebSocket session ID, if any
+func (sm *SubscriptionManager) WebSocketSession() string {
+	sm.sessionMutex.RLock()
+	defer sm.sessionMutex.RUnlock()
+
+	return sm.sessionID
+}
+
+// deleteSubscription deletes an EventSub subscription by ID
+func (sm *SubscriptionManager) deleteSubscription(ctx context.Context, subscriptionID string) error {
+	endpoint := sm.apiBaseURL + "/eventsub/subscriptions?id=" + url.QueryEscape(subscriptionID)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.twitch.tv/helix/eventsub/subscriptions", nil)
+	if err := sm.authorize(ctx, req); err != nil {
+		return err
+	}
+
+	resp, err := sm.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		// Already gone, nothing left to clean up
+		sm.logger.Debug("Subscription already deleted", "subscription_id", subscriptionID)
+		return nil
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("subscription deletion failed with status %d: %s", resp.StatusCode, string(body))
+	}
+}
+
+// GetSubscriptions retrieves all current EventSub subscriptions matching the filter
+func (sm *SubscriptionManager) GetSubscriptions(ctx context.Context, filter SubscriptionFilter) (*SubscriptionResponse, error) {
+	return sm.getSubscriptions(ctx, filter)
+}
+
+// getSubscriptions retrieves all current EventSub subscriptions matching the filter,
+// following the pagination cursor until every page has been fetched
+func (sm *SubscriptionManager) getSubscriptions(ctx context.Context, filter SubscriptionFilter) (*SubscriptionResponse, error) {
+	if filter.Status != "" && filter.Type != "" {
+		return nil, fmt.Errorf("only one of status and type can be used to filter subscriptions")
+	}
+
+	var result *SubscriptionResponse
+	cursor := ""
+	for page := 1; ; page++ {
+		response, err := sm.getSubscriptionsPage(ctx, filter, cursor)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get subscriptions page %d: %w", page, err)
+		}
+
+		if result == nil {
+			result = response
+		} else {
+			result.Data = append(result.Data, response.Data...)
+		}
+
+		if response.Pagination.Cursor == "" || response.Pagination.Cursor == cursor {
+			break
+		}
+		cursor = response.Pagination.Cursor
+	}
+
+	result.Pagination = Pagination{}
+	return result, nil
+}
+
+// getSubscriptionsPage retrieves a single page of EventSub subscriptions
+func (sm *SubscriptionManager) getSubscriptionsPage(ctx context.Context, filter SubscriptionFilter, cursor string) (*SubscriptionResponse, error) {
+	query := url.Values{}
+	if filter.Status != "" {
+		query.Set("status", filter.Status)
+	}
+	if filter.Type != "" {
+		query.Set("type", filter.Type)
+	}
+	if cursor != "" {
+		query.Set("after", cursor)
+	}
+
+	endpoint := sm.apiBaseURL + "/eventsub/subscriptions"
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	sm.client.setAuthHeaders(req)
+	if err := sm.authorize(ctx, req); err != nil {
+		return nil, err
+	}
 
 	resp, err := sm.httpClient.Do(req)
 	if err != nil {
@@ -277,7 +851,7 @@ func (sm *SubscriptionManager) backgroundSync(ctx context.Context) {
 // UpdateConfig updates the subscription manager with new configuration
 func (sm *SubscriptionManager) UpdateConfig(newConfig *config.Config) error {
 	sm.config = newConfig
-	sm.callbackURL = buildCallbackURL(newConfig)
+	sm.callbackURL = resolveCallbackURL(newConfig)
 	sm.logger.Info("Updated subscription manager configuration")
 	return nil
 }
@@ -288,6 +862,14 @@ func (sm *SubscriptionManager) RefreshSubscriptions(ctx context.Context) error {
 	return sm.syncSubscriptions(ctx)
 }
 
+// resolveCallbackURL returns incoming_webhook_url if specified, otherwise builds it from server config
+func resolveCallbackURL(cfg *config.Config) string {
+	if cfg.Twitch.IncomingWebhookURL != "" {
+		return cfg.Twitch.IncomingWebhookURL
+	}
+	return buildCallbackURL(cfg)
+}
+
 // buildCallbackURL constructs the callback URL for EventSub subscriptions
 func buildCallbackURL(cfg *config.Config) string {
 	// Use external_domain if specified (for reverse proxy scenarios)
@@ -1,9 +1,19 @@
 package twitch
 
 import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBuildCallbackURL(t *testing.T) {
@@ -93,3 +103,484 @@ func TestBuildCallbackURL(t *testing.T) {
 		})
 	}
 }
+
+func TestDesiredSubscriptions(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"default_events": {
+			UserID: "111",
+			Login:  "defaultevents",
+		},
+		"custom_events": {
+			UserID: "222",
+			Login:  "customevents",
+			Events: []string{"stream.online", "channel.update"},
+		},
+		"unresolved": {
+			Login: "unresolved",
+		},
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	sm := NewSubscriptionManager(cfg, logger, nil)
+
+	desired := sm.desiredSubscriptions()
+	require.Len(t, desired, 4)
+
+	var got []string
+	for _, sub := range desired {
+		got = append(got, sub.StreamerKey+" "+sub.Type+" v"+sub.Version)
+	}
+
+	assert.Equal(t, []string{
+		"custom_events stream.online v1",
+		"custom_events channel.update v2",
+		"default_events stream.online v1",
+		"default_events stream.offline v1",
+	}, got)
+	assert.Equal(t, map[string]interface{}{"broadcaster_user_id": "222"}, desired[0].Condition)
+}
+
+func TestSubscriptionKey(t *testing.T) {
+	condition := map[string]interface{}{
+		"broadcaster_user_id": "123",
+		"moderator_user_id":   "456",
+	}
+
+	key := subscriptionKey("channel.update", "2", condition)
+	assert.Equal(t, "channel.update|2|broadcaster_user_id=123|moderator_user_id=456", key)
+
+	// Versions are part of the key so upgrades are reconciled
+	assert.NotEqual(t, key, subscriptionKey("channel.update", "1", condition))
+}
+
+// fakeHelix is a minimal in-memory stand-in for the Helix EventSub subscriptions API
+type fakeHelix struct {
+	mu       sync.Mutex
+	subs     []EventSubSubscription
+	nextID   int
+	pageSize int
+	pages    int
+	created  []SubscriptionRequest
+	deleted  []string
+	auth     string // Authorization header of the last request
+	conflict bool   // reject creates as duplicates
+}
+
+func (f *fakeHelix) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.auth = r.Header.Get("Authorization")
+
+	switch r.Method {
+	case http.MethodGet:
+		f.pages++
+		query := r.URL.Query()
+		matching := make([]EventSubSubscription, 0, len(f.subs))
+		for _, sub := range f.subs {
+			if status := query.Get("status"); status != "" && sub.Status != status {
+				continue
+			}
+			if subType := query.Get("type"); subType != "" && sub.Type != subType {
+				continue
+			}
+			matching = append(matching, sub)
+		}
+
+		pageSize := f.pageSize
+		if pageSize == 0 {
+			pageSize = 100
+		}
+		offset := 0
+		if after := query.Get("after"); after != "" {
+			_, _ = fmt.Sscanf(after, "offset-%d", &offset)
+		}
+		end := min(offset+pageSize, len(matching))
+
+		response := SubscriptionResponse{
+			Data:         matching[offset:end],
+			Total:        len(f.subs),
+			TotalCost:    len(f.subs),
+			MaxTotalCost: 10000,
+		}
+		if end < len(matching) {
+			response.Pagination.Cursor = fmt.Sprintf("offset-%d", end)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+
+	case http.MethodPost:
+		var req SubscriptionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if f.conflict {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.created = append(f.created, req)
+		f.nextID++
+		sub := EventSubSubscription{
+			ID:        fmt.Sprintf("new-%d", f.nextID),
+			Status:    SubscriptionStatusWebhookCallbackVerificationPending,
+			Type:      req.Type,
+			Version:   req.Version,
+			Condition: req.Condition,
+			Transport: EventSubTransport{Method: req.Transport.Method, Callback: req.Transport.Callback, SessionID: req.Transport.SessionID},
+			CreatedAt: time.Now(),
+			Cost:      1,
+		}
+		f.subs = append(f.subs, sub)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(SubscriptionResponse{Data: []EventSubSubscription{sub}})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		f.deleted = append(f.deleted, id)
+		for i, sub := range f.subs {
+			if sub.ID == id {
+				f.subs = append(f.subs[:i], f.subs[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newTestSubscriptionManager creates a subscription manager talking to the given fake Helix server
+func newTestSubscriptionManager(t *testing.T, cfg *config.Config, helix *fakeHelix) *SubscriptionManager {
+	server := httptest.NewServer(helix)
+	t.Cleanup(server.Close)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	client := NewClient(cfg, logger)
+	client.token = &AppAccessToken{
+		AccessToken: "test_token",
+		TokenType:   "bearer",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+
+	sm := NewSubscriptionManager(cfg, logger, client)
+	sm.apiBaseURL = server.URL
+	sm.oauthBaseURL = newFakeOAuth(t, map[string]int{"user_token": 14400}).URL
+	return sm
+}
+
+// testSubscription builds an existing subscription for reconciliation tests
+func testSubscription(id, subType, status, broadcasterID, callback string) EventSubSubscription {
+	return EventSubSubscription{
+		ID:        id,
+		Status:    status,
+		Type:      subType,
+		Version:   "1",
+		Condition: map[string]interface{}{"broadcaster_user_id": broadcasterID},
+		Transport: EventSubTransport{Method: "webhook", Callback: callback},
+		Cost:      1,
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Twitch.IncomingWebhookURL = "https://example.com/twitch"
+	cfg.Twitch.PreviousCallbackURLs = []string{"https://old.example.com/twitch"}
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"streamer1": {UserID: "111", Login: "streamer1", Events: []string{"stream.online"}},
+		"streamer2": {UserID: "222", Login: "streamer2", Events: []string{"stream.online"}},
+		"streamer3": {UserID: "333", Login: "streamer3", Events: []string{"stream.online"}},
+		"streamer4": {UserID: "444", Login: "streamer4", Events: []string{"stream.online", "stream.offline"}},
+		"streamer5": {UserID: "555", Login: "streamer5", Events: []string{"stream.online"}},
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	sm := NewSubscriptionManager(cfg, logger, nil)
+
+	callback := "https://example.com/twitch"
+	current := []EventSubSubscription{
+		testSubscription("failed", "stream.online", SubscriptionStatusNotificationFailuresExceeded, "111", callback),
+		testSubscription("healthy", "stream.online", SubscriptionStatusEnabled, "111", callback),
+		testSubscription("old-callback", "stream.online", SubscriptionStatusEnabled, "222", "https://old.example.com/twitch"),
+		testSubscription("other-deployment", "stream.online", SubscriptionStatusEnabled, "222", "https://staging.example.com/twitch"),
+		testSubscription("unlisted-callback", "stream.online", SubscriptionStatusEnabled, "555", "https://moved.example.com/twitch"),
+		testSubscription("orphan", "stream.online", SubscriptionStatusEnabled, "999", callback),
+		testSubscription("previous-callback-orphan", "stream.offline", SubscriptionStatusEnabled, "999", "https://old.example.com/twitch"),
+		testSubscription("other-deployment-orphan", "stream.offline", SubscriptionStatusEnabled, "888", "https://staging.example.com/twitch"),
+		testSubscription("other-session", "stream.online", SubscriptionStatusEnabled, "888", ""),
+		testSubscription("disconnected", "stream.online", SubscriptionStatusWebSocketDisconnected, "888", ""),
+		testSubscription("verification-failed", "stream.online", SubscriptionStatusWebhookCallbackVerificationFailed, "333", callback),
+		testSubscription("pending", "stream.online", SubscriptionStatusWebhookCallbackVerificationPending, "444", callback),
+	}
+
+	current[8].Transport = EventSubTransport{Method: "websocket", SessionID: "other-session"}
+	current[9].Transport = EventSubTransport{Method: "websocket", SessionID: "gone-session"}
+
+	actions := sm.planSync(current, sm.desiredSubscriptions())
+
+	got := make(map[string]string)
+	for _, action := range actions {
+		if action.SubscriptionID != "" {
+			got[action.SubscriptionID] = action.Action + ": " + action.Reason
+		} else {
+			got[action.Type+"/"+action.StreamerKey] = action.Action + ": " + action.Reason
+		}
+	}
+
+	assert.Equal(t, map[string]string{
+		"healthy":                  "keep: ",
+		"pending":                  "keep: ",
+		"failed":                   "delete: duplicate subscription",
+		"old-callback":             "recreate: callback URL changed",
+		"unlisted-callback":        "recreate: callback URL changed",
+		"orphan":                   "delete: not in configuration",
+		"previous-callback-orphan": "delete: not in configuration",
+		"disconnected":             "delete: not in configuration",
+		"verification-failed":      "recreate: status webhook_callback_verification_failed",
+		"stream.offline/streamer4": "create: missing subscription",
+	}, got, "other deployments' subscriptions are only replaced, never deleted")
+
+	// Deletions and recreations happen before new subscriptions are created
+	assert.Equal(t, SyncActionCreate, actions[len(actions)-1].Action)
+}
+
+func TestSyncSubscriptionsReconciles(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Twitch.WebhookSecret = "test_secret"
+	cfg.Twitch.IncomingWebhookURL = "https://example.com/twitch"
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"streamer1": {UserID: "111", Login: "streamer1", Events: []string{"stream.online"}},
+		"streamer2": {UserID: "222", Login: "streamer2", Events: []string{"stream.online"}},
+	}
+
+	helix := &fakeHelix{
+		subs: []EventSubSubscription{
+			testSubscription("keep", "stream.online", SubscriptionStatusEnabled, "111", "https://example.com/twitch"),
+			testSubscription("failed", "stream.online", SubscriptionStatusNotificationFailuresExceeded, "222", "https://example.com/twitch"),
+			testSubscription("orphan", "stream.offline", SubscriptionStatusEnabled, "111", "https://example.com/twitch"),
+		},
+	}
+	sm := newTestSubscriptionManager(t, cfg, helix)
+
+	require.NoError(t, sm.SyncSubscriptions(t.Context()))
+
+	assert.ElementsMatch(t, []string{"failed", "orphan"}, helix.deleted)
+	require.Len(t, helix.created, 1)
+	assert.Equal(t, "stream.online", helix.created[0].Type)
+	assert.Equal(t, "222", helix.created[0].Condition["broadcaster_user_id"])
+	assert.Equal(t, "https://example.com/twitch", helix.created[0].Transport.Callback)
+	assert.Equal(t, "test_secret", helix.created[0].Transport.Secret)
+
+	// A second sync is a no-op
+	require.NoError(t, sm.SyncSubscriptions(t.Context()))
+	assert.Len(t, helix.deleted, 2)
+	assert.Len(t, helix.created, 1)
+}
+
+func TestSyncSubscriptionsReportsFailedActions(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Twitch.IncomingWebhookURL = "https://example.com/twitch"
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"streamer1": {UserID: "111", Login: "streamer1", Events: []string{"stream.online"}},
+		"streamer2": {UserID: "222", Login: "streamer2", Events: []string{"stream.online"}},
+	}
+
+	helix := &fakeHelix{
+		subs: []EventSubSubscription{
+			testSubscription("orphan", "stream.offline", SubscriptionStatusEnabled, "111", "https://example.com/twitch"),
+		},
+		conflict: true,
+	}
+	sm := newTestSubscriptionManager(t, cfg, helix)
+
+	err := sm.SyncSubscriptions(t.Context())
+	var syncErr *SyncFailedError
+	require.ErrorAs(t, err, &syncErr)
+	assert.Equal(t, 2, syncErr.Failed)
+	assert.Equal(t, 3, syncErr.Total)
+	assert.EqualError(t, err, "2 of 3 subscription changes failed")
+	assert.Equal(t, []string{"orphan"}, helix.deleted, "the other actions are still applied")
+}
+
+func TestRecreateSubscription(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Twitch.IncomingWebhookURL = "https://example.com/twitch"
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"streamer1": {UserID: "111", Login: "streamer1", Events: []string{"stream.online"}},
+	}
+
+	revoked := testSubscription("revoked", "stream.online", SubscriptionStatusNotificationFailuresExceeded, "111", "https://example.com/twitch")
+	helix := &fakeHelix{subs: []EventSubSubscription{revoked}}
+	sm := newTestSubscriptionManager(t, cfg, helix)
+
+	recreated, err := sm.RecreateSubscription(t.Context(), revoked)
+	require.NoError(t, err)
+	assert.True(t, recreated)
+	assert.Equal(t, []string{"revoked"}, helix.deleted)
+	require.Len(t, helix.created, 1)
+	assert.Equal(t, "111", helix.created[0].Condition["broadcaster_user_id"])
+
+	// Subscriptions that are no longer configured are not recreated
+	unwanted := testSubscription("unwanted", "stream.offline", SubscriptionStatusNotificationFailuresExceeded, "111", "https://example.com/twitch")
+	recreated, err = sm.RecreateSubscription(t.Context(), unwanted)
+	require.NoError(t, err)
+	assert.False(t, recreated)
+	assert.Len(t, helix.created, 1)
+}
+
+func TestDisabledStreamersAreNotSubscribed(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"streamer1": {UserID: "111", Login: "streamer1", Events: []string{"stream.online"}},
+		"streamer2": {UserID: "222", Login: "streamer2", Events: []string{"stream.online"}, Disabled: true},
+		"streamer3": {UserID: "333", Login: "streamer3", Events: []string{"stream.online"}},
+	}
+	sm := NewSubscriptionManager(cfg, slog.New(slog.NewTextHandler(os.Stdout, nil)), nil)
+
+	sm.DisableStreamer("streamer3", SubscriptionStatusUserRemoved)
+	assert.True(t, sm.IsStreamerDisabled("streamer3"))
+	assert.False(t, sm.IsStreamerDisabled("streamer1"))
+
+	desired := sm.desiredSubscriptions()
+	require.Len(t, desired, 1)
+	assert.Equal(t, "streamer1", desired[0].StreamerKey)
+}
+
+func TestSyncSubscriptionsWebSocketTransport(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Twitch.Transport = config.TransportWebSocket
+	cfg.Twitch.UserAccessToken = "user_token"
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"streamer1": {UserID: "111", Login: "streamer1", Events: []string{"stream.online"}},
+		"streamer2": {UserID: "222", Login: "streamer2", Events: []string{"stream.online"}},
+	}
+
+	stale := testSubscription("stale", "stream.online", SubscriptionStatusEnabled, "111", "")
+	stale.Transport = EventSubTransport{Method: "websocket", SessionID: "old-session"}
+	current := testSubscription("current", "stream.online", SubscriptionStatusEnabled, "222", "")
+	current.Transport = EventSubTransport{Method: "websocket", SessionID: "session-1"}
+
+	failed := testSubscription("failed", "stream.online", SubscriptionStatusNotificationFailuresExceeded, "222", "")
+	failed.Transport = EventSubTransport{Method: "websocket", SessionID: "session-1"}
+	disconnected := testSubscription("disconnected", "stream.online", SubscriptionStatusWebSocketDisconnected, "999", "")
+	disconnected.Transport = EventSubTransport{Method: "websocket", SessionID: "old-session"}
+
+	helix := &fakeHelix{subs: []EventSubSubscription{stale, current, failed, disconnected}}
+	sm := newTestSubscriptionManager(t, cfg, helix)
+
+	// Nothing can be created before a session exists
+	require.Error(t, sm.SyncSubscriptions(t.Context()))
+	assert.Empty(t, helix.created)
+
+	sm.SetWebSocketSession("session-1")
+	require.NoError(t, sm.SyncSubscriptions(t.Context()))
+
+	// A live subscription of another session may belong to another instance and is kept
+	assert.ElementsMatch(t, []string{"failed", "disconnected"}, helix.deleted)
+	require.Len(t, helix.created, 1)
+	assert.Equal(t, "111", helix.created[0].Condition["broadcaster_user_id"])
+	assert.Equal(t, "websocket", helix.created[0].Transport.Method)
+	assert.Equal(t, "session-1", helix.created[0].Transport.SessionID)
+	assert.Empty(t, helix.created[0].Transport.Callback)
+	assert.Equal(t, "Bearer user_token", helix.auth)
+}
+
+func TestGetSubscriptionsPagination(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Twitch.IncomingWebhookURL = "https://example.com/twitch"
+
+	helix := &fakeHelix{pageSize: 100}
+	for i := 0; i < 250; i++ {
+		status := SubscriptionStatusEnabled
+		if i%10 == 0 {
+			status = SubscriptionStatusNotificationFailuresExceeded
+		}
+		helix.subs = append(helix.subs, testSubscription(
+			fmt.Sprintf("sub-%d", i), "stream.online", status, fmt.Sprintf("%d", i), "https://example.com/twitch"))
+	}
+	sm := newTestSubscriptionManager(t, cfg, helix)
+
+	subs, err := sm.GetSubscriptions(t.Context(), SubscriptionFilter{})
+	require.NoError(t, err)
+	assert.Len(t, subs.Data, 250)
+	assert.Equal(t, 250, subs.Total)
+	assert.Equal(t, 3, helix.pages)
+	assert.Equal(t, "sub-249", subs.Data[249].ID)
+	assert.Empty(t, subs.Pagination.Cursor)
+
+	failed, err := sm.GetSubscriptions(t.Context(), SubscriptionFilter{Status: SubscriptionStatusNotificationFailuresExceeded})
+	require.NoError(t, err)
+	assert.Len(t, failed.Data, 25)
+
+	_, err = sm.GetSubscriptions(t.Context(), SubscriptionFilter{Status: SubscriptionStatusEnabled, Type: "stream.online"})
+	require.Error(t, err)
+}
+
+func TestSyncSubscriptionsSeesAllPages(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Twitch.IncomingWebhookURL = "https://example.com/twitch"
+
+	helix := &fakeHelix{pageSize: 2}
+	for i := 0; i < 5; i++ {
+		userID := fmt.Sprintf("%d", 100+i)
+		cfg.Streamers[fmt.Sprintf("streamer%d", i)] = config.StreamerConfig{
+			UserID: userID,
+			Events: []string{"stream.online"},
+		}
+		helix.subs = append(helix.subs, testSubscription(
+			fmt.Sprintf("sub-%d", i), "stream.online", SubscriptionStatusEnabled, userID, "https://example.com/twitch"))
+	}
+	sm := newTestSubscriptionManager(t, cfg, helix)
+
+	require.NoError(t, sm.SyncSubscriptions(t.Context()))
+	assert.Empty(t, helix.created)
+	assert.Empty(t, helix.deleted)
+}
+
+func TestPlanSyncDoesNotModifySubscriptions(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Twitch.IncomingWebhookURL = "https://example.com/twitch"
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"streamer1": {UserID: "111", Login: "streamer1", Events: []string{"stream.online", "stream.offline"}},
+		"streamer2": {UserID: "222", Login: "streamer2", Events: []string{"stream.online"}},
+	}
+
+	helix := &fakeHelix{
+		subs: []EventSubSubscription{
+			testSubscription("keep", "stream.online", SubscriptionStatusEnabled, "111", "https://example.com/twitch"),
+			testSubscription("failed", "stream.online", SubscriptionStatusWebhookCallbackVerificationFailed, "222", "https://example.com/twitch"),
+			testSubscription("orphan-1", "stream.online", SubscriptionStatusEnabled, "333", "https://example.com/twitch"),
+			testSubscription("orphan-2", "stream.offline", SubscriptionStatusEnabled, "333", "https://example.com/twitch"),
+		},
+	}
+	sm := newTestSubscriptionManager(t, cfg, helix)
+
+	plan, err := sm.PlanSync(t.Context())
+	require.NoError(t, err)
+
+	assert.Empty(t, helix.created)
+	assert.Empty(t, helix.deleted)
+
+	assert.Equal(t, map[string]int{
+		SyncActionKeep:     1,
+		SyncActionRecreate: 1,
+		SyncActionDelete:   2,
+		SyncActionCreate:   1,
+	}, plan.Summary)
+	assert.Equal(t, 4, plan.TotalCost)
+	assert.Equal(t, -1, plan.CostDelta)
+	assert.Equal(t, 3, plan.ProjectedTotalCost)
+	assert.False(t, plan.ExceedsMaxCost)
+
+	// The plan is serializable for deploy pipelines
+	data, err := json.Marshal(plan)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"action":"recreate"`)
+	assert.Contains(t, string(data), `"projected_total_cost":3`)
+
+	// Applying the plan performs exactly the planned changes
+	require.NoError(t, sm.ApplySyncPlan(t.Context(), plan))
+	assert.ElementsMatch(t, []string{"failed", "orphan-1", "orphan-2"}, helix.deleted)
+	assert.Len(t, helix.created, 2)
+}
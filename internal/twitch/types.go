@@ -1,6 +1,7 @@
 package twitch
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -25,8 +26,9 @@ type EventSubSubscription struct {
 
 // EventSubTransport represents the transport configuration
 type EventSubTransport struct {
-	Method   string `json:"method"`
-	Callback string `json:"callback"`
+	Method    string `json:"method"`
+	Callback  string `json:"callback,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
 }
 
 // StreamOnlineEvent represents a stream.online event
@@ -39,6 +41,39 @@ type StreamOnlineEvent struct {
 	StartedAt            time.Time `json:"started_at"`
 }
 
+// StreamOfflineEvent represents a stream.offline event
+type StreamOfflineEvent struct {
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+}
+
+// ChannelUpdateEvent represents a channel.update (v2) event
+type ChannelUpdateEvent struct {
+	BroadcasterUserID           string   `json:"broadcaster_user_id"`
+	BroadcasterUserLogin        string   `json:"broadcaster_user_login"`
+	BroadcasterUserName         string   `json:"broadcaster_user_name"`
+	Title                       string   `json:"title"`
+	Language                    string   `json:"language"`
+	CategoryID                  string   `json:"category_id"`
+	CategoryName                string   `json:"category_name"`
+	ContentClassificationLabels []string `json:"content_classification_labels"`
+}
+
+// ChannelChangeEvent represents a channel.update event that changed the title or category
+type ChannelChangeEvent struct {
+	ChannelUpdateEvent
+	Changes              []string `json:"changes"`
+	PreviousTitle        string   `json:"previous_title,omitempty"`
+	PreviousCategoryID   string   `json:"previous_category_id,omitempty"`
+	PreviousCategoryName string   `json:"previous_category_name,omitempty"`
+}
+
+// SubscriptionRevocation represents a revocation notification for a subscription
+type SubscriptionRevocation struct {
+	Subscription EventSubSubscription `json:"subscription"`
+}
+
 // EventSubHeaders represents the headers sent with EventSub notifications
 type EventSubHeaders struct {
 	MessageID           string `json:"message_id"`
@@ -55,6 +90,53 @@ const (
 	MessageTypeWebhookCallbackVerification = "webhook_callback_verification"
 	MessageTypeNotification                = "notification"
 	MessageTypeRevocation                  = "revocation"
+
+	// EventSub WebSocket session messages
+	MessageTypeSessionWelcome   = "session_welcome"
+	MessageTypeSessionKeepalive = "session_keepalive"
+	MessageTypeSessionReconnect = "session_reconnect"
+)
+
+// WebSocketMessage represents a message received on an EventSub WebSocket session
+type WebSocketMessage struct {
+	Metadata WebSocketMetadata `json:"metadata"`
+	Payload  json.RawMessage   `json:"payload"`
+}
+
+// WebSocketMetadata represents the metadata of an EventSub WebSocket message
+type WebSocketMetadata struct {
+	MessageID           string `json:"message_id"`
+	MessageType         string `json:"message_type"`
+	MessageTimestamp    string `json:"message_timestamp"`
+	SubscriptionType    string `json:"subscription_type,omitempty"`
+	SubscriptionVersion string `json:"subscription_version,omitempty"`
+}
+
+// WebSocketSession represents an EventSub WebSocket session
+type WebSocketSession struct {
+	ID                      string    `json:"id"`
+	Status                  string    `json:"status"`
+	ConnectedAt             time.Time `json:"connected_at"`
+	KeepaliveTimeoutSeconds int       `json:"keepalive_timeout_seconds"`
+	ReconnectURL            string    `json:"reconnect_url"`
+}
+
+// WebSocketSessionPayload represents the payload of welcome and reconnect messages
+type WebSocketSessionPayload struct {
+	Session WebSocketSession `json:"session"`
+}
+
+// Subscription type constants
+const (
+	SubscriptionTypeStreamOnline  = "stream.online"
+	SubscriptionTypeStreamOffline = "stream.offline"
+	SubscriptionTypeChannelUpdate = "channel.update"
+)
+
+// Channel change constants
+const (
+	ChannelChangeTitle    = "title_changed"
+	ChannelChangeCategory = "category_changed"
 )
 
 // Subscription status constants
@@ -65,4 +147,6 @@ const (
 	SubscriptionStatusNotificationFailuresExceeded       = "notification_failures_exceeded"
 	SubscriptionStatusAuthorizationRevoked               = "authorization_revoked"
 	SubscriptionStatusUserRemoved                        = "user_removed"
+	SubscriptionStatusVersionRemoved                     = "version_removed"
+	SubscriptionStatusWebSocketDisconnected              = "websocket_disconnected"
 )
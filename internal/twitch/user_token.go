@@ -0,0 +1,206 @@
+package twitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/webhook"
+)
+
+// oauthBaseURL is the base URL of the Twitch OAuth API
+const oauthBaseURL = "https://id.twitch.tv/oauth2"
+
+// User access token lifecycle
+const (
+	userTokenValidateInterval = time.Hour        // Twitch requires apps to validate user tokens hourly
+	userTokenRefreshMargin    = 10 * time.Minute // refresh this long before the token expires
+	userTokenAlertWindow      = 24 * time.Hour   // alert this long before a token that cannot be refreshed expires
+)
+
+// errInvalidUserToken is returned when Twitch rejects the user access token as invalid or expired
+var errInvalidUserToken = errors.New("user access token is invalid or expired")
+
+// userToken is the user access token the WebSocket transport creates subscriptions with
+type userToken struct {
+	accessToken  string
+	refreshToken string
+	expiresAt    time.Time // zero if the token does not expire or has not been validated yet
+	validatedAt  time.Time
+	alerted      bool // the operator was alerted about the token
+
+	// Settings the token was taken from, to pick up new tokens on a config reload
+	configuredAccessToken  string
+	configuredRefreshToken string
+}
+
+// userTokenResponse is the response of the token and validate endpoints
+type userTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// SetAlertHandler registers a callback that sends operator alerts, e.g. when the user access token
+// is about to expire
+func (sm *SubscriptionManager) SetAlertHandler(handler func(webhook.AlertPayload)) {
+	sm.alertHandler = handler
+}
+
+// userAccessToken returns the user access token of the WebSocket transport. The token is validated
+// hourly and refreshed before it expires when a refresh token is configured; otherwise the operator
+// is alerted a day before it expires.
+func (sm *SubscriptionManager) userAccessToken(ctx context.Context) (string, error) {
+	accessToken, alert, err := sm.currentUserToken(ctx, time.Now())
+	if alert != nil {
+		sm.logger.Warn(alert.Message)
+		if sm.alertHandler != nil {
+			sm.alertHandler(*alert)
+		}
+	}
+	return accessToken, err
+}
+
+// currentUserToken validates and refreshes the user access token as needed. It returns the alert
+// to send, if any, so that it is sent without holding the lock.
+func (sm *SubscriptionManager) currentUserToken(ctx context.Context, now time.Time) (string, *webhook.AlertPayload, error) {
+	sm.userTokenMutex.Lock()
+	defer sm.userTokenMutex.Unlock()
+
+	token := &sm.userToken
+	twitchConfig := sm.config.Twitch
+	if token.configuredAccessToken != twitchConfig.UserAccessToken || token.configuredRefreshToken != twitchConfig.UserRefreshToken {
+		*token = userToken{
+			accessToken:            twitchConfig.UserAccessToken,
+			refreshToken:           twitchConfig.UserRefreshToken,
+			configuredAccessToken:  twitchConfig.UserAccessToken,
+			configuredRefreshToken: twitchConfig.UserRefreshToken,
+		}
+	}
+
+	if token.accessToken != "" && now.Sub(token.validatedAt) >= userTokenValidateInterval {
+		validated, err := sm.requestUserToken(ctx, http.MethodGet, "/validate", nil, "OAuth "+token.accessToken)
+		switch {
+		case err == nil:
+			token.validatedAt = now
+			token.expiresAt = expiryOf(validated, now)
+		case errors.Is(err, errInvalidUserToken):
+			token.accessToken = ""
+		default:
+			// Keep using the token; Helix rejects it if it is no longer valid
+			sm.logger.Warn("Failed to validate user access token", "error", err)
+		}
+	}
+
+	expiring := token.accessToken == "" || (!token.expiresAt.IsZero() && now.Add(userTokenRefreshMargin).After(token.expiresAt))
+	if expiring && token.refreshToken != "" {
+		form := url.Values{}
+		form.Set("client_id", twitchConfig.ClientID)
+		form.Set("client_secret", twitchConfig.ClientSecret)
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", token.refreshToken)
+
+		refreshed, err := sm.requestUserToken(ctx, http.MethodPost, "/token", form, "")
+		if err != nil {
+			alert := sm.userTokenAlert("user_token_refresh_failed", "critical",
+				"Failed to refresh the Twitch user access token; WebSocket subscriptions cannot be created once it expires", err)
+			if token.accessToken == "" {
+				return "", alert, fmt.Errorf("failed to refresh user access token: %w", err)
+			}
+			return token.accessToken, alert, nil
+		}
+
+		token.accessToken = refreshed.AccessToken
+		if refreshed.RefreshToken != "" {
+			token.refreshToken = refreshed.RefreshToken
+		}
+		token.validatedAt = now
+		token.expiresAt = expiryOf(refreshed, now)
+		token.alerted = false
+		sm.logger.Info("Refreshed Twitch user access token", "expires_at", token.expiresAt)
+	}
+
+	if token.accessToken == "" {
+		alert := sm.userTokenAlert("user_token_expired", "critical",
+			"The Twitch user access token is invalid or expired; configure a new user_access_token or a user_refresh_token", nil)
+		return "", alert, errInvalidUserToken
+	}
+
+	if token.refreshToken == "" && !token.alerted && !token.expiresAt.IsZero() && now.Add(userTokenAlertWindow).After(token.expiresAt) {
+		token.alerted = true
+		return token.accessToken, sm.userTokenAlert("user_token_expiring", "warning",
+			fmt.Sprintf("The Twitch user access token expires at %s; configure a new user_access_token or a user_refresh_token", token.expiresAt.Format(time.RFC3339)), nil), nil
+	}
+
+	return token.accessToken, nil, nil
+}
+
+// userTokenAlert creates an operator alert about the user access token
+func (sm *SubscriptionManager) userTokenAlert(alert, severity, message string, err error) *webhook.AlertPayload {
+	payload := &webhook.AlertPayload{
+		Alert:     alert,
+		Severity:  severity,
+		Message:   message,
+		Timestamp: time.Now().UTC(),
+	}
+	if err != nil {
+		payload.Error = err.Error()
+	}
+	return payload
+}
+
+// expiryOf returns when a token expires, or zero if it does not
+func expiryOf(token *userTokenResponse, now time.Time) time.Time {
+	if token.ExpiresIn <= 0 {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(token.ExpiresIn) * time.Second)
+}
+
+// requestUserToken calls a Twitch OAuth endpoint. It returns errInvalidUserToken when the token is
+// rejected.
+func (sm *SubscriptionManager) requestUserToken(ctx context.Context, method, path string, form url.Values, authorization string) (*userTokenResponse, error) {
+	var body io.Reader
+	if form != nil {
+		body = bytes.NewBufferString(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, sm.oauthBaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := sm.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusBadRequest && form != nil:
+		return nil, errInvalidUserToken
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("token request failed with status %d", resp.StatusCode)
+	}
+
+	var token userTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if form != nil && token.AccessToken == "" {
+		return nil, fmt.Errorf("token response contains no access token")
+	}
+	return &token, nil
+}
@@ -0,0 +1,162 @@
+package twitch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/rmoriz/itsjustintv/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOAuth is a minimal stand-in for the Twitch OAuth validate and token endpoints
+type fakeOAuth struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	valid       map[string]int // expires_in by valid access token
+	validations int
+	refreshes   []string // refresh tokens used
+}
+
+func newFakeOAuth(t *testing.T, valid map[string]int) *fakeOAuth {
+	f := &fakeOAuth{valid: valid}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/validate":
+			f.validations++
+			expiresIn, ok := f.valid[strings.TrimPrefix(r.Header.Get("Authorization"), "OAuth ")]
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"client_id": "client", "expires_in": expiresIn})
+		case "/token":
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+			assert.Equal(t, "test_client_secret", r.PostForm.Get("client_secret"))
+			refreshToken := r.PostForm.Get("refresh_token")
+			f.refreshes = append(f.refreshes, refreshToken)
+			if refreshToken == "revoked" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			f.valid["refreshed_token"] = 14400
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "refreshed_token", "refresh_token": "rotated_refresh", "expires_in": 14400})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newUserTokenTestManager(t *testing.T, accessToken, refreshToken string, oauth *fakeOAuth) (*SubscriptionManager, *[]webhook.AlertPayload) {
+	cfg := config.DefaultConfig()
+	cfg.Twitch.Transport = config.TransportWebSocket
+	cfg.Twitch.ClientSecret = "test_client_secret"
+	cfg.Twitch.UserAccessToken = accessToken
+	cfg.Twitch.UserRefreshToken = refreshToken
+
+	sm := newTestSubscriptionManager(t, cfg, &fakeHelix{})
+	sm.oauthBaseURL = oauth.URL
+	alerts := &[]webhook.AlertPayload{}
+	sm.SetAlertHandler(func(alert webhook.AlertPayload) { *alerts = append(*alerts, alert) })
+	return sm, alerts
+}
+
+func TestUserTokenIsValidatedHourly(t *testing.T) {
+	oauth := newFakeOAuth(t, map[string]int{"user_token": 14400})
+	sm, alerts := newUserTokenTestManager(t, "user_token", "", oauth)
+	now := time.Now()
+
+	token, _, err := sm.currentUserToken(t.Context(), now)
+	require.NoError(t, err)
+	assert.Equal(t, "user_token", token)
+	_, _, err = sm.currentUserToken(t.Context(), now.Add(30*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, oauth.validations)
+
+	_, _, err = sm.currentUserToken(t.Context(), now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, oauth.validations)
+	assert.Empty(t, *alerts)
+}
+
+func TestUserTokenIsRefreshedBeforeItExpires(t *testing.T) {
+	oauth := newFakeOAuth(t, map[string]int{"user_token": 300})
+	sm, alerts := newUserTokenTestManager(t, "user_token", "refresh", oauth)
+
+	token, err := sm.userAccessToken(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "refreshed_token", token)
+	assert.Equal(t, []string{"refresh"}, oauth.refreshes)
+
+	// The rotated refresh token is used when the new token is about to expire
+	oauth.valid["refreshed_token"] = 300
+	_, _, err = sm.currentUserToken(t.Context(), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"refresh", "rotated_refresh"}, oauth.refreshes)
+	assert.Empty(t, *alerts)
+}
+
+func TestUserTokenFromRefreshTokenOnly(t *testing.T) {
+	oauth := newFakeOAuth(t, map[string]int{})
+	sm, _ := newUserTokenTestManager(t, "", "refresh", oauth)
+
+	token, err := sm.userAccessToken(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "refreshed_token", token)
+}
+
+func TestUserTokenAlerts(t *testing.T) {
+	// A token that cannot be refreshed is reported once, a day before it expires
+	oauth := newFakeOAuth(t, map[string]int{"user_token": 3600})
+	sm, alerts := newUserTokenTestManager(t, "user_token", "", oauth)
+	_, err := sm.userAccessToken(t.Context())
+	require.NoError(t, err)
+	_, err = sm.userAccessToken(t.Context())
+	require.NoError(t, err)
+	require.Len(t, *alerts, 1)
+	assert.Equal(t, "user_token_expiring", (*alerts)[0].Alert)
+
+	// An invalid token fails startup
+	oauth = newFakeOAuth(t, map[string]int{})
+	sm, alerts = newUserTokenTestManager(t, "user_token", "", oauth)
+	require.Error(t, sm.Start(t.Context()))
+	require.Len(t, *alerts, 1)
+	assert.Equal(t, "user_token_expired", (*alerts)[0].Alert)
+
+	// A failed refresh is critical
+	oauth = newFakeOAuth(t, map[string]int{"user_token": 300})
+	sm, alerts = newUserTokenTestManager(t, "user_token", "revoked", oauth)
+	token, err := sm.userAccessToken(t.Context())
+	require.NoError(t, err, "the token is still valid for a few minutes")
+	assert.Equal(t, "user_token", token)
+	require.Len(t, *alerts, 1)
+	assert.Equal(t, "user_token_refresh_failed", (*alerts)[0].Alert)
+	assert.Equal(t, "critical", (*alerts)[0].Severity)
+}
+
+func TestUserTokenFollowsConfigReload(t *testing.T) {
+	oauth := newFakeOAuth(t, map[string]int{"user_token": 14400, "new_token": 14400})
+	sm, _ := newUserTokenTestManager(t, "user_token", "", oauth)
+	_, err := sm.userAccessToken(t.Context())
+	require.NoError(t, err)
+
+	newConfig := *sm.config
+	newConfig.Twitch.UserAccessToken = "new_token"
+	require.NoError(t, sm.UpdateConfig(&newConfig))
+	token, err := sm.userAccessToken(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "new_token", token)
+}
@@ -0,0 +1,113 @@
+package twitch
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignEventSubMessage(t *testing.T) {
+	body := []byte(`{"subscription":{"id":"sub_123"}}`)
+
+	// Twitch signs the concatenation of message ID, timestamp and body
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("msg_123" + "2025-01-15T12:00:00.123456789Z" + string(body)))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	assert.Equal(t, expected, SignEventSubMessage("secret", "msg_123", "2025-01-15T12:00:00.123456789Z", body))
+}
+
+func TestEventSubVerifier(t *testing.T) {
+	body := []byte(`{"subscription":{"id":"sub_123"}}`)
+	headers := EventSubHeaders{
+		MessageID:        "msg_123",
+		MessageTimestamp: "2025-01-15T12:00:00.123456789Z",
+	}
+	sign := func(secret string) EventSubHeaders {
+		signed := headers
+		signed.MessageSignature = SignEventSubMessage(secret, headers.MessageID, headers.MessageTimestamp, body)
+		return signed
+	}
+
+	verifier := NewEventSubVerifier("current", []string{"", "previous"}, time.Time{})
+
+	tests := []struct {
+		name          string
+		headers       EventSubHeaders
+		body          []byte
+		errorContains string
+	}{
+		{name: "current secret", headers: sign("current"), body: body},
+		{name: "previous secret during rotation", headers: sign("previous"), body: body},
+		{name: "unknown secret", headers: sign("other"), body: body, errorContains: "invalid signature"},
+		{name: "tampered body", headers: sign("current"), body: []byte(`{}`), errorContains: "invalid signature"},
+		{
+			name: "tampered message ID",
+			headers: func() EventSubHeaders {
+				h := sign("current")
+				h.MessageID = "msg_456"
+				return h
+			}(),
+			body:          body,
+			errorContains: "invalid signature",
+		},
+		{
+			name: "missing prefix",
+			headers: func() EventSubHeaders {
+				h := sign("current")
+				h.MessageSignature = strings.TrimPrefix(h.MessageSignature, "sha256=")
+				return h
+			}(),
+			body:          body,
+			errorContains: "signature must start with",
+		},
+		{
+			name: "missing timestamp",
+			headers: func() EventSubHeaders {
+				h := sign("current")
+				h.MessageTimestamp = ""
+				return h
+			}(),
+			body:          body,
+			errorContains: "missing message ID or timestamp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifier.Verify(tt.headers, tt.body)
+			if tt.errorContains == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+			}
+		})
+	}
+}
+
+func TestEventSubVerifierWithoutSecret(t *testing.T) {
+	verifier := NewEventSubVerifier("", nil, time.Time{})
+
+	err := verifier.Verify(EventSubHeaders{MessageID: "msg", MessageTimestamp: "ts", MessageSignature: "sha256=00"}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "webhook secret not configured")
+}
+
+func TestEventSubVerifierExpiresPreviousSecrets(t *testing.T) {
+	headers := EventSubHeaders{MessageID: "msg_123", MessageTimestamp: "2025-01-15T12:00:00Z"}
+	headers.MessageSignature = SignEventSubMessage("previous", headers.MessageID, headers.MessageTimestamp, nil)
+
+	rotating := NewEventSubVerifier("current", []string{"previous"}, time.Now().Add(time.Hour))
+	assert.NoError(t, rotating.Verify(headers, nil))
+
+	rotated := NewEventSubVerifier("current", []string{"previous"}, time.Now().Add(-time.Second))
+	assert.Error(t, rotated.Verify(headers, nil))
+	assert.Equal(t, []string{"current"}, rotated.secrets(time.Now()))
+}
@@ -0,0 +1,91 @@
+package twitch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rmoriz/itsjustintv/internal/atomicfile"
+	"github.com/rmoriz/itsjustintv/internal/config"
+)
+
+// webhookSecretState is the persisted fingerprint of the secret the webhook subscriptions were
+// created with. Only a hash is stored, never the secret itself.
+type webhookSecretState struct {
+	Fingerprint string `json:"webhook_secret_sha256"`
+}
+
+// WebhookSecretStateFile returns the path of the webhook secret state, next to the token file
+func WebhookSecretStateFile(cfg *config.Config) string {
+	return filepath.Join(filepath.Dir(cfg.Twitch.TokenFile), "webhook_secret.json")
+}
+
+// LoadWebhookSecretFingerprint loads the fingerprint of the webhook secret the webhook subscriptions
+// were created with from path. When webhook_secret changes, the next sync recreates them with the
+// new secret, since Twitch keeps signing with the secret a subscription was created with. Without a
+// stored fingerprint the subscriptions are assumed to use the current secret.
+func (sm *SubscriptionManager) LoadWebhookSecretFingerprint(path string) error {
+	sm.secretMutex.Lock()
+	defer sm.secretMutex.Unlock()
+
+	sm.secretFile = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read webhook secret state: %w", err)
+	}
+
+	var state webhookSecretState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse webhook secret state: %w", err)
+	}
+	sm.secretFingerprint = state.Fingerprint
+	return nil
+}
+
+// webhookSecretChanged reports whether webhook_secret differs from the secret the webhook
+// subscriptions were created with
+func (sm *SubscriptionManager) webhookSecretChanged() bool {
+	sm.secretMutex.Lock()
+	defer sm.secretMutex.Unlock()
+
+	return sm.secretFingerprint != "" && sm.secretFingerprint != webhookSecretFingerprint(sm.config.Twitch.WebhookSecret)
+}
+
+// saveWebhookSecretFingerprint records that the webhook subscriptions use the current secret
+func (sm *SubscriptionManager) saveWebhookSecretFingerprint() {
+	if sm.config.Twitch.Transport == config.TransportWebSocket {
+		return
+	}
+
+	sm.secretMutex.Lock()
+	defer sm.secretMutex.Unlock()
+
+	fingerprint := webhookSecretFingerprint(sm.config.Twitch.WebhookSecret)
+	if sm.secretFile == "" || fingerprint == sm.secretFingerprint {
+		sm.secretFingerprint = fingerprint
+		return
+	}
+
+	data, err := json.MarshalIndent(webhookSecretState{Fingerprint: fingerprint}, "", "  ")
+	if err == nil {
+		err = atomicfile.WriteFile(sm.secretFile, data)
+	}
+	if err != nil {
+		sm.logger.Warn("Failed to save webhook secret state", "path", sm.secretFile, "error", err)
+		return
+	}
+	sm.secretFingerprint = fingerprint
+}
+
+// webhookSecretFingerprint returns the fingerprint of a webhook secret
+func webhookSecretFingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,62 @@
+package twitch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncRecreatesSubscriptionsAfterSecretRotation(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Twitch.WebhookSecret = "old_secret"
+	cfg.Twitch.IncomingWebhookURL = "https://example.com/twitch"
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"streamer1": {UserID: "111", Login: "streamer1", Events: []string{"stream.online"}},
+	}
+	stateFile := filepath.Join(t.TempDir(), "webhook_secret.json")
+
+	helix := &fakeHelix{
+		subs: []EventSubSubscription{
+			testSubscription("existing", "stream.online", SubscriptionStatusEnabled, "111", "https://example.com/twitch"),
+		},
+	}
+	sm := newTestSubscriptionManager(t, cfg, helix)
+	require.NoError(t, sm.LoadWebhookSecretFingerprint(stateFile))
+
+	// Without a stored fingerprint the subscriptions are assumed to use the current secret
+	require.NoError(t, sm.SyncSubscriptions(t.Context()))
+	assert.Empty(t, helix.deleted)
+	assert.Empty(t, helix.created)
+	data, err := os.ReadFile(stateFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "old_secret")
+
+	// A rotated secret recreates the subscriptions with it, once
+	rotated := *cfg
+	rotated.Twitch.WebhookSecret = "new_secret"
+	rotated.Twitch.PreviousWebhookSecrets = []string{"old_secret"}
+	require.NoError(t, sm.UpdateConfig(&rotated))
+
+	plan, err := sm.PlanSync(t.Context())
+	require.NoError(t, err)
+	require.Len(t, plan.Actions, 1)
+	assert.Equal(t, SyncActionRecreate, plan.Actions[0].Action)
+	assert.Equal(t, "webhook secret changed", plan.Actions[0].Reason)
+
+	require.NoError(t, sm.ApplySyncPlan(t.Context(), plan))
+	assert.Equal(t, []string{"existing"}, helix.deleted)
+	require.Len(t, helix.created, 1)
+	assert.Equal(t, "new_secret", helix.created[0].Transport.Secret)
+
+	require.NoError(t, sm.SyncSubscriptions(t.Context()))
+	assert.Len(t, helix.created, 1)
+
+	// The rotation is remembered across restarts
+	restarted := newTestSubscriptionManager(t, &rotated, helix)
+	require.NoError(t, restarted.LoadWebhookSecretFingerprint(stateFile))
+	assert.False(t, restarted.webhookSecretChanged())
+}
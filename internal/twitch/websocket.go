@@ -0,0 +1,269 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"golang.org/x/net/websocket"
+)
+
+// EventHandler handles an event the processor marked for processing
+type EventHandler func(event *ProcessedEvent, messageID string) error
+
+// SessionHandler is called when a new EventSub WebSocket session is established
+type SessionHandler func(ctx context.Context, sessionID string) error
+
+// WebSocketClient receives EventSub notifications over a WebSocket session instead of
+// the public webhook callback
+type WebSocketClient struct {
+	config    *config.Config
+	logger    *slog.Logger
+	processor *Processor
+	onEvent   EventHandler
+	onSession SessionHandler
+
+	welcomeTimeout    time.Duration
+	keepaliveGrace    time.Duration
+	reconnectDelay    time.Duration
+	maxReconnectDelay time.Duration
+
+	conn      *websocket.Conn
+	connMutex sync.Mutex
+}
+
+// NewWebSocketClient creates a new EventSub WebSocket client
+func NewWebSocketClient(cfg *config.Config, logger *slog.Logger, processor *Processor) *WebSocketClient {
+	return &WebSocketClient{
+		config:            cfg,
+		logger:            logger,
+		processor:         processor,
+		welcomeTimeout:    10 * time.Second,
+		keepaliveGrace:    5 * time.Second,
+		reconnectDelay:    time.Second,
+		maxReconnectDelay: time.Minute,
+	}
+}
+
+// SetEventHandler registers the handler for processed notifications
+func (c *WebSocketClient) SetEventHandler(handler EventHandler) {
+	c.onEvent = handler
+}
+
+// SetSessionHandler registers the handler called with every new session ID
+func (c *WebSocketClient) SetSessionHandler(handler SessionHandler) {
+	c.onSession = handler
+}
+
+// Run connects to EventSub and keeps a session alive until the context is cancelled
+func (c *WebSocketClient) Run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, c.closeConn)
+	defer stop()
+
+	delay := c.reconnectDelay
+	for {
+		established, err := c.runSession(ctx, c.config.Twitch.WebSocketURL)
+		c.closeConn()
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if established {
+			delay = c.reconnectDelay
+		}
+		c.logger.Warn("EventSub WebSocket session ended, reconnecting",
+			"error", err,
+			"delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > c.maxReconnectDelay {
+			delay = c.maxReconnectDelay
+		}
+	}
+}
+
+// runSession runs a single session and reports whether it was established
+func (c *WebSocketClient) runSession(ctx context.Context, url string) (bool, error) {
+	conn, session, err := c.connect(ctx, url)
+	if err != nil {
+		return false, err
+	}
+
+	if c.onSession != nil {
+		if err := c.onSession(ctx, session.ID); err != nil {
+			return false, fmt.Errorf("failed to handle new session: %w", err)
+		}
+	}
+
+	keepalive := c.keepaliveTimeout(session)
+	for {
+		message, err := c.receive(conn, keepalive)
+		if err != nil {
+			return true, fmt.Errorf("failed to read message: %w", err)
+		}
+
+		switch message.Metadata.MessageType {
+		case MessageTypeSessionKeepalive:
+			c.logger.Debug("EventSub WebSocket keepalive received")
+
+		case MessageTypeNotification, MessageTypeRevocation:
+			c.handleMessage(message)
+
+		case MessageTypeSessionReconnect:
+			var payload WebSocketSessionPayload
+			if err := json.Unmarshal(message.Payload, &payload); err != nil {
+				return true, fmt.Errorf("failed to unmarshal reconnect message: %w", err)
+			}
+
+			c.logger.Info("EventSub WebSocket reconnect requested",
+				"session_id", payload.Session.ID,
+				"reconnect_url", payload.Session.ReconnectURL)
+
+			// Subscriptions move to the new connection; the old one is closed once it is welcomed
+			newConn, newSession, err := c.connect(ctx, payload.Session.ReconnectURL)
+			if err != nil {
+				return true, fmt.Errorf("failed to reconnect: %w", err)
+			}
+			conn = newConn
+			keepalive = c.keepaliveTimeout(newSession)
+
+		default:
+			c.logger.Debug("Ignoring unknown EventSub WebSocket message",
+				"message_type", message.Metadata.MessageType)
+		}
+	}
+}
+
+// connect dials an EventSub WebSocket URL and waits for the welcome message
+func (c *WebSocketClient) connect(ctx context.Context, url string) (*websocket.Conn, WebSocketSession, error) {
+	wsConfig, err := websocket.NewConfig(url, "http://localhost/")
+	if err != nil {
+		return nil, WebSocketSession{}, fmt.Errorf("failed to create WebSocket config: %w", err)
+	}
+
+	conn, err := wsConfig.DialContext(ctx)
+	if err != nil {
+		return nil, WebSocketSession{}, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	message, err := c.receive(conn, c.welcomeTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, WebSocketSession{}, fmt.Errorf("failed to read welcome message: %w", err)
+	}
+	if message.Metadata.MessageType != MessageTypeSessionWelcome {
+		conn.Close()
+		return nil, WebSocketSession{}, fmt.Errorf("expected %s message, got %s", MessageTypeSessionWelcome, message.Metadata.MessageType)
+	}
+
+	var payload WebSocketSessionPayload
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		conn.Close()
+		return nil, WebSocketSession{}, fmt.Errorf("failed to unmarshal welcome message: %w", err)
+	}
+
+	c.setConn(conn)
+
+	c.logger.Info("EventSub WebSocket session established",
+		"session_id", payload.Session.ID,
+		"keepalive_timeout_seconds", payload.Session.KeepaliveTimeoutSeconds)
+
+	return conn, payload.Session, nil
+}
+
+// receive reads the next message, failing if none arrives within the timeout
+func (c *WebSocketClient) receive(conn *websocket.Conn, timeout time.Duration) (*WebSocketMessage, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+
+	var data []byte
+	if err := websocket.Message.Receive(conn, &data); err != nil {
+		return nil, err
+	}
+
+	var message WebSocketMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	return &message, nil
+}
+
+// handleMessage feeds a notification or revocation into the processor
+func (c *WebSocketClient) handleMessage(message *WebSocketMessage) {
+	headers := EventSubHeaders{
+		MessageID:           message.Metadata.MessageID,
+		MessageType:         message.Metadata.MessageType,
+		MessageTimestamp:    message.Metadata.MessageTimestamp,
+		SubscriptionType:    message.Metadata.SubscriptionType,
+		SubscriptionVersion: message.Metadata.SubscriptionVersion,
+	}
+
+	processedEvent, err := c.processor.ProcessNotification(headers, message.Payload)
+	if err != nil {
+		c.logger.Error("Failed to process EventSub WebSocket message",
+			"error", err,
+			"message_id", headers.MessageID)
+		return
+	}
+
+	switch processedEvent.Action {
+	case "process":
+		if c.onEvent == nil {
+			return
+		}
+		if err := c.onEvent(processedEvent, headers.MessageID); err != nil {
+			c.logger.Error("Failed to process stream event",
+				"error", err,
+				"message_id", headers.MessageID)
+		}
+
+	case "revoke":
+		// There is no response to reject the event with; the next sync deletes the subscription
+		c.logger.Info("Unwanted subscription, ignoring event",
+			"message_id", headers.MessageID,
+			"event_type", processedEvent.Type)
+
+	default:
+		c.logger.Debug("Event ignored",
+			"message_id", headers.MessageID,
+			"event_type", processedEvent.Type)
+	}
+}
+
+// keepaliveTimeout returns how long to wait for a message before treating the session as dead
+func (c *WebSocketClient) keepaliveTimeout(session WebSocketSession) time.Duration {
+	return time.Duration(session.KeepaliveTimeoutSeconds)*time.Second + c.keepaliveGrace
+}
+
+// setConn makes conn the current connection and closes the previous one
+func (c *WebSocketClient) setConn(conn *websocket.Conn) {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = conn
+}
+
+// closeConn closes the current connection
+func (c *WebSocketClient) closeConn() {
+	c.setConn(nil)
+}
+
+// UpdateConfig updates the WebSocket client configuration
+func (c *WebSocketClient) UpdateConfig(newConfig *config.Config) {
+	c.config = newConfig
+}
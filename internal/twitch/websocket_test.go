@@ -0,0 +1,252 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+// fakeEventSub is a minimal EventSub WebSocket server driven by a per-connection script
+type fakeEventSub struct {
+	server *httptest.Server
+	script func(conn *websocket.Conn, connection int)
+
+	mu          sync.Mutex
+	connections int
+}
+
+func newFakeEventSub(t *testing.T, script func(conn *websocket.Conn, connection int)) *fakeEventSub {
+	f := &fakeEventSub{script: script}
+	f.server = httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		f.mu.Lock()
+		f.connections++
+		connection := f.connections
+		f.mu.Unlock()
+
+		f.script(conn, connection)
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeEventSub) url() string {
+	return "ws" + strings.TrimPrefix(f.server.URL, "http")
+}
+
+func (f *fakeEventSub) connectionCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connections
+}
+
+func sendWebSocketMessage(t *testing.T, conn *websocket.Conn, messageType string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	assert.NoError(t, err)
+
+	message := WebSocketMessage{
+		Metadata: WebSocketMetadata{
+			MessageID:        fmt.Sprintf("%s-%d", messageType, time.Now().UnixNano()),
+			MessageType:      messageType,
+			MessageTimestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		},
+		Payload: data,
+	}
+	if messageType == MessageTypeNotification {
+		message.Metadata.SubscriptionType = SubscriptionTypeStreamOnline
+		message.Metadata.SubscriptionVersion = "1"
+	}
+
+	assert.NoError(t, websocket.JSON.Send(conn, message))
+}
+
+func sendWelcome(t *testing.T, conn *websocket.Conn, sessionID string, keepaliveSeconds int) {
+	sendWebSocketMessage(t, conn, MessageTypeSessionWelcome, WebSocketSessionPayload{
+		Session: WebSocketSession{
+			ID:                      sessionID,
+			Status:                  "connected",
+			ConnectedAt:             time.Now().UTC(),
+			KeepaliveTimeoutSeconds: keepaliveSeconds,
+		},
+	})
+}
+
+func sendStreamOnline(t *testing.T, conn *websocket.Conn, streamID string) {
+	sendWebSocketMessage(t, conn, MessageTypeNotification, map[string]interface{}{
+		"subscription": EventSubSubscription{
+			ID:      "sub_123",
+			Type:    SubscriptionTypeStreamOnline,
+			Version: "1",
+			Status:  "enabled",
+		},
+		"event": StreamOnlineEvent{
+			ID:                   streamID,
+			BroadcasterUserID:    "123456789",
+			BroadcasterUserLogin: "teststreamer",
+			BroadcasterUserName:  "TestStreamer",
+			Type:                 "live",
+			StartedAt:            time.Now().UTC(),
+		},
+	})
+}
+
+// waitForClose blocks until the client closes the connection
+func waitForClose(conn *websocket.Conn) {
+	var discard []byte
+	for websocket.Message.Receive(conn, &discard) == nil {
+	}
+}
+
+func newTestWebSocketClient(t *testing.T, url string) (*WebSocketClient, chan *ProcessedEvent, chan string) {
+	cfg := config.DefaultConfig()
+	cfg.Twitch.Transport = config.TransportWebSocket
+	cfg.Twitch.WebSocketURL = url
+	cfg.Streamers = map[string]config.StreamerConfig{
+		"test_streamer": {UserID: "123456789", Login: "teststreamer"},
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	client := NewWebSocketClient(cfg, logger, NewProcessor(cfg, logger))
+	client.reconnectDelay = 10 * time.Millisecond
+
+	events := make(chan *ProcessedEvent, 10)
+	sessions := make(chan string, 10)
+	client.SetEventHandler(func(event *ProcessedEvent, messageID string) error {
+		events <- event
+		return nil
+	})
+	client.SetSessionHandler(func(ctx context.Context, sessionID string) error {
+		sessions <- sessionID
+		return nil
+	})
+
+	return client, events, sessions
+}
+
+func runWebSocketClient(t *testing.T, client *WebSocketClient) {
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Error("WebSocket client did not stop")
+		}
+	})
+}
+
+func receiveWithin[T any](t *testing.T, ch chan T) T {
+	t.Helper()
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for value")
+	}
+	var zero T
+	return zero
+}
+
+func TestWebSocketClientProcessesNotifications(t *testing.T) {
+	eventSub := newFakeEventSub(t, func(conn *websocket.Conn, connection int) {
+		sendWelcome(t, conn, "session-1", 10)
+		sendWebSocketMessage(t, conn, MessageTypeSessionKeepalive, struct{}{})
+		sendStreamOnline(t, conn, "stream_1")
+		waitForClose(conn)
+	})
+
+	client, events, sessions := newTestWebSocketClient(t, eventSub.url())
+	runWebSocketClient(t, client)
+
+	assert.Equal(t, "session-1", receiveWithin(t, sessions))
+
+	event := receiveWithin(t, events)
+	assert.Equal(t, SubscriptionTypeStreamOnline, event.Type)
+	streamEvent, ok := event.Event.(StreamOnlineEvent)
+	require.True(t, ok)
+	assert.Equal(t, "stream_1", streamEvent.ID)
+	assert.Equal(t, "teststreamer", streamEvent.BroadcasterUserLogin)
+}
+
+func TestWebSocketClientReconnect(t *testing.T) {
+	oldClosed := make(chan struct{})
+
+	target := newFakeEventSub(t, func(conn *websocket.Conn, connection int) {
+		sendWelcome(t, conn, "session-1", 10)
+		sendStreamOnline(t, conn, "stream_after_reconnect")
+		waitForClose(conn)
+	})
+
+	origin := newFakeEventSub(t, func(conn *websocket.Conn, connection int) {
+		sendWelcome(t, conn, "session-1", 10)
+		sendWebSocketMessage(t, conn, MessageTypeSessionReconnect, WebSocketSessionPayload{
+			Session: WebSocketSession{
+				ID:           "session-1",
+				Status:       "reconnecting",
+				ReconnectURL: target.url(),
+			},
+		})
+		waitForClose(conn)
+		close(oldClosed)
+	})
+
+	client, events, sessions := newTestWebSocketClient(t, origin.url())
+	runWebSocketClient(t, client)
+
+	assert.Equal(t, "session-1", receiveWithin(t, sessions))
+
+	event := receiveWithin(t, events)
+	streamEvent, ok := event.Event.(StreamOnlineEvent)
+	require.True(t, ok)
+	assert.Equal(t, "stream_after_reconnect", streamEvent.ID)
+
+	// The old connection is closed and subscriptions are not re-created
+	receiveWithin(t, oldClosed)
+	assert.Empty(t, sessions)
+	assert.Equal(t, 1, origin.connectionCount())
+}
+
+func TestWebSocketClientReconnectsAfterMissedKeepalive(t *testing.T) {
+	eventSub := newFakeEventSub(t, func(conn *websocket.Conn, connection int) {
+		sendWelcome(t, conn, fmt.Sprintf("session-%d", connection), 1)
+		// Stay silent so the client's keepalive timer expires
+		waitForClose(conn)
+	})
+
+	client, _, sessions := newTestWebSocketClient(t, eventSub.url())
+	client.keepaliveGrace = 0
+	runWebSocketClient(t, client)
+
+	assert.Equal(t, "session-1", receiveWithin(t, sessions))
+	assert.Equal(t, "session-2", receiveWithin(t, sessions))
+}
+
+func TestWebSocketClientRejectsMissingWelcome(t *testing.T) {
+	eventSub := newFakeEventSub(t, func(conn *websocket.Conn, connection int) {
+		sendWebSocketMessage(t, conn, MessageTypeSessionKeepalive, struct{}{})
+		waitForClose(conn)
+	})
+
+	client, _, _ := newTestWebSocketClient(t, eventSub.url())
+
+	_, err := client.runSession(t.Context(), eventSub.url())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected session_welcome")
+}
@@ -0,0 +1,357 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// blueskyPostCollection is the collection of Bluesky posts in a repository
+const blueskyPostCollection = "app.bsky.feed.post"
+
+// BlueskyPost is an app.bsky.feed.post record
+type BlueskyPost struct {
+	Type      string         `json:"$type"`
+	Text      string         `json:"text"`
+	CreatedAt string         `json:"createdAt"`
+	Facets    []BlueskyFacet `json:"facets,omitempty"`
+	Embed     *BlueskyEmbed  `json:"embed,omitempty"`
+	Langs     []string       `json:"langs,omitempty"`
+}
+
+// BlueskyFacet annotates a byte range of a post's text, e.g. as a link
+type BlueskyFacet struct {
+	Index    BlueskyByteSlice      `json:"index"`
+	Features []BlueskyFacetFeature `json:"features"`
+}
+
+// BlueskyByteSlice is a range of UTF-8 bytes of a post's text
+type BlueskyByteSlice struct {
+	ByteStart int `json:"byteStart"`
+	ByteEnd   int `json:"byteEnd"`
+}
+
+// BlueskyFacetFeature is the meaning of a facet
+type BlueskyFacetFeature struct {
+	Type string `json:"$type"`
+	URI  string `json:"uri"`
+}
+
+// BlueskyEmbed is an external link card embedded in a post
+type BlueskyEmbed struct {
+	Type     string               `json:"$type"`
+	External BlueskyExternalEmbed `json:"external"`
+}
+
+// BlueskyExternalEmbed describes the linked page of a link card
+type BlueskyExternalEmbed struct {
+	URI         string          `json:"uri"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Thumb       json.RawMessage `json:"thumb,omitempty"` // blob returned by uploadBlob
+}
+
+// blueskySession is an authenticated AT Protocol session
+type blueskySession struct {
+	AccessJwt  string `json:"accessJwt"`
+	RefreshJwt string `json:"refreshJwt"`
+	DID        string `json:"did"`
+
+	password string // app password the session was created with
+}
+
+// blueskyTokenMargin is how long before its expiry an access token is refreshed
+const blueskyTokenMargin = time.Minute
+
+// blueskyTIDAlphabet is the base32 alphabet of record keys in the TID format
+const blueskyTIDAlphabet = "234567abcdefghijklmnopqrstuvwxyz"
+
+// blueskyLinkPattern matches the links in a post's text that are turned into link facets
+var blueskyLinkPattern = regexp.MustCompile(`https?://[^\s]+`)
+
+// sendBluesky creates a go-live post with a link card through the AT Protocol and deletes it on
+// stream.offline when the target asks for it
+func (d *Dispatcher) sendBluesky(ctx context.Context, req *DispatchRequest, text string) *DispatchResult {
+	switch req.Payload.EventType {
+	case "", eventTypeStreamOnline:
+	case eventTypeStreamOffline:
+		if !req.DeleteOnOffline {
+			return d.skipSocialEvent(req)
+		}
+		if _, ok := d.sentMessage(req); !ok {
+			d.warnUnknownSentMessage(req)
+			return d.skipSocialEvent(req)
+		}
+	default:
+		return d.skipSocialEvent(req)
+	}
+
+	pdsURL := strings.TrimSuffix(req.WebhookURL, "/")
+
+	if req.Payload.EventType == eventTypeStreamOffline {
+		rkey, _ := d.sentMessage(req)
+		result, _ := d.withBlueskySession(ctx, req, pdsURL, func(session *blueskySession) (*DispatchResult, []byte) {
+			deletion := map[string]string{"repo": session.DID, "collection": blueskyPostCollection, "rkey": rkey}
+			return d.callAPI(ctx, http.MethodPost, pdsURL+"/xrpc/com.atproto.repo.deleteRecord", deletion, bearerAuth(session.AccessJwt))
+		})
+		if result.Success {
+			d.forgetSentMessage(req)
+		}
+		return result
+	}
+
+	// The record key is derived from the event, so a retry after a lost response conflicts with
+	// the post it already created instead of posting twice
+	rkey := blueskyRecordKey(req)
+	result, respBody := d.withBlueskySession(ctx, req, pdsURL, func(session *blueskySession) (*DispatchResult, []byte) {
+		auth := bearerAuth(session.AccessJwt)
+		post := newBlueskyPost(text, req.Payload)
+		if thumb := d.uploadBlueskyThumb(ctx, req, pdsURL, auth); thumb != nil {
+			post.Embed.External.Thumb = thumb
+		}
+
+		record := map[string]interface{}{"repo": session.DID, "collection": blueskyPostCollection, "rkey": rkey, "record": post}
+		return d.callAPI(ctx, http.MethodPost, pdsURL+"/xrpc/com.atproto.repo.createRecord", record, auth)
+	})
+	if !result.Success && blueskyRecordExists(result, respBody) {
+		d.logger.Info("Bluesky post already exists",
+			"streamer_key", req.StreamerKey,
+			"target_name", req.TargetName,
+			"rkey", rkey)
+		result = &DispatchResult{Success: true, StatusCode: result.StatusCode}
+	}
+	if result.Success && req.DeleteOnOffline {
+		d.rememberSentMessage(req, rkey)
+	}
+
+	return result
+}
+
+// blueskyRecordKey returns the record key of the go-live post of an event: a TID with the event's
+// timestamp and a clock ID derived from its delivery ID
+func blueskyRecordKey(req *DispatchRequest) string {
+	hash := sha256.Sum256([]byte(deliveryID(req)))
+	clockID := uint64(binary.BigEndian.Uint16(hash[:2])) & 0x3ff
+	tid := (uint64(req.Payload.Timestamp.UnixMicro())&(1<<53-1))<<10 | clockID
+
+	key := make([]byte, 13)
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i] = blueskyTIDAlphabet[tid&31]
+		tid >>= 5
+	}
+	return string(key)
+}
+
+// blueskyError returns the error name, e.g. "ExpiredToken", and message of a failed XRPC call
+func blueskyError(respBody []byte) (string, string) {
+	var xrpcError struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(respBody, &xrpcError)
+	return xrpcError.Error, xrpcError.Message
+}
+
+// blueskyRecordExists reports whether createRecord failed because the record key is taken
+func blueskyRecordExists(result *DispatchResult, respBody []byte) bool {
+	if result.StatusCode != http.StatusBadRequest && result.StatusCode != http.StatusConflict {
+		return false
+	}
+	_, message := blueskyError(respBody)
+	return strings.Contains(strings.ToLower(message), "already exists")
+}
+
+// blueskySessionRejected reports whether a call failed because its session is no longer valid
+func blueskySessionRejected(result *DispatchResult, respBody []byte) bool {
+	if result.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	name, _ := blueskyError(respBody)
+	return result.StatusCode == http.StatusBadRequest && (name == "ExpiredToken" || name == "InvalidToken")
+}
+
+// withBlueskySession makes calls with the session of the target's account. When the PDS rejects
+// the session, e.g. because it was revoked, the calls are made once more with a new login.
+func (d *Dispatcher) withBlueskySession(ctx context.Context, req *DispatchRequest, pdsURL string, call func(*blueskySession) (*DispatchResult, []byte)) (*DispatchResult, []byte) {
+	session, result := d.blueskySession(ctx, req, pdsURL)
+	if session == nil {
+		return result, nil
+	}
+
+	result, respBody := call(session)
+	if !blueskySessionRejected(result, respBody) {
+		return result, respBody
+	}
+
+	d.dropBlueskySession(pdsURL, req.Handle)
+	if session, result = d.blueskySession(ctx, req, pdsURL); session == nil {
+		return result, nil
+	}
+	return call(session)
+}
+
+// blueskySession returns the session of the target's account. A cached session is reused and
+// refreshed when its access token is about to expire; a new session is created when there is
+// none, the refresh fails or the app password changed.
+func (d *Dispatcher) blueskySession(ctx context.Context, req *DispatchRequest, pdsURL string) (*blueskySession, *DispatchResult) {
+	key := pdsURL + " " + req.Handle
+	d.blueskySessionsMutex.Lock()
+	session := d.blueskySessions[key]
+	d.blueskySessionsMutex.Unlock()
+
+	if session != nil && session.password == req.AppPassword {
+		if !jwtExpiresBefore(session.AccessJwt, time.Now().Add(blueskyTokenMargin)) {
+			return session, nil
+		}
+		if refreshed := d.refreshBlueskySession(ctx, req, pdsURL, session); refreshed != nil {
+			d.storeBlueskySession(key, refreshed)
+			return refreshed, nil
+		}
+	}
+
+	session, result := d.createBlueskySession(ctx, req, pdsURL)
+	if session == nil {
+		return nil, result
+	}
+	d.storeBlueskySession(key, session)
+	return session, nil
+}
+
+// storeBlueskySession caches the session of an account
+func (d *Dispatcher) storeBlueskySession(key string, session *blueskySession) {
+	d.blueskySessionsMutex.Lock()
+	defer d.blueskySessionsMutex.Unlock()
+	d.blueskySessions[key] = session
+}
+
+// dropBlueskySession removes the cached session of an account
+func (d *Dispatcher) dropBlueskySession(pdsURL, handle string) {
+	d.blueskySessionsMutex.Lock()
+	defer d.blueskySessionsMutex.Unlock()
+	delete(d.blueskySessions, pdsURL+" "+handle)
+}
+
+// jwtExpiresBefore reports whether a JWT expires before t. Tokens without a readable expiry are
+// treated as valid; the PDS rejects them when they are not.
+func jwtExpiresBefore(token string, t time.Time) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(claimsJSON, &claims) != nil || claims.Exp == 0 {
+		return false
+	}
+	return time.Unix(claims.Exp, 0).Before(t)
+}
+
+// newBlueskyPost creates a post with link facets for the links in its text and a link card for
+// the channel
+func newBlueskyPost(text string, payload WebhookPayload) BlueskyPost {
+	post := BlueskyPost{
+		Type:      blueskyPostCollection,
+		Text:      text,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Embed: &BlueskyEmbed{
+			Type: "app.bsky.embed.external",
+			External: BlueskyExternalEmbed{
+				URI:         payload.URL,
+				Title:       fmt.Sprintf("%s - Twitch", payload.StreamerName),
+				Description: payload.Title,
+			},
+		},
+	}
+	if payload.Language != "" {
+		post.Langs = []string{payload.Language}
+	}
+
+	for _, match := range blueskyLinkPattern.FindAllStringIndex(text, -1) {
+		post.Facets = append(post.Facets, BlueskyFacet{
+			Index: BlueskyByteSlice{ByteStart: match[0], ByteEnd: match[1]},
+			Features: []BlueskyFacetFeature{{
+				Type: "app.bsky.richtext.facet#link",
+				URI:  text[match[0]:match[1]],
+			}},
+		})
+	}
+
+	return post
+}
+
+// createBlueskySession logs in with the target's app password. It returns a failed result instead
+// of a session when the login fails.
+func (d *Dispatcher) createBlueskySession(ctx context.Context, req *DispatchRequest, pdsURL string) (*blueskySession, *DispatchResult) {
+	credentials := map[string]string{"identifier": req.Handle, "password": req.AppPassword}
+	result, respBody := d.callAPI(ctx, http.MethodPost, pdsURL+"/xrpc/com.atproto.server.createSession", credentials, nil)
+	if !result.Success {
+		result.Error = fmt.Sprintf("bluesky login failed: %s", result.Error)
+		return nil, result
+	}
+
+	var session blueskySession
+	if err := json.Unmarshal(respBody, &session); err != nil || session.AccessJwt == "" || session.DID == "" {
+		return nil, &DispatchResult{Success: false, StatusCode: result.StatusCode, Error: "bluesky login returned no session"}
+	}
+	session.password = req.AppPassword
+	return &session, nil
+}
+
+// refreshBlueskySession renews the access token of a session with its refresh token. It returns
+// nil when the refresh fails.
+func (d *Dispatcher) refreshBlueskySession(ctx context.Context, req *DispatchRequest, pdsURL string, session *blueskySession) *blueskySession {
+	if session.RefreshJwt == "" {
+		return nil
+	}
+
+	result, respBody := d.callAPI(ctx, http.MethodPost, pdsURL+"/xrpc/com.atproto.server.refreshSession", nil, bearerAuth(session.RefreshJwt))
+	var refreshed blueskySession
+	if !result.Success || json.Unmarshal(respBody, &refreshed) != nil || refreshed.AccessJwt == "" || refreshed.DID == "" {
+		d.logger.Warn("Failed to refresh Bluesky session, logging in again",
+			"streamer_key", req.StreamerKey,
+			"target_name", req.TargetName,
+			"error", result.Error)
+		return nil
+	}
+	refreshed.password = session.password
+	return &refreshed
+}
+
+// uploadBlueskyThumb uploads the cached profile image as link card thumbnail. The post is sent
+// without a thumbnail when there is no cached image or the upload fails.
+func (d *Dispatcher) uploadBlueskyThumb(ctx context.Context, req *DispatchRequest, pdsURL string, auth http.Header) json.RawMessage {
+	if req.Payload.Image == nil || req.Payload.Image.Data == "" {
+		return nil
+	}
+
+	image, err := base64.StdEncoding.DecodeString(req.Payload.Image.Data)
+	if err != nil {
+		d.logger.Warn("Failed to decode cached profile image", "streamer_key", req.StreamerKey, "error", err)
+		return nil
+	}
+
+	result, respBody := d.send(ctx, http.MethodPost, pdsURL+"/xrpc/com.atproto.repo.uploadBlob", image, http.DetectContentType(image), credentials{}, auth)
+	var uploaded struct {
+		Blob json.RawMessage `json:"blob"`
+	}
+	if !result.Success || json.Unmarshal(respBody, &uploaded) != nil || len(uploaded.Blob) == 0 {
+		d.logger.Warn("Failed to upload link card thumbnail to Bluesky",
+			"streamer_key", req.StreamerKey,
+			"target_name", req.TargetName,
+			"error", result.Error)
+		return nil
+	}
+	return uploaded.Blob
+}
@@ -0,0 +1,235 @@
+package webhook
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchBlueskyPostsAndDeletes(t *testing.T) {
+	var calls []string
+	var createdRkey string
+	var record struct {
+		Repo       string      `json:"repo"`
+		Collection string      `json:"collection"`
+		Record     BlueskyPost `json:"record"`
+		Rkey       string      `json:"rkey"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+
+		switch r.URL.Path {
+		case "/xrpc/com.atproto.server.createSession":
+			assert.JSONEq(t, `{"identifier":"streams.example.com","password":"app-pass"}`, string(body))
+			w.Write([]byte(`{"accessJwt":"jwt","did":"did:plc:abc"}`))
+			return
+		case "/xrpc/com.atproto.repo.uploadBlob":
+			assert.Equal(t, "image/png", r.Header.Get("Content-Type"))
+			w.Write([]byte(`{"blob":{"$type":"blob","ref":{"$link":"bafk"},"mimeType":"image/png","size":8}}`))
+			return
+		}
+
+		assert.Equal(t, "Bearer jwt", r.Header.Get("Authorization"))
+		require.NoError(t, json.Unmarshal(body, &record))
+		if r.URL.Path == "/xrpc/com.atproto.repo.createRecord" {
+			createdRkey = record.Rkey
+			w.Write([]byte(`{"uri":"at://did:plc:abc/app.bsky.feed.post/3kxyz","cid":"bafy"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+
+	png := []byte("\x89PNG\r\n\x1a\n")
+	req := &DispatchRequest{
+		WebhookURL: server.URL,
+		Payload: WebhookPayload{
+			StreamerName: "Tëst Streamer",
+			URL:          "https://twitch.tv/teststreamer",
+			Title:        "Speedruns",
+			Image:        &ImageData{Data: base64.StdEncoding.EncodeToString(png)},
+			EventType:    eventTypeStreamOnline,
+			Timestamp:    time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC),
+		},
+		StreamerKey:     "test_streamer",
+		TargetName:      "bluesky",
+		Format:          config.TargetFormatBluesky,
+		Handle:          "streams.example.com",
+		AppPassword:     "app-pass",
+		DeleteOnOffline: true,
+		Attempt:         1,
+	}
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+
+	assert.Equal(t, blueskyRecordKey(req), createdRkey)
+	assert.Equal(t, "did:plc:abc", record.Repo)
+	assert.Equal(t, blueskyPostCollection, record.Collection)
+	post := record.Record
+	assert.Equal(t, "Tëst Streamer is live on Twitch: Speedruns\n\nhttps://twitch.tv/teststreamer", post.Text)
+	require.Len(t, post.Facets, 1)
+	facet := post.Facets[0]
+	assert.Equal(t, "https://twitch.tv/teststreamer", post.Text[facet.Index.ByteStart:facet.Index.ByteEnd])
+	assert.Equal(t, "https://twitch.tv/teststreamer", post.Embed.External.URI)
+	assert.JSONEq(t, `{"$type":"blob","ref":{"$link":"bafk"},"mimeType":"image/png","size":8}`, string(post.Embed.External.Thumb))
+
+	req.Payload.EventType = eventTypeStreamOffline
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+	assert.Equal(t, createdRkey, record.Rkey)
+
+	// The session of the go-live post is reused
+	assert.Equal(t, []string{
+		"/xrpc/com.atproto.server.createSession",
+		"/xrpc/com.atproto.repo.uploadBlob",
+		"/xrpc/com.atproto.repo.createRecord",
+		"/xrpc/com.atproto.repo.deleteRecord",
+	}, calls)
+}
+
+func TestBlueskyRecordKey(t *testing.T) {
+	req := &DispatchRequest{
+		StreamerKey: "test_streamer",
+		TargetName:  "bluesky",
+		Payload:     WebhookPayload{EventType: eventTypeStreamOnline, Timestamp: time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)},
+	}
+	key := blueskyRecordKey(req)
+	assert.Regexp(t, `^[234567abcdefghij][234567a-z]{12}$`, key)
+
+	// A retry of the same event uses the same key, another event a different one
+	retry := *req
+	retry.Attempt = 3
+	assert.Equal(t, key, blueskyRecordKey(&retry))
+	other := *req
+	other.TargetName = "bluesky_alt"
+	assert.NotEqual(t, key, blueskyRecordKey(&other))
+}
+
+func TestDispatchBlueskyRetryAfterLostResponse(t *testing.T) {
+	var rkeys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/xrpc/com.atproto.server.createSession" {
+			w.Write([]byte(`{"accessJwt":"jwt","did":"did:plc:abc"}`))
+			return
+		}
+		var record struct {
+			Rkey string `json:"rkey"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&record))
+		rkeys = append(rkeys, record.Rkey)
+
+		// The first post was created, but its response did not arrive
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"InvalidRequest","message":"Record already exists"}`))
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+
+	req := newBlueskyTestRequest(server.URL)
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+	require.Len(t, rkeys, 1)
+	assert.Equal(t, blueskyRecordKey(req), rkeys[0])
+
+	// The existing post is deleted on stream.offline
+	rkey, ok := dispatcher.sentMessage(req)
+	assert.True(t, ok)
+	assert.Equal(t, rkeys[0], rkey)
+}
+
+func TestBlueskySessionIsRefreshedAndRenewed(t *testing.T) {
+	expired := testJWT(time.Now().Add(-time.Minute))
+	valid := testJWT(time.Now().Add(time.Hour))
+
+	var calls []string
+	rejectNext := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path)
+		switch r.URL.Path {
+		case "/xrpc/com.atproto.server.createSession":
+			w.Write([]byte(`{"accessJwt":"` + expired + `","refreshJwt":"refresh","did":"did:plc:abc"}`))
+		case "/xrpc/com.atproto.server.refreshSession":
+			assert.Equal(t, "Bearer refresh", r.Header.Get("Authorization"))
+			w.Write([]byte(`{"accessJwt":"` + valid + `","refreshJwt":"refresh2","did":"did:plc:abc"}`))
+		default:
+			if rejectNext {
+				rejectNext = false
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"ExpiredToken","message":"Token has been revoked"}`))
+				return
+			}
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+	req := newBlueskyTestRequest(server.URL)
+
+	// A cached session whose access token expired is refreshed before the next post
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+	assert.Equal(t, []string{
+		"/xrpc/com.atproto.server.createSession",
+		"/xrpc/com.atproto.repo.createRecord",
+		"/xrpc/com.atproto.server.refreshSession",
+		"/xrpc/com.atproto.repo.createRecord",
+	}, calls)
+
+	// A rejected session is replaced by a new login
+	calls = nil
+	rejectNext = true
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+	assert.Equal(t, []string{
+		"/xrpc/com.atproto.repo.createRecord",
+		"/xrpc/com.atproto.server.createSession",
+		"/xrpc/com.atproto.repo.createRecord",
+	}, calls)
+
+	// A changed app password logs in again
+	calls = nil
+	req.AppPassword = "new-app-pass"
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+	assert.Equal(t, "/xrpc/com.atproto.server.createSession", calls[0])
+}
+
+func newBlueskyTestRequest(pdsURL string) *DispatchRequest {
+	return &DispatchRequest{
+		WebhookURL: pdsURL,
+		Payload: WebhookPayload{
+			StreamerName: "Test Streamer",
+			URL:          "https://twitch.tv/teststreamer",
+			EventType:    eventTypeStreamOnline,
+			Timestamp:    time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC),
+		},
+		StreamerKey:     "test_streamer",
+		TargetName:      "bluesky",
+		Format:          config.TargetFormatBluesky,
+		Handle:          "streams.example.com",
+		AppPassword:     "app-pass",
+		DeleteOnOffline: true,
+		Attempt:         1,
+	}
+}
+
+// testJWT returns an unsigned JWT that expires at exp
+func testJWT(exp time.Time) string {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix())))
+	return "eyJhbGciOiJub25lIn0." + claims + ".sig"
+}
@@ -0,0 +1,198 @@
+package webhook
+
+import (
+	"math/rand/v2"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+)
+
+// CircuitState is the state of the circuit breaker of a target host
+type CircuitState string
+
+// Circuit states
+const (
+	CircuitClosed   CircuitState = "closed"    // requests are sent
+	CircuitOpen     CircuitState = "open"      // requests are deferred until the open timeout ends
+	CircuitHalfOpen CircuitState = "half_open" // one probe request at a time decides whether to close again
+)
+
+// CircuitStatus is the circuit breaker state of a target host
+type CircuitStatus struct {
+	Host                string       `json:"host"`
+	State               CircuitState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	OpenUntil           *time.Time   `json:"open_until,omitempty"`
+}
+
+// circuit tracks the recent outcomes of requests to one host
+type circuit struct {
+	state     CircuitState
+	failures  int // consecutive failures while closed
+	successes int // successful probes while half-open
+	openUntil time.Time
+	probing   bool // a half-open probe is in flight
+}
+
+// circuitOutcome is how a dispatch result counts for the circuit of its host
+type circuitOutcome int
+
+const (
+	outcomeSuccess circuitOutcome = iota // the host responded
+	outcomeFailure                       // the host was unreachable or failed with 5xx
+	outcomeNeutral                       // the result says nothing about the host, e.g. 429
+)
+
+// circuitBreakers keeps a circuit breaker per target host, so that a receiver that is down does
+// not cost a timeout for every event
+type circuitBreakers struct {
+	circuits map[string]*circuit
+	mutex    sync.Mutex
+}
+
+func newCircuitBreakers() *circuitBreakers {
+	return &circuitBreakers{circuits: make(map[string]*circuit)}
+}
+
+// circuitHost returns the host a request URL is keyed by in the circuit breakers
+func circuitHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// outcomeOf returns how a dispatch result counts for the circuit of its host
+func outcomeOf(result *DispatchResult) circuitOutcome {
+	switch {
+	case result.Success:
+		return outcomeSuccess
+	case result.FailureClass == FailureNetwork || result.FailureClass == FailureServerError:
+		return outcomeFailure
+	case result.FailureClass == FailurePermanent && result.StatusCode != 0:
+		// A 4xx is an answer from a working host
+		return outcomeSuccess
+	}
+	return outcomeNeutral
+}
+
+// probeDelay is how long requests are deferred while a half-open probe is in flight
+func probeDelay(settings config.CircuitBreakerConfig) time.Duration {
+	return max(settings.OpenTimeout/10, time.Second)
+}
+
+// spreadDelay adds a random part of up to one probe delay to a deferral, so that the requests
+// deferred by a circuit do not all return at the same moment and pile up behind the probe
+func spreadDelay(delay time.Duration, settings config.CircuitBreakerConfig) time.Duration {
+	return delay + rand.N(probeDelay(settings))
+}
+
+// allow reports whether a request to host may be sent at now. Otherwise it returns how long the
+// request should be deferred.
+func (b *circuitBreakers) allow(host string, settings config.CircuitBreakerConfig, now time.Time) (time.Duration, bool) {
+	if !settings.Enabled || host == "" {
+		return 0, true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c, ok := b.circuits[host]
+	if !ok {
+		return 0, true
+	}
+
+	switch c.state {
+	case CircuitOpen:
+		if now.Before(c.openUntil) {
+			return spreadDelay(c.openUntil.Sub(now), settings), false
+		}
+		c.state = CircuitHalfOpen
+		c.successes = 0
+		c.probing = true
+		return 0, true
+	case CircuitHalfOpen:
+		if c.probing {
+			return spreadDelay(probeDelay(settings), settings), false
+		}
+		c.probing = true
+		return 0, true
+	}
+	return 0, true
+}
+
+// record updates the circuit of host with the outcome of a request. It returns the previous and
+// the new state of the circuit.
+func (b *circuitBreakers) record(host string, outcome circuitOutcome, settings config.CircuitBreakerConfig, now time.Time) (CircuitState, CircuitState) {
+	if !settings.Enabled || host == "" {
+		return CircuitClosed, CircuitClosed
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c, ok := b.circuits[host]
+	if !ok {
+		c = &circuit{state: CircuitClosed}
+		b.circuits[host] = c
+	}
+	previous := c.state
+
+	switch c.state {
+	case CircuitClosed:
+		switch outcome {
+		case outcomeSuccess:
+			c.failures = 0
+		case outcomeFailure:
+			c.failures++
+			if c.failures >= settings.FailureThreshold {
+				c.state = CircuitOpen
+				c.openUntil = now.Add(settings.OpenTimeout)
+			}
+		}
+	case CircuitHalfOpen:
+		c.probing = false
+		switch outcome {
+		case outcomeSuccess:
+			c.successes++
+			if c.successes >= settings.SuccessThreshold {
+				c.state = CircuitClosed
+				c.failures = 0
+			}
+		case outcomeFailure:
+			c.state = CircuitOpen
+			c.openUntil = now.Add(settings.OpenTimeout)
+		}
+	}
+	// Requests that were sent before the circuit opened do not change an open circuit
+
+	return previous, c.state
+}
+
+// statuses returns the circuit of every host requests were sent to at now, sorted by host. An
+// open circuit whose timeout ended is shown as half-open, since the next request is a probe.
+func (b *circuitBreakers) statuses(now time.Time) []CircuitStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	statuses := make([]CircuitStatus, 0, len(b.circuits))
+	for host, c := range b.circuits {
+		status := CircuitStatus{Host: host, State: c.state, ConsecutiveFailures: c.failures}
+		if c.state == CircuitOpen {
+			if now.Before(c.openUntil) {
+				openUntil := c.openUntil
+				status.OpenUntil = &openUntil
+			} else {
+				status.State = CircuitHalfOpen
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Host < statuses[j].Host })
+	return statuses
+}
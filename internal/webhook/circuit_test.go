@@ -0,0 +1,164 @@
+package webhook
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCircuitSettings() config.CircuitBreakerConfig {
+	return config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 3, OpenTimeout: time.Minute, SuccessThreshold: 2}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	breakers := newCircuitBreakers()
+	settings := testCircuitSettings()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	const host = "hooks.example.com"
+
+	// Failures below the threshold keep the circuit closed, and a success resets the count
+	breakers.record(host, outcomeFailure, settings, now)
+	breakers.record(host, outcomeFailure, settings, now)
+	breakers.record(host, outcomeSuccess, settings, now)
+	breakers.record(host, outcomeFailure, settings, now)
+	breakers.record(host, outcomeFailure, settings, now)
+	_, ok := breakers.allow(host, settings, now)
+	assert.True(t, ok)
+
+	previous, state := breakers.record(host, outcomeFailure, settings, now)
+	assert.Equal(t, CircuitClosed, previous)
+	assert.Equal(t, CircuitOpen, state)
+
+	// Requests are deferred until the open timeout ends
+	delay, ok := breakers.allow(host, settings, now.Add(20*time.Second))
+	assert.False(t, ok)
+	assertDeferred(t, 40*time.Second, delay)
+
+	// The health endpoint shows when the circuit closes, and a half-open circuit once it is due
+	statuses := breakers.statuses(now.Add(20 * time.Second))
+	require.Len(t, statuses, 1)
+	assert.Equal(t, CircuitOpen, statuses[0].State)
+	require.NotNil(t, statuses[0].OpenUntil)
+	assert.Equal(t, now.Add(time.Minute), *statuses[0].OpenUntil)
+	statuses = breakers.statuses(now.Add(time.Minute))
+	assert.Equal(t, CircuitStatus{Host: host, State: CircuitHalfOpen, ConsecutiveFailures: 3}, statuses[0])
+
+	// Then a single probe is let through
+	_, ok = breakers.allow(host, settings, now.Add(time.Minute))
+	assert.True(t, ok)
+	delay, ok = breakers.allow(host, settings, now.Add(time.Minute))
+	assert.False(t, ok)
+	assertDeferred(t, 6*time.Second, delay)
+
+	// A failed probe opens the circuit again
+	_, state = breakers.record(host, outcomeFailure, settings, now.Add(time.Minute))
+	assert.Equal(t, CircuitOpen, state)
+	_, ok = breakers.allow(host, settings, now.Add(90*time.Second))
+	assert.False(t, ok)
+
+	// Successful probes close it after success_threshold
+	later := now.Add(2 * time.Minute)
+	_, ok = breakers.allow(host, settings, later)
+	require.True(t, ok)
+	_, state = breakers.record(host, outcomeSuccess, settings, later)
+	assert.Equal(t, CircuitHalfOpen, state)
+	_, ok = breakers.allow(host, settings, later)
+	require.True(t, ok)
+	_, state = breakers.record(host, outcomeSuccess, settings, later)
+	assert.Equal(t, CircuitClosed, state)
+
+	statuses = breakers.statuses(later)
+	require.Len(t, statuses, 1)
+	assert.Equal(t, CircuitStatus{Host: host, State: CircuitClosed}, statuses[0])
+}
+
+// assertDeferred asserts that a deferral lasts delay plus a jitter of less than the probe delay
+func assertDeferred(t *testing.T, delay, actual time.Duration) {
+	t.Helper()
+	assert.GreaterOrEqual(t, actual, delay)
+	assert.Less(t, actual, delay+probeDelay(testCircuitSettings()))
+}
+
+func TestCircuitDeferralsAreSpread(t *testing.T) {
+	breakers := newCircuitBreakers()
+	settings := testCircuitSettings()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	const host = "hooks.example.com"
+	for i := 0; i < settings.FailureThreshold; i++ {
+		breakers.record(host, outcomeFailure, settings, now)
+	}
+
+	delays := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		delay, ok := breakers.allow(host, settings, now)
+		require.False(t, ok)
+		delays[delay] = true
+	}
+	assert.Greater(t, len(delays), 1, "deferred requests should not all return at once")
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	breakers := newCircuitBreakers()
+	settings := testCircuitSettings()
+	settings.Enabled = false
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		breakers.record("hooks.example.com", outcomeFailure, settings, now)
+	}
+	_, ok := breakers.allow("hooks.example.com", settings, now)
+	assert.True(t, ok)
+	assert.Empty(t, breakers.statuses(now))
+}
+
+func TestOutcomeOf(t *testing.T) {
+	assert.Equal(t, outcomeSuccess, outcomeOf(&DispatchResult{Success: true, StatusCode: 200}))
+	assert.Equal(t, outcomeFailure, outcomeOf(&DispatchResult{FailureClass: FailureNetwork}))
+	assert.Equal(t, outcomeFailure, outcomeOf(&DispatchResult{StatusCode: 503, FailureClass: FailureServerError}))
+	assert.Equal(t, outcomeSuccess, outcomeOf(&DispatchResult{StatusCode: 404, FailureClass: FailurePermanent}))
+	assert.Equal(t, outcomeNeutral, outcomeOf(&DispatchResult{StatusCode: 429, FailureClass: FailureRateLimited}))
+	assert.Equal(t, outcomeNeutral, outcomeOf(&DispatchResult{FailureClass: FailurePermanent}))
+}
+
+func TestDispatchDefersWhileCircuitOpen(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.CircuitBreaker = testCircuitSettings()
+	dispatcher := NewDispatcher(cfg, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+	for i := 0; i < 3; i++ {
+		result := dispatcher.Dispatch(context.Background(), &DispatchRequest{WebhookURL: server.URL + "/hook", Attempt: 1})
+		assert.Equal(t, FailureServerError, result.FailureClass)
+	}
+
+	req := &DispatchRequest{WebhookURL: server.URL + "/other", Attempt: 1}
+	result := dispatcher.Dispatch(context.Background(), req)
+	assert.False(t, result.Success)
+	assert.True(t, result.Deferred())
+	assert.True(t, result.Retryable())
+	assert.Equal(t, FailureCircuitOpen, result.FailureClass)
+	assert.InDelta(t, time.Minute.Seconds(), result.RetryAfter.Seconds(), probeDelay(cfg.CircuitBreaker).Seconds()+1)
+	assert.Equal(t, int32(3), requests.Load(), "no request should reach the host while the circuit is open")
+	assert.Empty(t, req.History, "a deferral is not an attempt")
+
+	statuses := dispatcher.CircuitBreakers()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, CircuitOpen, statuses[0].State)
+	assert.Equal(t, 3, statuses[0].ConsecutiveFailures)
+	assert.NotNil(t, statuses[0].OpenUntil)
+}
@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+)
+
+// CloudEvents attributes
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsTypePrefix  = "tv.itsjustintv."
+	cloudEventsContentType = "application/cloudevents+json"
+)
+
+// CloudEvent is a CloudEvents 1.0 event in structured JSON mode carrying a webhook payload
+type CloudEvent struct {
+	SpecVersion     string         `json:"specversion"`
+	ID              string         `json:"id"`
+	Source          string         `json:"source"`
+	Type            string         `json:"type"`
+	Subject         string         `json:"subject,omitempty"`
+	Time            string         `json:"time"`
+	DataContentType string         `json:"datacontenttype"`
+	Data            WebhookPayload `json:"data"`
+}
+
+// newCloudEvent wraps the payload of a request in a CloudEvent
+func newCloudEvent(req *DispatchRequest) CloudEvent {
+	return CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              cloudEventID(req),
+		Source:          req.Payload.URL,
+		Type:            cloudEventType(req.Payload),
+		Subject:         req.Payload.StreamerLogin,
+		Time:            req.Payload.Timestamp.UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            req.Payload,
+	}
+}
+
+// cloudEventID returns the event ID: the EventSub message ID, so the event keeps its ID across
+// retries and targets, or a delivery ID when the payload was not created from a message
+func cloudEventID(req *DispatchRequest) string {
+	if req.MessageID != "" {
+		return req.MessageID
+	}
+	return deliveryID(req)
+}
+
+// cloudEventType returns the event type, e.g. "tv.itsjustintv.stream.online"
+func cloudEventType(payload WebhookPayload) string {
+	eventType := payload.EventType
+	if eventType == "" {
+		eventType = eventTypeStreamOnline
+	}
+	return cloudEventsTypePrefix + eventType
+}
+
+// sendCloudEvent sends a CloudEvent in structured mode, or in binary mode with the event
+// attributes as ce- headers and the payload as body
+func (d *Dispatcher) sendCloudEvent(ctx context.Context, req *DispatchRequest, body []byte) *DispatchResult {
+	if req.CloudEventsMode != config.CloudEventsModeBinary {
+		result, _ := d.send(ctx, http.MethodPost, req.WebhookURL, body, cloudEventsContentType, req.credentials(), nil)
+		return result
+	}
+
+	event := newCloudEvent(req)
+	headers := http.Header{}
+	headers.Set("ce-specversion", event.SpecVersion)
+	headers.Set("ce-id", event.ID)
+	headers.Set("ce-source", event.Source)
+	headers.Set("ce-type", event.Type)
+	headers.Set("ce-time", event.Time)
+	if event.Subject != "" {
+		headers.Set("ce-subject", event.Subject)
+	}
+
+	result, _ := d.send(ctx, http.MethodPost, req.WebhookURL, body, event.DataContentType, req.credentials(), headers)
+	return result
+}
@@ -0,0 +1,75 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchCloudEvents(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+
+	payload := WebhookPayload{
+		StreamerLogin: "teststreamer",
+		URL:           "https://twitch.tv/teststreamer",
+		Timestamp:     time.Date(2026, 10, 16, 18, 0, 0, 0, time.UTC),
+		EventType:     eventTypeStreamOffline,
+	}
+	req := &DispatchRequest{
+		WebhookURL: server.URL,
+		Payload:    payload,
+		MessageID:  "befa7b53-d79d-478f-86b9-120f112b044e",
+		Format:     config.TargetFormatCloudEvents,
+		Attempt:    1,
+	}
+
+	t.Run("structured", func(t *testing.T) {
+		require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+		assert.Equal(t, cloudEventsContentType, header.Get("Content-Type"))
+
+		var event CloudEvent
+		require.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, "1.0", event.SpecVersion)
+		assert.Equal(t, "befa7b53-d79d-478f-86b9-120f112b044e", event.ID)
+		assert.Equal(t, "https://twitch.tv/teststreamer", event.Source)
+		assert.Equal(t, "tv.itsjustintv.stream.offline", event.Type)
+		assert.Equal(t, "2026-10-16T18:00:00Z", event.Time)
+		assert.Equal(t, "teststreamer", event.Data.StreamerLogin)
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		binary := *req
+		binary.CloudEventsMode = config.CloudEventsModeBinary
+		require.True(t, dispatcher.Dispatch(context.Background(), &binary).Success)
+
+		assert.Equal(t, "application/json", header.Get("Content-Type"))
+		assert.Equal(t, "1.0", header.Get("ce-specversion"))
+		assert.Equal(t, "befa7b53-d79d-478f-86b9-120f112b044e", header.Get("ce-id"))
+		assert.Equal(t, "https://twitch.tv/teststreamer", header.Get("ce-source"))
+		assert.Equal(t, "tv.itsjustintv.stream.offline", header.Get("ce-type"))
+
+		var data WebhookPayload
+		require.NoError(t, json.Unmarshal(body, &data))
+		assert.Equal(t, payload.StreamerLogin, data.StreamerLogin)
+	})
+}
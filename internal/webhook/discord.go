@@ -0,0 +1,180 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Discord embed limits
+const (
+	discordTitleLimit       = 256
+	discordDescriptionLimit = 4096
+	discordFieldValueLimit  = 1024
+)
+
+// DiscordMessage is the body of a Discord webhook execution
+type DiscordMessage struct {
+	Embeds          []DiscordEmbed         `json:"embeds"`
+	AllowedMentions DiscordAllowedMentions `json:"allowed_mentions"`
+}
+
+// DiscordAllowedMentions controls which mentions in a message ping users
+type DiscordAllowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+// DiscordEmbed is a Discord rich embed
+type DiscordEmbed struct {
+	Title       string              `json:"title"`
+	URL         string              `json:"url,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color"`
+	Fields      []DiscordEmbedField `json:"fields,omitempty"`
+	Thumbnail   *DiscordEmbedImage  `json:"thumbnail,omitempty"`
+	Footer      *DiscordEmbedFooter `json:"footer,omitempty"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+}
+
+// DiscordEmbedField is a name/value field of a Discord embed
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// DiscordEmbedImage is an image of a Discord embed
+type DiscordEmbedImage struct {
+	URL string `json:"url"`
+}
+
+// DiscordEmbedFooter is the footer of a Discord embed
+type DiscordEmbedFooter struct {
+	Text string `json:"text"`
+}
+
+// newDiscordMessage renders a webhook payload as a Discord message with a single embed
+func newDiscordMessage(payload WebhookPayload) DiscordMessage {
+	n := newNotification(payload)
+	embed := DiscordEmbed{
+		Title:       truncate(n.Title, discordTitleLimit),
+		URL:         n.URL,
+		Description: truncate(n.Description, discordDescriptionLimit),
+		Color:       n.Color,
+		Footer:      &DiscordEmbedFooter{Text: "Twitch"},
+		Timestamp:   n.Timestamp.Format(time.RFC3339),
+	}
+	if n.ImageURL != "" {
+		embed.Thumbnail = &DiscordEmbedImage{URL: n.ImageURL}
+	}
+	for _, field := range n.Fields {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{
+			Name:   field.Name,
+			Value:  truncate(field.Value, discordFieldValueLimit),
+			Inline: field.Inline,
+		})
+	}
+
+	return DiscordMessage{
+		Embeds:          []DiscordEmbed{embed},
+		AllowedMentions: DiscordAllowedMentions{Parse: []string{}},
+	}
+}
+
+// sendDiscord executes a Discord webhook. Go-live messages are remembered when the target edits
+// them on stream.offline, and the offline message then replaces the original one.
+func (d *Dispatcher) sendDiscord(ctx context.Context, req *DispatchRequest, body []byte) *DispatchResult {
+	if req.EditOnOffline && req.Payload.EventType == eventTypeStreamOffline {
+		if messageID, ok := d.sentMessage(req); ok {
+			editURL, err := discordMessageURL(req.WebhookURL, messageID)
+			if err != nil {
+				return &DispatchResult{Success: false, Error: err.Error()}
+			}
+
+			result, respBody := d.send(ctx, http.MethodPatch, editURL, body, "", req.credentials(), nil)
+			if result.StatusCode != http.StatusNotFound {
+				if result.Success {
+					d.forgetSentMessage(req)
+				}
+				applyDiscordRetryAfter(result, respBody)
+				return result
+			}
+
+			// The message was deleted in Discord; announce the offline event as a new message
+			d.forgetSentMessage(req)
+		} else {
+			d.warnUnknownSentMessage(req)
+		}
+	}
+
+	executeURL := req.WebhookURL
+	track := req.EditOnOffline && req.Payload.EventType == eventTypeStreamOnline
+	if track {
+		var err error
+		if executeURL, err = discordWaitURL(req.WebhookURL); err != nil {
+			return &DispatchResult{Success: false, Error: err.Error()}
+		}
+	}
+
+	result, respBody := d.send(ctx, http.MethodPost, executeURL, body, "", req.credentials(), nil)
+	applyDiscordRetryAfter(result, respBody)
+
+	if track && result.Success {
+		var message struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(respBody, &message); err != nil || message.ID == "" {
+			d.logger.Warn("Discord did not return a message ID, the message cannot be edited on stream.offline",
+				"streamer_key", req.StreamerKey,
+				"target_name", req.TargetName)
+		} else {
+			d.rememberSentMessage(req, message.ID)
+		}
+	}
+
+	return result
+}
+
+// applyDiscordRetryAfter sets the retry delay requested by a Discord 429 response
+func applyDiscordRetryAfter(result *DispatchResult, respBody []byte) {
+	if result.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+
+	var rateLimit struct {
+		RetryAfter float64 `json:"retry_after"` // seconds
+	}
+	if err := json.Unmarshal(respBody, &rateLimit); err == nil && rateLimit.RetryAfter > 0 {
+		result.RetryAfter = time.Duration(rateLimit.RetryAfter * float64(time.Second))
+		result.Error = fmt.Sprintf("HTTP %d (rate limited, retry after %s)", result.StatusCode, result.RetryAfter)
+	}
+}
+
+// discordWaitURL adds wait=true to a Discord webhook URL so the created message is returned
+func discordWaitURL(webhookURL string) (string, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid discord webhook URL: %w", err)
+	}
+	query := u.Query()
+	query.Set("wait", "true")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// discordMessageURL returns the URL of a message sent by a Discord webhook, keeping the thread_id
+func discordMessageURL(webhookURL, messageID string) (string, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid discord webhook URL: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/messages/" + url.PathEscape(messageID)
+	query := u.Query()
+	query.Del("wait")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
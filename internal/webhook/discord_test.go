@@ -0,0 +1,117 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDiscordMessage(t *testing.T) {
+	startedAt := time.Date(2026, 10, 16, 18, 0, 0, 0, time.UTC)
+	message := newDiscordMessage(WebhookPayload{
+		StreamerName: "Test Streamer",
+		URL:          "https://twitch.tv/teststreamer",
+		Title:        "Speedrunning @everyone",
+		CategoryName: "Celeste",
+		Tags:         []string{"English", "Speedrun"},
+		Image:        &ImageData{URL: "https://static-cdn.jtvnw.net/profile.png"},
+		EventType:    eventTypeStreamOnline,
+		StartedAt:    &startedAt,
+	})
+
+	require.Len(t, message.Embeds, 1)
+	embed := message.Embeds[0]
+	assert.Equal(t, "Test Streamer is live on Twitch", embed.Title)
+	assert.Equal(t, "Speedrunning @everyone", embed.Description)
+	assert.Equal(t, colorLive, embed.Color)
+	assert.Equal(t, "https://static-cdn.jtvnw.net/profile.png", embed.Thumbnail.URL)
+	assert.Equal(t, "2026-10-16T18:00:00Z", embed.Timestamp)
+	assert.Equal(t, []DiscordEmbedField{
+		{Name: "Game", Value: "Celeste", Inline: true},
+		{Name: "Tags", Value: "English, Speedrun", Inline: true},
+	}, embed.Fields)
+	assert.Empty(t, message.AllowedMentions.Parse)
+
+	offline := newDiscordMessage(WebhookPayload{
+		StreamerName:    "Test Streamer",
+		EventType:       eventTypeStreamOffline,
+		DurationSeconds: 2*3600 + 13*60,
+	})
+	assert.Equal(t, "Streamed for 2h 13m", offline.Embeds[0].Description)
+	assert.Empty(t, offline.Embeds[0].Fields)
+}
+
+func TestDispatchDiscordEditsMessageOnOffline(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		body, _ := io.ReadAll(r.Body)
+		var message DiscordMessage
+		require.NoError(t, json.Unmarshal(body, &message))
+
+		if r.Method == http.MethodPost {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"111"}`))
+			return
+		}
+		assert.Equal(t, colorOffline, message.Embeds[0].Color)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+
+	req := &DispatchRequest{
+		WebhookURL:    server.URL + "/api/webhooks/1/token?thread_id=9",
+		Payload:       WebhookPayload{StreamerName: "Test Streamer", EventType: eventTypeStreamOnline},
+		StreamerKey:   "test_streamer",
+		TargetName:    "discord",
+		Format:        config.TargetFormatDiscord,
+		EditOnOffline: true,
+		Attempt:       1,
+	}
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+
+	req.Payload.EventType = eventTypeStreamOffline
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+
+	assert.Equal(t, []string{
+		"POST /api/webhooks/1/token?thread_id=9&wait=true",
+		"PATCH /api/webhooks/1/token/messages/111?thread_id=9",
+	}, requests)
+	assert.Empty(t, dispatcher.sentMessages)
+}
+
+func TestDispatchDiscordRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"message":"You are being rate limited.","retry_after":1.5,"global":false}`))
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+
+	result := dispatcher.Dispatch(context.Background(), &DispatchRequest{
+		WebhookURL: server.URL,
+		Payload:    WebhookPayload{StreamerName: "Test Streamer"},
+		Format:     config.TargetFormatDiscord,
+		Attempt:    1,
+	})
+
+	assert.False(t, result.Success)
+	assert.Equal(t, http.StatusTooManyRequests, result.StatusCode)
+	assert.Equal(t, 1500*time.Millisecond, result.RetryAfter)
+}
@@ -4,12 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
 	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/rmoriz/itsjustintv/pkg/standardwebhooks"
 )
 
 // Dispatcher handles webhook dispatching with retry logic
@@ -18,6 +23,16 @@ type Dispatcher struct {
 	logger     *slog.Logger
 	httpClient *http.Client
 	validator  *Validator
+	circuits   *circuitBreakers
+
+	// IDs of go-live messages and posts by "streamer_key/target_name", to edit or delete them on stream.offline
+	sentMessages      map[string]string
+	sentMessagesFile  string // persists sentMessages when set
+	sentMessagesMutex sync.Mutex
+
+	// Bluesky sessions by PDS URL and handle, as createSession is rate limited per account
+	blueskySessions      map[string]*blueskySession
+	blueskySessionsMutex sync.Mutex
 }
 
 // NewDispatcher creates a new webhook dispatcher
@@ -28,7 +43,10 @@ func NewDispatcher(cfg *config.Config, logger *slog.Logger) *Dispatcher {
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		validator: NewValidator(""), // Will be set per webhook
+		validator:       NewValidator(""), // Will be set per webhook
+		circuits:        newCircuitBreakers(),
+		sentMessages:    make(map[string]string),
+		blueskySessions: make(map[string]*blueskySession),
 	}
 }
 
@@ -46,8 +64,29 @@ type WebhookPayload struct {
 	Image          *ImageData `json:"image,omitempty"`
 	Timestamp      time.Time  `json:"timestamp"`
 	AdditionalTags []string   `json:"additional_tags,omitempty"`
+
+	// Stream lifecycle fields
+	EventType       string     `json:"event_type,omitempty"` // "stream.online", "stream.offline" or "channel.update"
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	EndedAt         *time.Time `json:"ended_at,omitempty"`
+	DurationSeconds int64      `json:"duration_seconds,omitempty"`
+
+	// Channel update fields
+	Title                string   `json:"title,omitempty"`
+	CategoryID           string   `json:"category_id,omitempty"`
+	CategoryName         string   `json:"category_name,omitempty"`
+	Changes              []string `json:"changes,omitempty"` // "title_changed", "category_changed"
+	PreviousTitle        string   `json:"previous_title,omitempty"`
+	PreviousCategoryName string   `json:"previous_category_name,omitempty"`
 }
 
+// Payload event types
+const (
+	eventTypeStreamOnline  = "stream.online"
+	eventTypeStreamOffline = "stream.offline"
+	eventTypeChannelUpdate = "channel.update"
+)
+
 // ImageData represents profile image data
 type ImageData struct {
 	URL    string `json:"url"`
@@ -56,16 +95,124 @@ type ImageData struct {
 	Data   string `json:"data,omitempty"` // Base64 encoded image data
 }
 
-// DispatchRequest represents a webhook dispatch request
+// DispatchRequest represents a webhook dispatch request. Only the event and the streamer and target
+// names are persisted in the retry queue and dead letters; the delivery settings and credentials are
+// taken from the current configuration of the target, see ResolveTarget.
 type DispatchRequest struct {
-	WebhookURL     string         `json:"webhook_url"`
-	Payload        WebhookPayload `json:"payload"`
-	WebhookSecret  string         `json:"webhook_secret,omitempty"`
-	WebhookHeader  string         `json:"webhook_header,omitempty"`
-	WebhookHashing string         `json:"webhook_hashing,omitempty"`
-	StreamerKey    string         `json:"streamer_key"`
-	Attempt        int            `json:"attempt"`
-	NextRetry      time.Time      `json:"next_retry,omitempty"`
+	Payload     WebhookPayload  `json:"payload"`
+	StreamerKey string          `json:"streamer_key"`
+	TargetName  string          `json:"target_name,omitempty"`
+	MessageID   string          `json:"message_id,omitempty"` // EventSub message the payload was created from
+	Attempt     int             `json:"attempt"`
+	NextRetry   time.Time       `json:"next_retry,omitempty"`
+	RetryID     string          `json:"retry_id,omitempty"` // identifies the request in the retry queue
+	History     []AttemptRecord `json:"history,omitempty"`
+
+	// Delivery settings of the target
+	WebhookURL        string            `json:"-"`
+	WebhookSecret     string            `json:"-"`
+	WebhookHeader     string            `json:"-"`
+	WebhookHashing    string            `json:"-"`
+	SigningScheme     string            `json:"-"`
+	PreviousSecrets   []string          `json:"-"`
+	BearerToken       string            `json:"-"`
+	BasicAuthUsername string            `json:"-"`
+	BasicAuthPassword string            `json:"-"`
+	Headers           map[string]string `json:"-"`
+	Template          string            `json:"-"`
+	ContentType       string            `json:"-"`
+	Format            string            `json:"-"`
+	EditOnOffline     bool              `json:"-"`
+	RoomID            string            `json:"-"`
+	AccessToken       string            `json:"-"`
+	Topic             string            `json:"-"`
+	ChatID            string            `json:"-"`
+	BotToken          string            `json:"-"`
+	DeleteOnOffline   bool              `json:"-"`
+	Visibility        string            `json:"-"`
+	Handle            string            `json:"-"`
+	AppPassword       string            `json:"-"`
+	CloudEventsMode   string            `json:"-"`
+}
+
+// AttemptRecord is the outcome of one delivery attempt of a request
+type AttemptRecord struct {
+	Attempt      int           `json:"attempt"`
+	Time         time.Time     `json:"time"`
+	Success      bool          `json:"success"`
+	StatusCode   int           `json:"status_code,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	FailureClass FailureClass  `json:"failure_class,omitempty"`
+	ResponseTime time.Duration `json:"response_time"`
+}
+
+// recordAttempt appends the outcome of an attempt started at start to the request's history
+func (req *DispatchRequest) recordAttempt(start time.Time, result *DispatchResult) {
+	req.History = append(req.History, AttemptRecord{
+		Attempt:      req.Attempt,
+		Time:         start,
+		Success:      result.Success,
+		StatusCode:   result.StatusCode,
+		Error:        result.Error,
+		FailureClass: result.FailureClass,
+		ResponseTime: result.ResponseTime,
+	})
+}
+
+// ErrTargetNotConfigured is returned when the target of a queued or dead-lettered request was
+// removed from the configuration
+var ErrTargetNotConfigured = errors.New("target is no longer configured")
+
+// NewDispatchRequest creates the first attempt to deliver a payload to a target
+func NewDispatchRequest(streamerKey string, target config.WebhookTargetConfig, payload WebhookPayload, messageID string) *DispatchRequest {
+	req := &DispatchRequest{
+		Payload:     payload,
+		StreamerKey: streamerKey,
+		MessageID:   messageID,
+		Attempt:     1,
+	}
+	req.applyTarget(target)
+	return req
+}
+
+// applyTarget sets the delivery settings of the request from its target
+func (req *DispatchRequest) applyTarget(target config.WebhookTargetConfig) {
+	req.TargetName = target.Name
+	req.WebhookURL = target.URL
+	req.WebhookSecret = target.Secret
+	req.WebhookHeader = target.Header
+	req.WebhookHashing = target.Hashing
+	req.SigningScheme = target.SigningScheme
+	req.PreviousSecrets = target.PreviousSecrets
+	req.BearerToken = target.BearerToken
+	req.BasicAuthUsername = target.BasicAuthUsername
+	req.BasicAuthPassword = target.BasicAuthPassword
+	req.Headers = target.Headers
+	req.Template = target.Template
+	req.ContentType = target.ContentType
+	req.Format = target.Format
+	req.EditOnOffline = target.EditOnOffline
+	req.RoomID = target.RoomID
+	req.AccessToken = target.AccessToken
+	req.Topic = target.Topic
+	req.ChatID = target.ChatID
+	req.BotToken = target.BotToken
+	req.DeleteOnOffline = target.DeleteOnOffline
+	req.Visibility = target.Visibility
+	req.Handle = target.Handle
+	req.AppPassword = target.AppPassword
+	req.CloudEventsMode = target.CloudEventsMode
+}
+
+// ResolveTarget sets the delivery settings of a queued or dead-lettered request from the current
+// configuration of its target, so that changed URLs and rotated credentials are used
+func (d *Dispatcher) ResolveTarget(req *DispatchRequest) error {
+	target, ok := d.config.FindTarget(req.StreamerKey, req.TargetName)
+	if !ok {
+		return fmt.Errorf("%s/%s: %w", req.StreamerKey, req.TargetName, ErrTargetNotConfigured)
+	}
+	req.applyTarget(target)
+	return nil
 }
 
 // DispatchResult represents the result of a webhook dispatch
@@ -75,6 +222,8 @@ type DispatchResult struct {
 	Error        string        `json:"error,omitempty"`
 	ResponseTime time.Duration `json:"response_time"`
 	Attempt      int           `json:"attempt"`
+	RetryAfter   time.Duration `json:"retry_after,omitempty"` // delay before retrying, as requested by the target
+	FailureClass FailureClass  `json:"failure_class,omitempty"`
 }
 
 // Dispatch sends a webhook with the given payload
@@ -84,88 +233,356 @@ func (d *Dispatcher) Dispatch(ctx context.Context, req *DispatchRequest) *Dispat
 	d.logger.Info("Dispatching webhook",
 		"webhook_url", req.WebhookURL,
 		"streamer_key", req.StreamerKey,
+		"target_name", req.TargetName,
 		"attempt", req.Attempt)
 
-	// Marshal payload to JSON
-	payloadBytes, err := json.Marshal(req.Payload)
+	payloadBytes, err := d.buildBody(req)
 	if err != nil {
-		return &DispatchResult{
+		result := &DispatchResult{
 			Success:      false,
-			Error:        fmt.Sprintf("failed to marshal payload: %v", err),
+			Error:        err.Error(),
 			ResponseTime: time.Since(start),
 			Attempt:      req.Attempt,
+			FailureClass: FailurePermanent,
 		}
+		req.recordAttempt(start, result)
+		return result
 	}
 
-	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", req.WebhookURL, bytes.NewBuffer(payloadBytes))
-	if err != nil {
+	// Defer requests to hosts that recently kept failing instead of waiting for another timeout
+	host := circuitHost(req.WebhookURL)
+	if delay, ok := d.circuits.allow(host, d.config.CircuitBreaker, start); !ok {
+		d.logger.Info("Webhook deferred, circuit breaker open",
+			"webhook_url", req.WebhookURL,
+			"streamer_key", req.StreamerKey,
+			"target_name", req.TargetName,
+			"host", host,
+			"retry_after", delay)
 		return &DispatchResult{
 			Success:      false,
-			Error:        fmt.Sprintf("failed to create request: %v", err),
+			Error:        fmt.Sprintf("circuit breaker open for %s", host),
 			ResponseTime: time.Since(start),
 			Attempt:      req.Attempt,
+			RetryAfter:   delay,
+			FailureClass: FailureCircuitOpen,
 		}
 	}
 
-	// Set headers
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("User-Agent", "itsjustintv/1.6")
+	var result *DispatchResult
+	switch req.Format {
+	case config.TargetFormatDiscord:
+		result = d.sendDiscord(ctx, req, payloadBytes)
+	case config.TargetFormatMatrix:
+		result = d.sendMatrix(ctx, req, payloadBytes)
+	case config.TargetFormatNtfy:
+		result = d.sendNtfy(ctx, req, payloadBytes)
+	case config.TargetFormatTelegram:
+		result = d.sendTelegram(ctx, req, payloadBytes)
+	case config.TargetFormatMastodon:
+		result = d.sendMastodon(ctx, req, string(payloadBytes))
+	case config.TargetFormatBluesky:
+		result = d.sendBluesky(ctx, req, string(payloadBytes))
+	case config.TargetFormatCloudEvents:
+		result = d.sendCloudEvent(ctx, req, payloadBytes)
+	default:
+		result = d.post(ctx, req.WebhookURL, payloadBytes, req.ContentType, req.credentials())
+	}
+	result.Attempt = req.Attempt
+	result.ResponseTime = time.Since(start)
+	if !result.Success && result.FailureClass == "" {
+		// Failures before any request was sent come from the target configuration
+		result.FailureClass = FailurePermanent
+		if result.StatusCode != 0 {
+			result.FailureClass = classifyStatus(result.StatusCode)
+		}
+	}
+	req.recordAttempt(start, result)
+	d.recordCircuit(host, result)
+	if result.StatusCode == 0 {
+		// The request never reached the target
+		return result
+	}
 
-	// Add HMAC signature if secret is provided
-	if req.WebhookSecret != "" {
-		header := req.WebhookHeader
-		if header == "" {
-			header = "X-Hub-Signature-256" // Default header
+	d.logger.Info("Webhook dispatch completed",
+		"webhook_url", req.WebhookURL,
+		"streamer_key", req.StreamerKey,
+		"target_name", req.TargetName,
+		"attempt", req.Attempt,
+		"success", result.Success,
+		"status_code", result.StatusCode,
+		"failure_class", result.FailureClass,
+		"response_time", result.ResponseTime)
+
+	return result
+}
+
+// recordCircuit updates the circuit breaker of host with the result of a request and logs when its
+// state changes
+func (d *Dispatcher) recordCircuit(host string, result *DispatchResult) {
+	previous, state := d.circuits.record(host, outcomeOf(result), d.config.CircuitBreaker, time.Now())
+	switch {
+	case previous == state:
+	case state == CircuitOpen:
+		d.logger.Warn("Circuit breaker opened, deferring requests to host",
+			"host", host,
+			"open_timeout", d.config.CircuitBreaker.OpenTimeout,
+			"error", result.Error)
+	case state == CircuitClosed:
+		d.logger.Info("Circuit breaker closed, host recovered", "host", host)
+	}
+}
+
+// CircuitBreakers returns the circuit breaker state of every target host requests were sent to
+func (d *Dispatcher) CircuitBreakers() []CircuitStatus {
+	return d.circuits.statuses(time.Now())
+}
+
+// buildBody returns the request body: the text of a social post, the message of the target's format,
+// the rendered template if the target has one, or otherwise the JSON payload
+func (d *Dispatcher) buildBody(req *DispatchRequest) ([]byte, error) {
+	if config.IsSocialTargetFormat(req.Format) {
+		text, err := d.socialPostText(req)
+		if err != nil {
+			return nil, err
 		}
+		return []byte(text), nil
+	}
 
-		hashing := req.WebhookHashing
-		if hashing == "" {
-			hashing = "SHA-256" // Default hashing
+	if formatter, ok := messageFormatters[req.Format]; ok {
+		body, err := json.Marshal(formatter(req))
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s message: %w", req.Format, err)
 		}
+		return body, nil
+	}
+
+	if req.Template == "" {
+		payloadBytes, err := json.Marshal(req.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		return payloadBytes, nil
+	}
+
+	return d.renderTemplate(req)
+}
+
+// renderTemplate renders the payload template of a request
+func (d *Dispatcher) renderTemplate(req *DispatchRequest) ([]byte, error) {
+	data := TemplateData{
+		Payload:     req.Payload,
+		Streamer:    d.config.Streamers[req.StreamerKey],
+		StreamerKey: req.StreamerKey,
+		Target:      req.TargetName,
+	}
+	body, err := RenderPayloadTemplate(req.TargetName, req.Template, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render payload template: %w", err)
+	}
+	return body, nil
+}
+
+// AlertPayload represents an operator alert sent to the alert webhook
+type AlertPayload struct {
+	Alert             string    `json:"alert"`    // e.g. "subscription_revoked"
+	Severity          string    `json:"severity"` // "warning" or "critical"
+	Message           string    `json:"message"`
+	Reaction          string    `json:"reaction,omitempty"` // what itsjustintv did about it
+	StreamerKey       string    `json:"streamer_key,omitempty"`
+	BroadcasterUserID string    `json:"broadcaster_user_id,omitempty"`
+	SubscriptionID    string    `json:"subscription_id,omitempty"`
+	SubscriptionType  string    `json:"subscription_type,omitempty"`
+	Status            string    `json:"status,omitempty"`
+	Error             string    `json:"error,omitempty"`
+	Timestamp         time.Time `json:"timestamp"`
+}
 
-		validator := NewValidator(req.WebhookSecret)
-		signature := validator.GenerateSignature(payloadBytes, hashing)
-		httpReq.Header.Set(header, signature)
+// DispatchAlert sends an operator alert to the alert webhook; it returns nil when alerts are disabled
+func (d *Dispatcher) DispatchAlert(ctx context.Context, alert AlertPayload) *DispatchResult {
+	alertConfig := d.config.AlertWebhook
+	if !alertConfig.Enabled || alertConfig.URL == "" {
+		return nil
+	}
+
+	start := time.Now()
+
+	d.logger.Info("Dispatching operator alert",
+		"webhook_url", alertConfig.URL,
+		"alert", alert.Alert)
+
+	payloadBytes, err := json.Marshal(alert)
+	if err != nil {
+		return &DispatchResult{
+			Success:      false,
+			Error:        fmt.Sprintf("failed to marshal alert: %v", err),
+			ResponseTime: time.Since(start),
+			Attempt:      1,
+		}
+	}
+
+	result := d.post(ctx, alertConfig.URL, payloadBytes, "", credentials{
+		secret:  alertConfig.TargetWebhookSecret,
+		header:  alertConfig.TargetWebhookHeader,
+		hashing: alertConfig.TargetWebhookHashing,
+	})
+	result.Attempt = 1
+	result.ResponseTime = time.Since(start)
+	return result
+}
+
+// credentials describes how an outgoing request authenticates: static headers, HTTP authorization
+// and the signature of the body
+type credentials struct {
+	headers           map[string]string
+	bearerToken       string
+	basicAuthUsername string
+	basicAuthPassword string
+
+	scheme          string // config.SigningSchemeHMAC (default) or config.SigningSchemeStandardWebhooks
+	secret          string // no signature when empty
+	previousSecrets []string
+	header          string
+	hashing         string
+	messageID       string // webhook-id of the standard-webhooks scheme
+}
+
+// credentials returns how requests to the request's target authenticate
+func (req *DispatchRequest) credentials() credentials {
+	return credentials{
+		headers:           req.Headers,
+		bearerToken:       req.BearerToken,
+		basicAuthUsername: req.BasicAuthUsername,
+		basicAuthPassword: req.BasicAuthPassword,
+		scheme:            req.SigningScheme,
+		secret:            req.WebhookSecret,
+		previousSecrets:   req.PreviousSecrets,
+		header:            req.WebhookHeader,
+		hashing:           req.WebhookHashing,
+		messageID:         deliveryID(req),
+	}
+}
+
+// apply adds the static headers, the authorization and the signature of a body to a request
+func (c credentials) apply(httpReq *http.Request, body []byte) error {
+	for name, value := range c.headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	if c.bearerToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	} else if c.basicAuthUsername != "" {
+		httpReq.SetBasicAuth(c.basicAuthUsername, c.basicAuthPassword)
+	}
+
+	return c.sign(httpReq, body)
+}
+
+// sign adds the signature headers of a body to a request
+func (c credentials) sign(httpReq *http.Request, body []byte) error {
+	if c.secret == "" {
+		return nil
+	}
+
+	if c.scheme == config.SigningSchemeStandardWebhooks {
+		// The timestamp is the time of this attempt, so receivers can reject replays
+		secrets := append([]string{c.secret}, c.previousSecrets...)
+		return standardwebhooks.SignHeaders(httpReq.Header, secrets, c.messageID, time.Now(), body)
+	}
+
+	header := c.header
+	if header == "" {
+		header = "X-Hub-Signature-256" // Default header
+	}
+
+	hashing := c.hashing
+	if hashing == "" {
+		hashing = "SHA-256" // Default hashing
+	}
+
+	validator := NewValidator(c.secret)
+	httpReq.Header.Set(header, validator.GenerateSignature(body, hashing))
+	return nil
+}
+
+// post sends a body to a webhook URL with the given credentials. An empty content type sends the
+// body as JSON.
+func (d *Dispatcher) post(ctx context.Context, webhookURL string, body []byte, contentType string, creds credentials) *DispatchResult {
+	result, _ := d.send(ctx, http.MethodPost, webhookURL, body, contentType, creds, nil)
+	return result
+}
+
+// send sends a body to a webhook URL with the given method and additional headers, and returns the
+// result together with the response body
+func (d *Dispatcher) send(ctx context.Context, method, webhookURL string, body []byte, contentType string, creds credentials, extraHeaders http.Header) (*DispatchResult, []byte) {
+	// Create HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, method, webhookURL, bytes.NewBuffer(body))
+	if err != nil {
+		return &DispatchResult{
+			Success:      false,
+			Error:        fmt.Sprintf("failed to create request: %v", err),
+			FailureClass: FailurePermanent,
+		}, nil
+	}
+
+	// Set headers
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("User-Agent", "itsjustintv/1.6")
+	for name, values := range extraHeaders {
+		httpReq.Header[name] = values
+	}
+
+	// Add target headers, authorization and signature
+	if err := creds.apply(httpReq, body); err != nil {
+		return &DispatchResult{
+			Success:      false,
+			Error:        fmt.Sprintf("failed to sign request: %v", err),
+			FailureClass: FailurePermanent,
+		}, nil
 	}
 
 	// Send request
 	resp, err := d.httpClient.Do(httpReq)
 	if err != nil {
+		// Leave out the URL, which may contain a token, e.g. of Discord webhooks or Telegram bots
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
 		return &DispatchResult{
 			Success:      false,
 			Error:        fmt.Sprintf("request failed: %v", err),
-			ResponseTime: time.Since(start),
-			Attempt:      req.Attempt,
-		}
+			FailureClass: FailureNetwork,
+		}, nil
 	}
 	defer resp.Body.Close()
 
-	responseTime := time.Since(start)
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBodySize))
+
 	success := resp.StatusCode >= 200 && resp.StatusCode < 300
 
 	result := &DispatchResult{
-		Success:      success,
-		StatusCode:   resp.StatusCode,
-		ResponseTime: responseTime,
-		Attempt:      req.Attempt,
+		Success:    success,
+		StatusCode: resp.StatusCode,
 	}
 
 	if !success {
-		result.Error = fmt.Sprintf("HTTP %d", resp.StatusCode)
+		result.FailureClass = classifyStatus(resp.StatusCode)
+		result.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		result.Error = fmt.Sprintf("HTTP %d (%s)", resp.StatusCode, result.FailureClass)
+		if result.RetryAfter > 0 {
+			result.Error = fmt.Sprintf("HTTP %d (%s, retry after %s)", resp.StatusCode, result.FailureClass, result.RetryAfter)
+		}
 	}
 
-	d.logger.Info("Webhook dispatch completed",
-		"webhook_url", req.WebhookURL,
-		"streamer_key", req.StreamerKey,
-		"attempt", req.Attempt,
-		"success", success,
-		"status_code", resp.StatusCode,
-		"response_time", responseTime)
-
-	return result
+	return result, respBody
 }
 
+// maxResponseBodySize limits how much of a webhook response is read
+const maxResponseBodySize = 1 << 20
+
 // CreatePayload creates a webhook payload from stream event data
 func (d *Dispatcher) CreatePayload(streamerKey string, streamerConfig config.StreamerConfig, eventData map[string]interface{}) *WebhookPayload {
 	payload := &WebhookPayload{
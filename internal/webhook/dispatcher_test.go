@@ -3,14 +3,17 @@ package webhook
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/rmoriz/itsjustintv/pkg/standardwebhooks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -107,6 +110,91 @@ func TestDispatchWithHMAC(t *testing.T) {
 	assert.Equal(t, http.StatusOK, result.StatusCode)
 }
 
+func TestDispatchWithStandardWebhooksSigning(t *testing.T) {
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		headers = append(headers, r.Header.Clone())
+
+		// Receivers still on either secret accept the delivery
+		assert.NoError(t, standardwebhooks.Verify(r.Header, body, "whsec_bmV3X3NlY3JldA=="))
+		assert.NoError(t, standardwebhooks.Verify(r.Header, body, "old_secret"))
+		assert.Empty(t, r.Header.Get("X-Hub-Signature-256"))
+
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(cfg, logger)
+
+	req := &DispatchRequest{
+		WebhookURL:      server.URL,
+		Payload:         WebhookPayload{StreamerLogin: "teststreamer", EventType: "stream.online", Timestamp: time.Now()},
+		WebhookSecret:   "whsec_bmV3X3NlY3JldA==",
+		SigningScheme:   config.SigningSchemeStandardWebhooks,
+		PreviousSecrets: []string{"old_secret"},
+		StreamerKey:     "test_streamer",
+		TargetName:      "cms",
+		Attempt:         1,
+	}
+
+	dispatcher.Dispatch(context.Background(), req)
+	req.Attempt = 2
+	dispatcher.Dispatch(context.Background(), req)
+
+	require.Len(t, headers, 2)
+	assert.Len(t, strings.Fields(headers[0].Get(standardwebhooks.HeaderSignature)), 2)
+	// Retries keep the message ID so receivers can deduplicate them
+	assert.NotEmpty(t, headers[0].Get(standardwebhooks.HeaderID))
+	assert.Equal(t, headers[0].Get(standardwebhooks.HeaderID), headers[1].Get(standardwebhooks.HeaderID))
+}
+
+func TestDispatchWithHTTPAuthAndHeaders(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(cfg, logger)
+
+	payload := WebhookPayload{StreamerLogin: "teststreamer", Timestamp: time.Now()}
+
+	result := dispatcher.Dispatch(context.Background(), &DispatchRequest{
+		WebhookURL:    server.URL,
+		Payload:       payload,
+		WebhookSecret: "test_secret",
+		BearerToken:   "gateway_token",
+		Headers:       map[string]string{"X-Api-Version": "2"},
+		StreamerKey:   "test_streamer",
+		Attempt:       1,
+	})
+	require.True(t, result.Success)
+	assert.Equal(t, "Bearer gateway_token", received.Get("Authorization"))
+	assert.Equal(t, "2", received.Get("X-Api-Version"))
+	assert.Contains(t, received.Get("X-Hub-Signature-256"), "sha256=")
+
+	result = dispatcher.Dispatch(context.Background(), &DispatchRequest{
+		WebhookURL:        server.URL,
+		Payload:           payload,
+		BasicAuthUsername: "itsjustintv",
+		BasicAuthPassword: "n8n_password",
+		StreamerKey:       "test_streamer",
+		Attempt:           1,
+	})
+	require.True(t, result.Success)
+	httpReq := &http.Request{Header: received}
+	username, password, ok := httpReq.BasicAuth()
+	require.True(t, ok)
+	assert.Equal(t, "itsjustintv", username)
+	assert.Equal(t, "n8n_password", password)
+}
+
 func TestDispatchFailure(t *testing.T) {
 	// Create test server that returns error
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -171,6 +259,43 @@ func TestDispatchInvalidURL(t *testing.T) {
 	assert.Contains(t, result.Error, "request failed")
 }
 
+func TestDispatchAlert(t *testing.T) {
+	var received AlertPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEmpty(t, r.Header.Get("X-Alert-Signature"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(cfg, logger)
+
+	alert := AlertPayload{
+		Alert:          "subscription_revoked",
+		Severity:       "warning",
+		Message:        "subscription revoked",
+		SubscriptionID: "sub_123",
+		Timestamp:      time.Now().UTC(),
+	}
+
+	// Alerts are not sent unless enabled
+	assert.Nil(t, dispatcher.DispatchAlert(t.Context(), alert))
+
+	cfg.AlertWebhook.Enabled = true
+	cfg.AlertWebhook.URL = server.URL
+	cfg.AlertWebhook.TargetWebhookSecret = "alert-secret"
+	cfg.AlertWebhook.TargetWebhookHeader = "X-Alert-Signature"
+
+	result := dispatcher.DispatchAlert(t.Context(), alert)
+	require.NotNil(t, result)
+	assert.True(t, result.Success)
+	assert.Equal(t, http.StatusNoContent, result.StatusCode)
+	assert.Equal(t, "subscription_revoked", received.Alert)
+	assert.Equal(t, "sub_123", received.SubscriptionID)
+}
+
 func TestCreatePayload(t *testing.T) {
 	cfg := config.DefaultConfig()
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
@@ -254,3 +379,40 @@ func TestWebhookPayloadJSON(t *testing.T) {
 	assert.Equal(t, payload.Tags, unmarshaled.Tags)
 	assert.Equal(t, payload.AdditionalTags, unmarshaled.AdditionalTags)
 }
+
+func TestDispatchWithPayloadTemplate(t *testing.T) {
+	var body string
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		contentType = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Streamers["test_streamer"] = config.StreamerConfig{Login: "teststreamer", AdditionalTags: []string{"vip"}}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(cfg, logger)
+
+	req := &DispatchRequest{
+		WebhookURL:  server.URL,
+		Payload:     WebhookPayload{StreamerName: "Test \"Streamer\"", URL: "https://twitch.tv/teststreamer"},
+		StreamerKey: "test_streamer",
+		TargetName:  "cms",
+		Template:    `{"text":{{ json .Payload.StreamerName }},"link":"{{ .Payload.URL }}","tags":"{{ join .Streamer.AdditionalTags "," }}","target":"{{ .Target }}"}`,
+		ContentType: "application/vnd.cms+json",
+		Attempt:     1,
+	}
+
+	result := dispatcher.Dispatch(context.Background(), req)
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, "application/vnd.cms+json", contentType)
+	assert.JSONEq(t, `{"text":"Test \"Streamer\"","link":"https://twitch.tv/teststreamer","tags":"vip","target":"cms"}`, body)
+
+	req.Template = `{{ .Payload.NoSuchField }}`
+	result = dispatcher.Dispatch(context.Background(), req)
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error, "failed to render payload template")
+}
@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FailureClass classifies why a webhook dispatch failed
+type FailureClass string
+
+// Failure classes
+const (
+	FailureNetwork     FailureClass = "network"      // the target could not be reached or timed out
+	FailureServerError FailureClass = "server_error" // 5xx or an unusable response
+	FailureRateLimited FailureClass = "rate_limited" // 429
+	FailurePermanent   FailureClass = "permanent"    // other 4xx, or a request that cannot be built
+	FailureCircuitOpen FailureClass = "circuit_open" // not sent because the circuit breaker of the host is open
+)
+
+// Retryable reports whether a failed dispatch may succeed when retried. Permanent failures, such as a
+// 404 from a deleted webhook or an invalid target configuration, are not retried.
+func (r *DispatchResult) Retryable() bool {
+	return !r.Success && r.FailureClass != FailurePermanent
+}
+
+// Deferred reports whether the request was not sent at all and should be sent again after
+// RetryAfter without counting as an attempt
+func (r *DispatchResult) Deferred() bool {
+	return !r.Success && r.FailureClass == FailureCircuitOpen
+}
+
+// classifyStatus classifies a non-2xx HTTP status code
+func classifyStatus(statusCode int) FailureClass {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return FailureRateLimited
+	case statusCode == http.StatusRequestTimeout:
+		return FailureNetwork
+	case statusCode >= 500:
+		return FailureServerError
+	case statusCode >= 200 && statusCode < 300:
+		// A 2xx response the format could not use, e.g. without the expected message ID
+		return FailureServerError
+	}
+	return FailurePermanent
+}
+
+// parseRetryAfter returns the delay of a Retry-After header given in seconds or as an HTTP date,
+// or 0 when the header is missing or invalid
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
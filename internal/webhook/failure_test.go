@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		expected   FailureClass
+	}{
+		{http.StatusBadRequest, FailurePermanent},
+		{http.StatusUnauthorized, FailurePermanent},
+		{http.StatusNotFound, FailurePermanent},
+		{http.StatusGone, FailurePermanent},
+		{http.StatusRequestTimeout, FailureNetwork},
+		{http.StatusTooManyRequests, FailureRateLimited},
+		{http.StatusInternalServerError, FailureServerError},
+		{http.StatusServiceUnavailable, FailureServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.statusCode), func(t *testing.T) {
+			assert.Equal(t, tt.expected, classifyStatus(tt.statusCode))
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	assert.Zero(t, parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	assert.Zero(t, parseRetryAfter("", now))
+	assert.Zero(t, parseRetryAfter("-5", now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+}
+
+func TestDispatchFailureClasses(t *testing.T) {
+	status := http.StatusGone
+	retryAfter := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(cfg, logger)
+
+	dispatch := func(url string) *DispatchResult {
+		return dispatcher.Dispatch(context.Background(), &DispatchRequest{
+			WebhookURL:  url,
+			Payload:     WebhookPayload{StreamerLogin: "teststreamer", Timestamp: time.Now()},
+			StreamerKey: "test_streamer",
+			Attempt:     1,
+		})
+	}
+
+	result := dispatch(server.URL)
+	assert.Equal(t, FailurePermanent, result.FailureClass)
+	assert.False(t, result.Retryable())
+
+	status, retryAfter = http.StatusServiceUnavailable, "30"
+	result = dispatch(server.URL)
+	assert.Equal(t, FailureServerError, result.FailureClass)
+	assert.Equal(t, 30*time.Second, result.RetryAfter)
+	assert.Contains(t, result.Error, "retry after 30s")
+	assert.True(t, result.Retryable())
+
+	status, retryAfter = http.StatusTooManyRequests, ""
+	result = dispatch(server.URL)
+	assert.Equal(t, FailureRateLimited, result.FailureClass)
+	assert.True(t, result.Retryable())
+
+	// Nothing listens on a closed server
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	result = dispatch(closed.URL)
+	require.False(t, result.Success)
+	assert.Equal(t, FailureNetwork, result.FailureClass)
+	assert.True(t, result.Retryable())
+}
@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+)
+
+// messageFormatters render a request's payload as the message body of a chat or push target
+// format. Formats with their own delivery rules are sent by Dispatch through dedicated functions.
+var messageFormatters = map[string]func(*DispatchRequest) interface{}{
+	config.TargetFormatDiscord:  func(req *DispatchRequest) interface{} { return newDiscordMessage(req.Payload) },
+	config.TargetFormatSlack:    func(req *DispatchRequest) interface{} { return newSlackMessage(req.Payload) },
+	config.TargetFormatTeams:    func(req *DispatchRequest) interface{} { return newTeamsMessage(req.Payload) },
+	config.TargetFormatMatrix:   func(req *DispatchRequest) interface{} { return newMatrixMessage(req.Payload) },
+	config.TargetFormatNtfy:     func(req *DispatchRequest) interface{} { return newNtfyMessage(req.Topic, req.Payload) },
+	config.TargetFormatTelegram: func(req *DispatchRequest) interface{} { return newTelegramMessage(req.ChatID, req.Payload, true) },
+	config.TargetFormatCloudEvents: func(req *DispatchRequest) interface{} {
+		if req.CloudEventsMode == config.CloudEventsModeBinary {
+			return req.Payload
+		}
+		return newCloudEvent(req)
+	},
+}
+
+// Notification colors
+const (
+	colorLive    = 0x9146FF // Twitch purple
+	colorOffline = 0x747F8D
+	colorUpdate  = 0x3498DB
+)
+
+// notification is the format-independent summary of a payload that chat formats render
+type notification struct {
+	Title       string
+	Description string
+	URL         string
+	ImageURL    string
+	Color       int
+	Fields      []notificationField // only fields with a value
+	Timestamp   time.Time
+}
+
+// notificationField is a labelled value of a notification
+type notificationField struct {
+	Name   string
+	Value  string
+	Inline bool // short enough to be shown next to other fields
+}
+
+// newNotification summarizes a webhook payload for a chat message
+func newNotification(payload WebhookPayload) notification {
+	n := notification{
+		URL:       payload.URL,
+		Timestamp: payload.Timestamp.UTC(),
+	}
+	if payload.Image != nil {
+		n.ImageURL = payload.Image.URL
+	}
+
+	switch payload.EventType {
+	case eventTypeStreamOffline:
+		n.Title = fmt.Sprintf("%s was live on Twitch", payload.StreamerName)
+		n.Color = colorOffline
+		if payload.DurationSeconds > 0 {
+			n.Description = fmt.Sprintf("Streamed for %s", formatDuration(time.Duration(payload.DurationSeconds)*time.Second))
+		} else {
+			n.Description = "The stream has ended"
+		}
+		if payload.EndedAt != nil {
+			n.Timestamp = payload.EndedAt.UTC()
+		}
+	case eventTypeChannelUpdate:
+		n.Title = fmt.Sprintf("%s updated the stream", payload.StreamerName)
+		n.Color = colorUpdate
+		n.Description = payload.Title
+		for _, change := range payload.Changes {
+			switch change {
+			case "title_changed":
+				n.addField("Previous title", payload.PreviousTitle, false)
+			case "category_changed":
+				n.addField("Game", payload.CategoryName, true)
+				n.addField("Previous game", payload.PreviousCategoryName, true)
+			}
+		}
+	default:
+		n.Title = fmt.Sprintf("%s is live on Twitch", payload.StreamerName)
+		n.Color = colorLive
+		n.Description = payload.Title
+		n.addField("Game", payload.CategoryName, true)
+		n.addField("Tags", strings.Join(payload.Tags, ", "), true)
+		if payload.StartedAt != nil {
+			n.Timestamp = payload.StartedAt.UTC()
+		}
+	}
+
+	return n
+}
+
+// addField adds a field to the notification unless its value is empty
+func (n *notification) addField(name, value string, inline bool) {
+	if value != "" {
+		n.Fields = append(n.Fields, notificationField{Name: name, Value: value, Inline: inline})
+	}
+}
+
+// deliveryID derives a stable ID for delivering a payload to a target, shared by all of its retries.
+// Targets that support it use the ID to deduplicate retried requests.
+func deliveryID(req *DispatchRequest) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n%s", req.StreamerKey, req.TargetName, req.Payload.EventType, req.Payload.Timestamp.UTC().Format(time.RFC3339Nano))
+	return "itsjustintv-" + hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+// formatDuration formats a stream duration as e.g. "2h 13m"
+func formatDuration(duration time.Duration) string {
+	hours := int(duration.Hours())
+	minutes := int(duration.Minutes()) % 60
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
+
+// truncate shortens a string to at most limit runes, marking the cut with an ellipsis
+func truncate(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+	return string(runes[:limit-1]) + "…"
+}
@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MastodonStatus is the body of a Mastodon create status request
+type MastodonStatus struct {
+	Status     string `json:"status"`
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// sendMastodon posts a go-live status to a Mastodon instance and deletes it on stream.offline
+// when the target asks for it. Retries reuse the idempotency key, so a status is not posted twice.
+func (d *Dispatcher) sendMastodon(ctx context.Context, req *DispatchRequest, text string) *DispatchResult {
+	instanceURL := strings.TrimSuffix(req.WebhookURL, "/")
+
+	switch req.Payload.EventType {
+	case "", eventTypeStreamOnline:
+	case eventTypeStreamOffline:
+		if !req.DeleteOnOffline {
+			return d.skipSocialEvent(req)
+		}
+		statusID, ok := d.sentMessage(req)
+		if !ok {
+			d.warnUnknownSentMessage(req)
+			return d.skipSocialEvent(req)
+		}
+
+		result, _ := d.callAPI(ctx, http.MethodDelete, instanceURL+"/api/v1/statuses/"+url.PathEscape(statusID), nil, bearerAuth(req.AccessToken))
+		if result.StatusCode == http.StatusNotFound {
+			// Already deleted
+			result.Success = true
+			result.Error = ""
+		}
+		if result.Success {
+			d.forgetSentMessage(req)
+		}
+		return result
+	default:
+		return d.skipSocialEvent(req)
+	}
+
+	headers := bearerAuth(req.AccessToken)
+	headers.Set("Idempotency-Key", deliveryID(req))
+
+	status := MastodonStatus{Status: text, Visibility: req.Visibility}
+	result, respBody := d.callAPI(ctx, http.MethodPost, instanceURL+"/api/v1/statuses", status, headers)
+	if result.Success && req.DeleteOnOffline {
+		var created struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(respBody, &created); err == nil && created.ID != "" {
+			d.rememberSentMessage(req, created.ID)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchMastodonPostsAndDeletes(t *testing.T) {
+	var requests []string
+	var status MastodonStatus
+	var idempotencyKeys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer mastodon_token", r.Header.Get("Authorization"))
+		assert.Empty(t, r.Header.Get("X-Api-Key"), "generic target headers are not sent to the API")
+		assert.Empty(t, r.Header.Get("X-Signature"), "API calls are not signed")
+		requests = append(requests, r.Method+" "+r.URL.Path)
+
+		if r.Method == http.MethodPost {
+			idempotencyKeys = append(idempotencyKeys, r.Header.Get("Idempotency-Key"))
+			body, _ := io.ReadAll(r.Body)
+			require.NoError(t, json.Unmarshal(body, &status))
+			w.Write([]byte(`{"id":"109"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Streamers["test_streamer"] = config.StreamerConfig{Login: "teststreamer"}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(cfg, logger)
+
+	req := &DispatchRequest{
+		WebhookURL: server.URL + "/",
+		Payload: WebhookPayload{
+			StreamerName: "Test Streamer",
+			URL:          "https://twitch.tv/teststreamer",
+			Title:        "Speedruns",
+			EventType:    eventTypeStreamOnline,
+		},
+		StreamerKey:     "test_streamer",
+		TargetName:      "mastodon",
+		Format:          config.TargetFormatMastodon,
+		Template:        `We're live! {{ .Payload.Title }} {{ .Payload.URL }} #{{ .Streamer.Login }}`,
+		AccessToken:     "mastodon_token",
+		Visibility:      "unlisted",
+		DeleteOnOffline: true,
+		Attempt:         1,
+
+		// Generic auth settings, e.g. of a retry queued before validation rejected them
+		BearerToken:   "generic_token",
+		WebhookSecret: "secret",
+		WebhookHeader: "X-Signature",
+		Headers:       map[string]string{"X-Api-Key": "key"},
+	}
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+	assert.Equal(t, MastodonStatus{Status: "We're live! Speedruns https://twitch.tv/teststreamer #teststreamer", Visibility: "unlisted"}, status)
+	assert.Equal(t, []string{deliveryID(req)}, idempotencyKeys)
+
+	// channel.update is not posted
+	update := *req
+	update.Payload.EventType = eventTypeChannelUpdate
+	require.True(t, dispatcher.Dispatch(context.Background(), &update).Success)
+
+	offline := *req
+	offline.Payload.EventType = eventTypeStreamOffline
+	require.True(t, dispatcher.Dispatch(context.Background(), &offline).Success)
+
+	assert.Equal(t, []string{"POST /api/v1/statuses", "DELETE /api/v1/statuses/109"}, requests)
+	assert.Empty(t, dispatcher.sentMessages)
+}
+
+func TestSocialPostTextDefault(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+
+	req := &DispatchRequest{
+		Payload: WebhookPayload{
+			StreamerName: "Test Streamer",
+			URL:          "https://twitch.tv/teststreamer",
+			Title:        strings.Repeat("very long title ", 40),
+			EventType:    eventTypeStreamOnline,
+		},
+		Format: config.TargetFormatBluesky,
+	}
+
+	text, err := dispatcher.socialPostText(req)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len([]rune(text)), blueskyPostLimit)
+	assert.True(t, strings.HasPrefix(text, "Test Streamer is live on Twitch: very long title"))
+	assert.True(t, strings.HasSuffix(text, "…\n\nhttps://twitch.tv/teststreamer"))
+}
@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MatrixMessage is the content of an m.room.message event
+type MatrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+// newMatrixMessage renders a webhook payload as a Matrix notice with a plain and an HTML body
+func newMatrixMessage(payload WebhookPayload) MatrixMessage {
+	n := newNotification(payload)
+
+	plain := []string{n.Title}
+	formatted := []string{fmt.Sprintf(`<strong><a href="%s">%s</a></strong>`, html.EscapeString(n.URL), html.EscapeString(n.Title))}
+	if n.Description != "" {
+		plain = append(plain, n.Description)
+		formatted = append(formatted, html.EscapeString(n.Description))
+	}
+	for _, field := range n.Fields {
+		plain = append(plain, fmt.Sprintf("%s: %s", field.Name, field.Value))
+		formatted = append(formatted, fmt.Sprintf("<em>%s:</em> %s", html.EscapeString(field.Name), html.EscapeString(field.Value)))
+	}
+	plain = append(plain, n.URL)
+
+	return MatrixMessage{
+		MsgType:       "m.notice",
+		Body:          strings.Join(plain, "\n"),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: strings.Join(formatted, "<br>"),
+	}
+}
+
+// sendMatrix sends a message event to a Matrix room through the client-server API. The
+// transaction ID is derived from the event so retries are deduplicated by the homeserver.
+func (d *Dispatcher) sendMatrix(ctx context.Context, req *DispatchRequest, body []byte) *DispatchResult {
+	sendURL, err := matrixSendURL(req.WebhookURL, req.RoomID, deliveryID(req))
+	if err != nil {
+		return &DispatchResult{Success: false, Error: err.Error()}
+	}
+
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer "+req.AccessToken)
+
+	result, _ := d.send(ctx, http.MethodPut, sendURL, body, "", req.credentials(), headers)
+	return result
+}
+
+// matrixSendURL returns the URL that sends an m.room.message event to a room of a homeserver
+func matrixSendURL(homeserverURL, roomID, txnID string) (string, error) {
+	u, err := url.Parse(homeserverURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid matrix homeserver URL: %w", err)
+	}
+	path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.room.message/%s", url.PathEscape(roomID), url.PathEscape(txnID))
+	u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + path
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	return u.String(), nil
+}
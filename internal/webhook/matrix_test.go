@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchMatrix(t *testing.T) {
+	var paths []string
+	var message MatrixMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "Bearer matrix_token", r.Header.Get("Authorization"))
+		paths = append(paths, r.URL.EscapedPath())
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &message))
+		w.Write([]byte(`{"event_id":"$event"}`))
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+
+	req := &DispatchRequest{
+		WebhookURL: server.URL,
+		Payload: WebhookPayload{
+			StreamerName: "Test Streamer",
+			URL:          "https://twitch.tv/teststreamer",
+			Title:        "<b>Speedrun</b>",
+			Timestamp:    time.Date(2026, 10, 16, 18, 0, 0, 0, time.UTC),
+			EventType:    eventTypeStreamOnline,
+		},
+		StreamerKey: "test_streamer",
+		TargetName:  "matrix",
+		Format:      config.TargetFormatMatrix,
+		RoomID:      "!room/id:example.org",
+		AccessToken: "matrix_token",
+		Attempt:     1,
+	}
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+
+	// A retry reuses the transaction ID
+	req.Attempt = 2
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+
+	require.Len(t, paths, 2)
+	assert.Equal(t, "/_matrix/client/v3/rooms/%21room%2Fid:example.org/send/m.room.message/"+deliveryID(req), paths[0])
+	assert.Equal(t, paths[0], paths[1])
+
+	assert.Equal(t, "m.notice", message.MsgType)
+	assert.Equal(t, "Test Streamer is live on Twitch\n<b>Speedrun</b>\nhttps://twitch.tv/teststreamer", message.Body)
+	assert.Contains(t, message.FormattedBody, "&lt;b&gt;Speedrun&lt;/b&gt;")
+}
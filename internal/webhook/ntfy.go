@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// NtfyMessage is a JSON publish request to an ntfy server
+type NtfyMessage struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title"`
+	Message  string   `json:"message"`
+	Tags     []string `json:"tags,omitempty"` // emoji shortcodes are shown as emojis, other tags as text
+	Priority int      `json:"priority,omitempty"`
+	Click    string   `json:"click,omitempty"`
+	Attach   string   `json:"attach,omitempty"`
+}
+
+// ntfy priorities
+const ntfyPriorityHigh = 4
+
+// newNtfyMessage renders a webhook payload as an ntfy publish request to the given topic
+func newNtfyMessage(topic string, payload WebhookPayload) NtfyMessage {
+	n := newNotification(payload)
+
+	lines := make([]string, 0, len(n.Fields)+1)
+	if n.Description != "" {
+		lines = append(lines, n.Description)
+	}
+	for _, field := range n.Fields {
+		lines = append(lines, field.Name+": "+field.Value)
+	}
+
+	message := NtfyMessage{
+		Topic:   topic,
+		Title:   n.Title,
+		Message: strings.Join(lines, "\n"),
+		Click:   n.URL,
+		Attach:  n.ImageURL,
+	}
+	if message.Message == "" {
+		message.Message = n.Title
+	}
+
+	switch payload.EventType {
+	case eventTypeStreamOffline:
+		message.Tags = []string{"zzz"}
+	case eventTypeChannelUpdate:
+		message.Tags = []string{"pencil2"}
+	default:
+		message.Tags = append([]string{"red_circle"}, payload.Tags...)
+		message.Priority = ntfyPriorityHigh
+	}
+
+	return message
+}
+
+// sendNtfy publishes a message to the root URL of an ntfy server, authenticating with the
+// target's access token if it has one
+func (d *Dispatcher) sendNtfy(ctx context.Context, req *DispatchRequest, body []byte) *DispatchResult {
+	var headers http.Header
+	if req.AccessToken != "" {
+		headers = http.Header{}
+		headers.Set("Authorization", "Bearer "+req.AccessToken)
+	}
+
+	result, _ := d.send(ctx, http.MethodPost, req.WebhookURL, body, "", req.credentials(), headers)
+	return result
+}
@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchNtfy(t *testing.T) {
+	var message NtfyMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/", r.URL.Path)
+		assert.Equal(t, "Bearer tk_ntfy", r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &message))
+		w.Write([]byte(`{"id":"abc","event":"message"}`))
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+
+	result := dispatcher.Dispatch(context.Background(), &DispatchRequest{
+		WebhookURL: server.URL + "/",
+		Payload: WebhookPayload{
+			StreamerName: "Test Streamer",
+			URL:          "https://twitch.tv/teststreamer",
+			Title:        "Late night chill",
+			CategoryName: "Just Chatting",
+			Tags:         []string{"English"},
+			Image:        &ImageData{URL: "https://static-cdn.jtvnw.net/profile.png"},
+			EventType:    eventTypeStreamOnline,
+		},
+		Format:      config.TargetFormatNtfy,
+		Topic:       "streams",
+		AccessToken: "tk_ntfy",
+		Attempt:     1,
+	})
+	require.True(t, result.Success, result.Error)
+
+	assert.Equal(t, NtfyMessage{
+		Topic:    "streams",
+		Title:    "Test Streamer is live on Twitch",
+		Message:  "Late night chill\nGame: Just Chatting\nTags: English",
+		Tags:     []string{"red_circle", "English"},
+		Priority: ntfyPriorityHigh,
+		Click:    "https://twitch.tv/teststreamer",
+		Attach:   "https://static-cdn.jtvnw.net/profile.png",
+	}, message)
+}
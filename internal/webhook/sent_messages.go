@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rmoriz/itsjustintv/internal/atomicfile"
+)
+
+// sentMessageKey identifies the go-live message or post of a streamer at a target
+func sentMessageKey(req *DispatchRequest) string {
+	return req.StreamerKey + "/" + req.TargetName
+}
+
+// LoadSentMessages loads the remembered go-live message and post IDs from path and persists every
+// change to them there, so messages sent before a restart are still edited or deleted on
+// stream.offline. Without it the IDs are only kept in memory.
+func (d *Dispatcher) LoadSentMessages(path string) error {
+	d.sentMessagesMutex.Lock()
+	defer d.sentMessagesMutex.Unlock()
+
+	d.sentMessagesFile = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read sent messages file: %w", err)
+	}
+
+	sentMessages := make(map[string]string)
+	if err := json.Unmarshal(data, &sentMessages); err != nil {
+		return fmt.Errorf("failed to parse sent messages file: %w", err)
+	}
+	for key, id := range sentMessages {
+		d.sentMessages[key] = id
+	}
+	return nil
+}
+
+// rememberSentMessage remembers the ID of a go-live message or post sent to a target
+func (d *Dispatcher) rememberSentMessage(req *DispatchRequest, id string) {
+	d.sentMessagesMutex.Lock()
+	defer d.sentMessagesMutex.Unlock()
+	d.sentMessages[sentMessageKey(req)] = id
+	d.saveSentMessages()
+}
+
+// sentMessage returns the ID of the go-live message or post sent to a target, if known
+func (d *Dispatcher) sentMessage(req *DispatchRequest) (string, bool) {
+	d.sentMessagesMutex.Lock()
+	defer d.sentMessagesMutex.Unlock()
+	id, ok := d.sentMessages[sentMessageKey(req)]
+	return id, ok
+}
+
+// forgetSentMessage removes a remembered go-live message or post ID
+func (d *Dispatcher) forgetSentMessage(req *DispatchRequest) {
+	d.sentMessagesMutex.Lock()
+	defer d.sentMessagesMutex.Unlock()
+	delete(d.sentMessages, sentMessageKey(req))
+	d.saveSentMessages()
+}
+
+// warnUnknownSentMessage logs that a stream.offline event cannot edit or delete the go-live
+// message or post, because its ID is not known
+func (d *Dispatcher) warnUnknownSentMessage(req *DispatchRequest) {
+	d.logger.Warn("Go-live message ID is unknown, cannot edit or delete it on stream.offline",
+		"streamer_key", req.StreamerKey,
+		"target_name", req.TargetName)
+}
+
+// saveSentMessages writes the remembered IDs to the sent messages file, if there is one. The
+// caller must hold sentMessagesMutex.
+func (d *Dispatcher) saveSentMessages() {
+	if d.sentMessagesFile == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(d.sentMessages, "", "  ")
+	if err == nil {
+		err = atomicfile.WriteFile(d.sentMessagesFile, data)
+	}
+	if err != nil {
+		d.logger.Warn("Failed to save sent messages file", "path", d.sentMessagesFile, "error", err)
+	}
+}
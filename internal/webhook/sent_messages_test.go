@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSentMessagesSurviveRestart(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Write([]byte(`{"id":"109"}`))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "sent_messages.json")
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	req := &DispatchRequest{
+		WebhookURL:      server.URL,
+		Payload:         WebhookPayload{StreamerName: "Test Streamer", EventType: eventTypeStreamOnline},
+		StreamerKey:     "test_streamer",
+		TargetName:      "mastodon",
+		Format:          config.TargetFormatMastodon,
+		AccessToken:     "mastodon_token",
+		DeleteOnOffline: true,
+		Attempt:         1,
+	}
+
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+	require.NoError(t, dispatcher.LoadSentMessages(path))
+	require.True(t, dispatcher.Dispatch(context.Background(), req).Success)
+
+	// A new dispatcher, as after a restart, deletes the status
+	restarted := NewDispatcher(config.DefaultConfig(), logger)
+	require.NoError(t, restarted.LoadSentMessages(path))
+	offline := *req
+	offline.Payload.EventType = eventTypeStreamOffline
+	require.True(t, restarted.Dispatch(context.Background(), &offline).Success)
+	assert.Equal(t, []string{"POST /api/v1/statuses", "DELETE /api/v1/statuses/109"}, requests)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{}`, string(data))
+}
+
+func TestLoadSentMessagesRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sent_messages.json")
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0600))
+
+	dispatcher := NewDispatcher(config.DefaultConfig(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	assert.Error(t, dispatcher.LoadSentMessages(path))
+}
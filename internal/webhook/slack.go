@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SlackMessage is the body of a Slack incoming webhook using Block Kit
+type SlackMessage struct {
+	Text   string       `json:"text"` // fallback for notifications
+	Blocks []SlackBlock `json:"blocks"`
+}
+
+// SlackBlock is a Block Kit layout block
+type SlackBlock struct {
+	Type      string              `json:"type"`
+	Text      *SlackText          `json:"text,omitempty"`
+	Fields    []SlackText         `json:"fields,omitempty"`
+	Accessory *SlackImageElement  `json:"accessory,omitempty"`
+	Elements  []SlackContextEntry `json:"elements,omitempty"`
+}
+
+// SlackText is a Block Kit text object
+type SlackText struct {
+	Type string `json:"type"` // "mrkdwn" or "plain_text"
+	Text string `json:"text"`
+}
+
+// SlackImageElement is a Block Kit image element
+type SlackImageElement struct {
+	Type     string `json:"type"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+// SlackContextEntry is an element of a Block Kit context block
+type SlackContextEntry struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Slack Block Kit limits
+const (
+	slackSectionTextLimit = 3000
+	slackFieldTextLimit   = 2000
+)
+
+// newSlackMessage renders a webhook payload as a Slack Block Kit message
+func newSlackMessage(payload WebhookPayload) SlackMessage {
+	n := newNotification(payload)
+
+	text := fmt.Sprintf("*<%s|%s>*", n.URL, slackEscape(n.Title))
+	if n.Description != "" {
+		text += "\n" + slackEscape(n.Description)
+	}
+	section := SlackBlock{
+		Type: "section",
+		Text: &SlackText{Type: "mrkdwn", Text: truncate(text, slackSectionTextLimit)},
+	}
+	if n.ImageURL != "" {
+		section.Accessory = &SlackImageElement{Type: "image", ImageURL: n.ImageURL, AltText: payload.StreamerName}
+	}
+	blocks := []SlackBlock{section}
+
+	if len(n.Fields) > 0 {
+		fields := SlackBlock{Type: "section"}
+		for _, field := range n.Fields {
+			fields.Fields = append(fields.Fields, SlackText{
+				Type: "mrkdwn",
+				Text: truncate(fmt.Sprintf("*%s*\n%s", field.Name, slackEscape(field.Value)), slackFieldTextLimit),
+			})
+		}
+		blocks = append(blocks, fields)
+	}
+
+	blocks = append(blocks, SlackBlock{
+		Type: "context",
+		Elements: []SlackContextEntry{{
+			Type: "mrkdwn",
+			Text: fmt.Sprintf("Twitch · <!date^%d^{date_short_pretty} {time}|%s>", n.Timestamp.Unix(), n.Timestamp.Format("2006-01-02 15:04 MST")),
+		}},
+	})
+
+	return SlackMessage{Text: n.Title, Blocks: blocks}
+}
+
+// slackEscape escapes the characters Slack treats as control sequences in mrkdwn text
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
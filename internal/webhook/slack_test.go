@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchSlack(t *testing.T) {
+	var message SlackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &message))
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+
+	result := dispatcher.Dispatch(context.Background(), &DispatchRequest{
+		WebhookURL: server.URL,
+		Payload: WebhookPayload{
+			StreamerName: "Test Streamer",
+			URL:          "https://twitch.tv/teststreamer",
+			Title:        "Fish & <chips>",
+			CategoryName: "Just Chatting",
+			Image:        &ImageData{URL: "https://static-cdn.jtvnw.net/profile.png"},
+			EventType:    eventTypeStreamOnline,
+		},
+		Format:  config.TargetFormatSlack,
+		Attempt: 1,
+	})
+	require.True(t, result.Success, result.Error)
+
+	assert.Equal(t, "Test Streamer is live on Twitch", message.Text)
+	require.Len(t, message.Blocks, 3)
+	assert.Equal(t, "*<https://twitch.tv/teststreamer|Test Streamer is live on Twitch>*\nFish &amp; &lt;chips&gt;", message.Blocks[0].Text.Text)
+	assert.Equal(t, "https://static-cdn.jtvnw.net/profile.png", message.Blocks[0].Accessory.ImageURL)
+	assert.Equal(t, []SlackText{{Type: "mrkdwn", Text: "*Game*\nJust Chatting"}}, message.Blocks[1].Fields)
+	assert.Equal(t, "context", message.Blocks[2].Type)
+}
@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+)
+
+// Social post length limits
+const (
+	mastodonPostLimit = 500
+	blueskyPostLimit  = 300
+)
+
+// socialPostText returns the text of a go-live post: the rendered template of the target, or a
+// default announcement. Other events are not posted and have no text.
+func (d *Dispatcher) socialPostText(req *DispatchRequest) (string, error) {
+	if req.Payload.EventType != "" && req.Payload.EventType != eventTypeStreamOnline {
+		return "", nil
+	}
+
+	limit := mastodonPostLimit
+	if req.Format == config.TargetFormatBluesky {
+		limit = blueskyPostLimit
+	}
+
+	if req.Template != "" {
+		text, err := d.renderTemplate(req)
+		if err != nil {
+			return "", err
+		}
+		return truncate(strings.TrimSpace(string(text)), limit), nil
+	}
+
+	// Shorten the stream title rather than cutting off the link
+	announcement := fmt.Sprintf("%s is live on Twitch", req.Payload.StreamerName)
+	link := "\n\n" + req.Payload.URL
+	if title := req.Payload.Title; title != "" {
+		if available := limit - len([]rune(announcement+link)) - 2; available > 1 {
+			announcement += ": " + truncate(title, available)
+		}
+	}
+	return truncate(announcement+link, limit), nil
+}
+
+// skipSocialEvent returns the result of an event a social target does not post
+func (d *Dispatcher) skipSocialEvent(req *DispatchRequest) *DispatchResult {
+	d.logger.Debug("Social targets only announce go-live, skipping event",
+		"streamer_key", req.StreamerKey,
+		"target_name", req.TargetName,
+		"event_type", req.Payload.EventType)
+	return &DispatchResult{Success: true}
+}
+
+// callAPI sends a JSON request with additional headers to a social media API. The target's generic
+// headers, authorization and signature are not applied: the headers passed in carry the account's
+// own credentials.
+func (d *Dispatcher) callAPI(ctx context.Context, method, apiURL string, body interface{}, headers http.Header) (*DispatchResult, []byte) {
+	var bodyBytes []byte
+	if body != nil {
+		var err error
+		if bodyBytes, err = json.Marshal(body); err != nil {
+			return &DispatchResult{Success: false, Error: fmt.Sprintf("failed to marshal request: %v", err)}, nil
+		}
+	}
+
+	return d.send(ctx, method, apiURL, bodyBytes, "", credentials{}, headers)
+}
+
+// bearerAuth returns the header authenticating with a bearer token
+func bearerAuth(token string) http.Header {
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer "+token)
+	return headers
+}
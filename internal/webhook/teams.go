@@ -0,0 +1,102 @@
+package webhook
+
+// TeamsMessage is the body of a Microsoft Teams incoming webhook carrying an Adaptive Card
+type TeamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []TeamsAttachment `json:"attachments"`
+}
+
+// TeamsAttachment wraps an Adaptive Card in a Teams message
+type TeamsAttachment struct {
+	ContentType string       `json:"contentType"`
+	Content     AdaptiveCard `json:"content"`
+}
+
+// AdaptiveCard is an Adaptive Card
+type AdaptiveCard struct {
+	Schema  string                   `json:"$schema"`
+	Type    string                   `json:"type"`
+	Version string                   `json:"version"`
+	Body    []map[string]interface{} `json:"body"`
+	Actions []map[string]interface{} `json:"actions,omitempty"`
+}
+
+// Adaptive Card identifiers
+const (
+	adaptiveCardContentType = "application/vnd.microsoft.card.adaptive"
+	adaptiveCardSchema      = "http://adaptivecards.io/schemas/adaptive-card.json"
+	adaptiveCardVersion     = "1.4"
+)
+
+// newTeamsMessage renders a webhook payload as a Teams message with an Adaptive Card
+func newTeamsMessage(payload WebhookPayload) TeamsMessage {
+	n := newNotification(payload)
+
+	heading := []map[string]interface{}{{
+		"type":   "TextBlock",
+		"text":   n.Title,
+		"weight": "Bolder",
+		"size":   "Medium",
+		"wrap":   true,
+	}}
+	if n.Description != "" {
+		heading = append(heading, map[string]interface{}{
+			"type": "TextBlock",
+			"text": n.Description,
+			"wrap": true,
+		})
+	}
+
+	var body []map[string]interface{}
+	if n.ImageURL != "" {
+		// Profile image next to the heading
+		body = append(body, map[string]interface{}{
+			"type": "ColumnSet",
+			"columns": []map[string]interface{}{
+				{
+					"type":  "Column",
+					"width": "auto",
+					"items": []map[string]interface{}{{
+						"type":    "Image",
+						"url":     n.ImageURL,
+						"size":    "Small",
+						"style":   "Person",
+						"altText": payload.StreamerName,
+					}},
+				},
+				{
+					"type":  "Column",
+					"width": "stretch",
+					"items": heading,
+				},
+			},
+		})
+	} else {
+		body = append(body, heading...)
+	}
+
+	if len(n.Fields) > 0 {
+		facts := make([]map[string]interface{}, 0, len(n.Fields))
+		for _, field := range n.Fields {
+			facts = append(facts, map[string]interface{}{"title": field.Name, "value": field.Value})
+		}
+		body = append(body, map[string]interface{}{"type": "FactSet", "facts": facts})
+	}
+
+	card := AdaptiveCard{
+		Schema:  adaptiveCardSchema,
+		Type:    "AdaptiveCard",
+		Version: adaptiveCardVersion,
+		Body:    body,
+		Actions: []map[string]interface{}{{
+			"type":  "Action.OpenUrl",
+			"title": "Open on Twitch",
+			"url":   n.URL,
+		}},
+	}
+
+	return TeamsMessage{
+		Type:        "message",
+		Attachments: []TeamsAttachment{{ContentType: adaptiveCardContentType, Content: card}},
+	}
+}
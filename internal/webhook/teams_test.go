@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchTeams(t *testing.T) {
+	var message TeamsMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &message))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+
+	result := dispatcher.Dispatch(context.Background(), &DispatchRequest{
+		WebhookURL: server.URL,
+		Payload: WebhookPayload{
+			StreamerName:    "Test Streamer",
+			URL:             "https://twitch.tv/teststreamer",
+			EventType:       eventTypeStreamOffline,
+			DurationSeconds: 45 * 60,
+		},
+		Format:  config.TargetFormatTeams,
+		Attempt: 1,
+	})
+	require.True(t, result.Success, result.Error)
+
+	assert.Equal(t, "message", message.Type)
+	require.Len(t, message.Attachments, 1)
+	assert.Equal(t, adaptiveCardContentType, message.Attachments[0].ContentType)
+
+	card := message.Attachments[0].Content
+	assert.Equal(t, "AdaptiveCard", card.Type)
+	require.Len(t, card.Body, 2)
+	assert.Equal(t, "Test Streamer was live on Twitch", card.Body[0]["text"])
+	assert.Equal(t, "Streamed for 45m", card.Body[1]["text"])
+	assert.Equal(t, "https://twitch.tv/teststreamer", card.Actions[0]["url"])
+}
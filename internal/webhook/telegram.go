@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TelegramMessage is the body of a Telegram Bot API sendMessage or sendPhoto call
+type TelegramMessage struct {
+	ChatID    string `json:"chat_id"`
+	Text      string `json:"text,omitempty"`    // sendMessage
+	Photo     string `json:"photo,omitempty"`   // sendPhoto
+	Caption   string `json:"caption,omitempty"` // sendPhoto
+	ParseMode string `json:"parse_mode"`
+}
+
+// Telegram Bot API limits
+const (
+	telegramTextLimit    = 4096
+	telegramCaptionLimit = 1024
+)
+
+// newTelegramMessage renders a webhook payload as a MarkdownV2 Telegram message, sent as a photo
+// with caption when withPhoto is set and the payload has a profile image
+func newTelegramMessage(chatID string, payload WebhookPayload, withPhoto bool) TelegramMessage {
+	n := newNotification(payload)
+	message := TelegramMessage{ChatID: chatID, ParseMode: "MarkdownV2"}
+
+	limit := telegramTextLimit
+	if withPhoto && n.ImageURL != "" {
+		message.Photo = n.ImageURL
+		limit = telegramCaptionLimit
+	}
+
+	// Limits apply to the visible text, so the description is shortened before escaping
+	link := fmt.Sprintf("[Watch on Twitch](%s)", telegramEscapeURL(n.URL))
+	lines := []string{"*" + telegramEscape(n.Title) + "*"}
+	visible := len([]rune(n.Title)) + len("Watch on Twitch") + 2
+	for _, field := range n.Fields {
+		lines = append(lines, fmt.Sprintf("_%s:_ %s", telegramEscape(field.Name), telegramEscape(field.Value)))
+		visible += len([]rune(field.Name)) + len([]rune(field.Value)) + 3
+	}
+	if n.Description != "" && visible < limit-1 {
+		description := telegramEscape(truncate(n.Description, limit-visible-1))
+		lines = append(lines[:1], append([]string{description}, lines[1:]...)...)
+	}
+	lines = append(lines, link)
+
+	text := strings.Join(lines, "\n")
+	if message.Photo != "" {
+		message.Caption = text
+	} else {
+		message.Text = text
+	}
+	return message
+}
+
+// telegramEscape escapes text for Telegram's MarkdownV2 parse mode
+func telegramEscape(text string) string {
+	var escaped strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune("_*[]()~`>#+-=|{}.!\\", r) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// telegramEscapeURL escapes the URL of a MarkdownV2 inline link
+func telegramEscapeURL(url string) string {
+	return strings.NewReplacer(`\`, `\\`, `)`, `\)`).Replace(url)
+}
+
+// sendTelegram calls sendPhoto or sendMessage of the Bot API. If Telegram cannot use the profile
+// image, the message is sent again without it.
+func (d *Dispatcher) sendTelegram(ctx context.Context, req *DispatchRequest, body []byte) *DispatchResult {
+	method := "sendMessage"
+	withPhoto := req.Payload.Image != nil && req.Payload.Image.URL != ""
+	if withPhoto {
+		method = "sendPhoto"
+	}
+
+	result, respBody := d.callTelegram(ctx, req, method, body)
+	if withPhoto && result.StatusCode == http.StatusBadRequest {
+		d.logger.Warn("Telegram rejected the profile image, sending the message without it",
+			"streamer_key", req.StreamerKey,
+			"target_name", req.TargetName,
+			"error", result.Error)
+
+		textBody, err := json.Marshal(newTelegramMessage(req.ChatID, req.Payload, false))
+		if err != nil {
+			return &DispatchResult{Success: false, Error: fmt.Sprintf("failed to marshal telegram message: %v", err)}
+		}
+		result, respBody = d.callTelegram(ctx, req, "sendMessage", textBody)
+	}
+
+	if !result.Success {
+		var apiError struct {
+			Description string `json:"description"`
+			Parameters  struct {
+				RetryAfter int `json:"retry_after"` // seconds
+			} `json:"parameters"`
+		}
+		if err := json.Unmarshal(respBody, &apiError); err == nil {
+			if apiError.Description != "" {
+				result.Error = fmt.Sprintf("HTTP %d: %s", result.StatusCode, apiError.Description)
+			}
+			if result.StatusCode == http.StatusTooManyRequests && apiError.Parameters.RetryAfter > 0 {
+				result.RetryAfter = time.Duration(apiError.Parameters.RetryAfter) * time.Second
+			}
+		}
+	}
+
+	return result
+}
+
+// callTelegram calls a Bot API method, keeping the bot token out of reported errors
+func (d *Dispatcher) callTelegram(ctx context.Context, req *DispatchRequest, method string, body []byte) (*DispatchResult, []byte) {
+	methodURL := fmt.Sprintf("%s/bot%s/%s", strings.TrimSuffix(req.WebhookURL, "/"), req.BotToken, method)
+
+	result, respBody := d.send(ctx, http.MethodPost, methodURL, body, "", req.credentials(), nil)
+	if req.BotToken != "" {
+		result.Error = strings.ReplaceAll(result.Error, req.BotToken, "<bot_token>")
+	}
+	return result, respBody
+}
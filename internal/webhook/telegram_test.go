@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTelegramEscape(t *testing.T) {
+	assert.Equal(t, `Fish & chips? 1\_2 \*bold\* \(v2\.0\)\!`, telegramEscape("Fish & chips? 1_2 *bold* (v2.0)!"))
+	assert.Equal(t, `https://example.com/a\)b`, telegramEscapeURL("https://example.com/a)b"))
+}
+
+func TestDispatchTelegramFallsBackToText(t *testing.T) {
+	var methods []string
+	var message TelegramMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		message = TelegramMessage{}
+		require.NoError(t, json.Unmarshal(body, &message))
+
+		if message.Photo != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: wrong file identifier/HTTP URL specified"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+
+	result := dispatcher.Dispatch(context.Background(), &DispatchRequest{
+		WebhookURL: server.URL,
+		Payload: WebhookPayload{
+			StreamerName: "test_streamer",
+			URL:          "https://twitch.tv/test_streamer",
+			Title:        "v2.0 release!",
+			Image:        &ImageData{URL: "https://static-cdn.jtvnw.net/profile.png"},
+			EventType:    eventTypeStreamOnline,
+		},
+		Format:   config.TargetFormatTelegram,
+		ChatID:   "-100123",
+		BotToken: "123:secret",
+		Attempt:  1,
+	})
+	require.True(t, result.Success, result.Error)
+
+	assert.Equal(t, []string{"/bot123:secret/sendPhoto", "/bot123:secret/sendMessage"}, methods)
+	assert.Equal(t, "-100123", message.ChatID)
+	assert.Equal(t, "MarkdownV2", message.ParseMode)
+	assert.Equal(t, "*test\\_streamer is live on Twitch*\nv2\\.0 release\\!\n[Watch on Twitch](https://twitch.tv/test_streamer)", message.Text)
+}
+
+func TestDispatchTelegramRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 7","parameters":{"retry_after":7}}`))
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dispatcher := NewDispatcher(config.DefaultConfig(), logger)
+
+	result := dispatcher.Dispatch(context.Background(), &DispatchRequest{
+		WebhookURL: server.URL,
+		Payload:    WebhookPayload{StreamerName: "Test Streamer"},
+		Format:     config.TargetFormatTelegram,
+		ChatID:     "-100123",
+		BotToken:   "123:secret",
+		Attempt:    1,
+	})
+
+	assert.False(t, result.Success)
+	assert.Equal(t, 7*time.Second, result.RetryAfter)
+	assert.Equal(t, "HTTP 429: Too Many Requests: retry after 7", result.Error)
+}
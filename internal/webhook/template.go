@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+)
+
+// TemplateData is the data available to outgoing webhook payload templates
+type TemplateData struct {
+	Payload     WebhookPayload        // event and enrichment data, as sent in the default JSON body
+	Streamer    config.StreamerConfig // configuration of the streamer the event belongs to
+	StreamerKey string
+	Target      string
+}
+
+// parsedTemplates caches parsed payload templates by name and text, so that a template is parsed
+// once instead of on every dispatch
+var parsedTemplates sync.Map
+
+// parsePayloadTemplate returns the parsed payload template, parsing it on first use
+func parsePayloadTemplate(name, text string) (*template.Template, error) {
+	key := name + "\x00" + text
+	if tmpl, ok := parsedTemplates.Load(key); ok {
+		return tmpl.(*template.Template), nil
+	}
+
+	tmpl, err := config.ParsePayloadTemplate(name, text)
+	if err != nil {
+		return nil, err
+	}
+	parsedTemplates.Store(key, tmpl)
+	return tmpl, nil
+}
+
+// RenderPayloadTemplate renders a payload template into a request body
+func RenderPayloadTemplate(name, text string, data TemplateData) ([]byte, error) {
+	tmpl, err := parsePayloadTemplate(name, text)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// samplePayloads returns a sample payload of every event type, with the optional fields left
+// empty that are missing at runtime: Image when enrichment fails, StartedAt on channel.update
+func samplePayloads(streamer config.StreamerConfig) []WebhookPayload {
+	now := time.Now().UTC()
+	base := WebhookPayload{
+		StreamerLogin: streamer.Login,
+		StreamerName:  streamer.Login,
+		StreamerID:    streamer.UserID,
+		URL:           fmt.Sprintf("https://twitch.tv/%s", streamer.Login),
+		Timestamp:     now,
+	}
+
+	online := base
+	online.EventType = eventTypeStreamOnline
+	online.StartedAt = &now
+
+	offline := base
+	offline.EventType = eventTypeStreamOffline
+	offline.StartedAt = &now
+	offline.EndedAt = &now
+
+	update := base
+	update.EventType = eventTypeChannelUpdate
+	update.Changes = []string{"title_changed"}
+
+	return []WebhookPayload{online, offline, update}
+}
+
+// validatePayloadTemplate renders a template against every event it can be rendered for
+func validatePayloadTemplate(name, text, format, key string, streamer config.StreamerConfig) error {
+	if _, err := parsePayloadTemplate(name, text); err != nil {
+		return err
+	}
+
+	for _, payload := range samplePayloads(streamer) {
+		if config.IsSocialTargetFormat(format) && payload.EventType != eventTypeStreamOnline {
+			// Social posts are only rendered for go-live events
+			continue
+		}
+		data := TemplateData{Payload: payload, Streamer: streamer, StreamerKey: key, Target: name}
+		if _, err := RenderPayloadTemplate(name, text, data); err != nil {
+			return fmt.Errorf("%s event: %w", payload.EventType, err)
+		}
+	}
+	return nil
+}
+
+// ValidatePayloadTemplates renders every configured payload template against a sample of each
+// event type, catching references to unknown fields and to optional fields that may be missing,
+// which parsing alone does not detect
+func ValidatePayloadTemplates(cfg *config.Config) error {
+	if cfg.GlobalWebhook.Template != "" {
+		if err := validatePayloadTemplate(config.GlobalTargetName, cfg.GlobalWebhook.Template, "", "", config.StreamerConfig{}); err != nil {
+			return fmt.Errorf("global_webhook.template is invalid: %w", err)
+		}
+	}
+
+	for key, streamer := range cfg.Streamers {
+		for _, target := range streamer.Targets {
+			if target.Template == "" {
+				continue
+			}
+			if err := validatePayloadTemplate(target.Name, target.Template, target.Format, key, streamer); err != nil {
+				return fmt.Errorf("streamers.%s.targets.%s.template is invalid: %w", key, target.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
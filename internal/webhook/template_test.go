@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/rmoriz/itsjustintv/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePayloadTemplates(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.GlobalWebhook.Template = `{"content":{{ json .Payload.StreamerLogin }}}`
+	cfg.Streamers["test_streamer"] = config.StreamerConfig{
+		Login: "teststreamer",
+		Targets: []config.WebhookTargetConfig{
+			{Name: "cms", URL: "https://cms.example.com", Template: `{{ .Payload.Title }} {{ with .Payload.Image }}{{ .URL }}{{ end }} {{ .Streamer.Login }}`},
+		},
+	}
+	require.NoError(t, ValidatePayloadTemplates(cfg))
+
+	cfg.Streamers["test_streamer"] = config.StreamerConfig{
+		Targets: []config.WebhookTargetConfig{
+			{Name: "cms", URL: "https://cms.example.com", Template: `{{ .Payload.Titel }}`},
+		},
+	}
+	err := ValidatePayloadTemplates(cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "streamers.test_streamer.targets.cms.template is invalid")
+}
+
+func TestValidatePayloadTemplatesOptionalFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		template string
+		valid    bool
+	}{
+		{"image without check", "", `{{ .Payload.Image.URL }}`, false},
+		{"started_at without check", "", `{{ .Payload.StartedAt.Format "15:04" }}`, false},
+		{"started_at with check", "", `{{ with .Payload.StartedAt }}{{ .Format "15:04" }}{{ end }}`, true},
+		{"started_at in go-live post", config.TargetFormatMastodon, `Live since {{ .Payload.StartedAt.Format "15:04" }}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Streamers["test_streamer"] = config.StreamerConfig{
+				Login:   "teststreamer",
+				Targets: []config.WebhookTargetConfig{{Name: "cms", URL: "https://cms.example.com", Format: tt.format, Template: tt.template}},
+			}
+
+			err := ValidatePayloadTemplates(cfg)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
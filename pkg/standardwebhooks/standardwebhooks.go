@@ -0,0 +1,127 @@
+// Package standardwebhooks signs and verifies webhooks according to the Standard Webhooks
+// specification (https://www.standardwebhooks.com). Receivers of itsjustintv webhooks can import
+// it to verify deliveries of targets with signing_scheme = "standard-webhooks".
+package standardwebhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Standard Webhooks headers
+const (
+	HeaderID        = "webhook-id"
+	HeaderTimestamp = "webhook-timestamp"
+	HeaderSignature = "webhook-signature"
+)
+
+// SecretPrefix marks base64 encoded secrets
+const SecretPrefix = "whsec_"
+
+// signatureVersion is the version tag of HMAC-SHA256 signatures
+const signatureVersion = "v1"
+
+// DefaultTolerance is how far a message timestamp may be from the current time
+const DefaultTolerance = 5 * time.Minute
+
+// Verification errors
+var (
+	ErrMissingHeaders      = errors.New("missing webhook-id, webhook-timestamp or webhook-signature header")
+	ErrInvalidTimestamp    = errors.New("invalid webhook-timestamp header")
+	ErrTimestampOutOfRange = errors.New("webhook-timestamp is outside the tolerance")
+	ErrInvalidSignature    = errors.New("no matching signature")
+)
+
+// DecodeSecret returns the signing key of a secret. Secrets with the "whsec_" prefix are base64
+// encoded; other secrets are used as is.
+func DecodeSecret(secret string) ([]byte, error) {
+	if secret == "" {
+		return nil, errors.New("secret is empty")
+	}
+	if !strings.HasPrefix(secret, SecretPrefix) {
+		return []byte(secret), nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, SecretPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 in %s secret: %w", SecretPrefix, err)
+	}
+	return key, nil
+}
+
+// Sign returns the "v1,<base64>" signature of a message for one secret
+func Sign(secret, msgID string, timestamp time.Time, body []byte) (string, error) {
+	key, err := DecodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s.%d.", msgID, timestamp.Unix())
+	mac.Write(body)
+	return signatureVersion + "," + base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// SignHeaders sets the Standard Webhooks headers of a message, signing it with every secret so
+// receivers accept it while secrets are rotated
+func SignHeaders(header http.Header, secrets []string, msgID string, timestamp time.Time, body []byte) error {
+	signatures := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		signature, err := Sign(secret, msgID, timestamp, body)
+		if err != nil {
+			return err
+		}
+		signatures = append(signatures, signature)
+	}
+
+	header.Set(HeaderID, msgID)
+	header.Set(HeaderTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+	header.Set(HeaderSignature, strings.Join(signatures, " "))
+	return nil
+}
+
+// Verify verifies the Standard Webhooks headers of a received message against any of the given
+// secrets, rejecting timestamps more than DefaultTolerance away from now
+func Verify(header http.Header, body []byte, secrets ...string) error {
+	return VerifyAt(header, body, time.Now(), DefaultTolerance, secrets...)
+}
+
+// VerifyAt verifies the Standard Webhooks headers of a message as of the given time
+func VerifyAt(header http.Header, body []byte, now time.Time, tolerance time.Duration, secrets ...string) error {
+	msgID := header.Get(HeaderID)
+	timestampHeader := header.Get(HeaderTimestamp)
+	signatureHeader := header.Get(HeaderSignature)
+	if msgID == "" || timestampHeader == "" || signatureHeader == "" {
+		return ErrMissingHeaders
+	}
+
+	seconds, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	timestamp := time.Unix(seconds, 0)
+	if now.Sub(timestamp) > tolerance || timestamp.Sub(now) > tolerance {
+		return ErrTimestampOutOfRange
+	}
+
+	for _, secret := range secrets {
+		expected, err := Sign(secret, msgID, timestamp, body)
+		if err != nil {
+			return err
+		}
+		for _, signature := range strings.Fields(signatureHeader) {
+			if hmac.Equal([]byte(signature), []byte(expected)) {
+				return nil
+			}
+		}
+	}
+
+	return ErrInvalidSignature
+}
@@ -0,0 +1,73 @@
+package standardwebhooks
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	// Test vector from the Standard Webhooks reference implementations
+	signature, err := Sign("whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw", "msg_p5jXN8AQM9LWM0D4loKWxJek", time.Unix(1614265330, 0), []byte(`{"test": 2432232314}`))
+	require.NoError(t, err)
+	assert.Equal(t, "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE=", signature)
+}
+
+func TestDecodeSecret(t *testing.T) {
+	key, err := DecodeSecret("whsec_c2VjcmV0")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), key)
+
+	key, err = DecodeSecret("plain_secret")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("plain_secret"), key)
+
+	_, err = DecodeSecret("whsec_not base64")
+	assert.Error(t, err)
+
+	_, err = DecodeSecret("")
+	assert.Error(t, err)
+}
+
+func TestSignHeadersAndVerify(t *testing.T) {
+	body := []byte(`{"streamer_login":"teststreamer"}`)
+	now := time.Now()
+
+	header := http.Header{}
+	require.NoError(t, SignHeaders(header, []string{"new_secret", "old_secret"}, "msg_1", now, body))
+
+	assert.Equal(t, "msg_1", header.Get(HeaderID))
+	assert.Len(t, strings.Fields(header.Get(HeaderSignature)), 2)
+
+	assert.NoError(t, Verify(header, body, "new_secret"))
+	assert.NoError(t, Verify(header, body, "old_secret"))
+	assert.NoError(t, Verify(header, body, "unrelated", "old_secret"))
+	assert.ErrorIs(t, Verify(header, body, "unrelated"), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify(header, []byte(`{"streamer_login":"other"}`), "new_secret"), ErrInvalidSignature)
+}
+
+func TestVerifyRejectsReplays(t *testing.T) {
+	body := []byte(`{}`)
+	sent := time.Now().Add(-10 * time.Minute)
+
+	header := http.Header{}
+	require.NoError(t, SignHeaders(header, []string{"secret"}, "msg_1", sent, body))
+
+	assert.ErrorIs(t, Verify(header, body, "secret"), ErrTimestampOutOfRange)
+	assert.NoError(t, VerifyAt(header, body, sent.Add(time.Minute), DefaultTolerance, "secret"))
+	assert.ErrorIs(t, VerifyAt(header, body, sent.Add(-10*time.Minute), DefaultTolerance, "secret"), ErrTimestampOutOfRange)
+}
+
+func TestVerifyInvalidHeaders(t *testing.T) {
+	assert.ErrorIs(t, Verify(http.Header{}, nil, "secret"), ErrMissingHeaders)
+
+	header := http.Header{}
+	header.Set(HeaderID, "msg_1")
+	header.Set(HeaderTimestamp, "yesterday")
+	header.Set(HeaderSignature, "v1,abc")
+	assert.ErrorIs(t, Verify(header, nil, "secret"), ErrInvalidTimestamp)
+}